@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"net"
+	"sort"
+
+	"github.com/mistletoeChao/g53"
+)
+
+const DefaultMaxScopes = 16
+
+// scopeOf returns the subnet which the answer of resp is valid for as
+// rfc7871, nil means the answer is valid for all clients. Scope longer than
+// the source prefix is reduced to the source prefix
+func scopeOf(resp *g53.Message) *net.IPNet {
+	if resp.Edns == nil {
+		return nil
+	}
+
+	subnet := resp.Edns.SubnetOpt()
+	if subnet == nil || subnet.ScopePrefix() == 0 {
+		return nil
+	}
+
+	prefix := subnet.ScopePrefix()
+	if prefix > subnet.SourcePrefix() {
+		prefix = subnet.SourcePrefix()
+	}
+	return subnet.Network(prefix)
+}
+
+func scopedKey(k key, subnet *net.IPNet) key {
+	k.subnet = subnet.String()
+	return k
+}
+
+func prefixLen(subnet *net.IPNet) int {
+	ones, _ := subnet.Mask.Size()
+	return ones
+}
+
+// hostSubnet returns the subnet which only has ip
+func hostSubnet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	if ip = ip.To16(); ip == nil {
+		return nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// scopeMatch checks whether client belongs to the cached scope as rfc7871
+// 7.3.1, the shorter of the scope prefix and the client source prefix is
+// compared
+func scopeMatch(scope, client *net.IPNet) bool {
+	_, bits := scope.Mask.Size()
+	if _, clientBits := client.Mask.Size(); clientBits != bits {
+		return false
+	}
+
+	prefix := prefixLen(scope)
+	if p := prefixLen(client); p < prefix {
+		prefix = p
+	}
+	mask := net.CIDRMask(prefix, bits)
+	return scope.IP.Mask(mask).Equal(client.IP.Mask(mask))
+}
+
+// called with lock held, the variant added earliest is evicted when the
+// rrset already has MaxScopes variants
+func (c *RRsetCache) addScope(entry *rrsetEntry) {
+	k := entry.key
+	k.subnet = ""
+	maxScopes := c.MaxScopes
+	if maxScopes <= 0 {
+		maxScopes = DefaultMaxScopes
+	}
+
+	for len(c.scopes[k]) >= maxScopes {
+		elem := c.entries[scopedKey(k, c.scopes[k][0])]
+		c.removeElement(elem)
+	}
+	c.scopes[k] = append(c.scopes[k], entry.subnet)
+}
+
+// called with lock held
+func (c *RRsetCache) removeScope(entry *rrsetEntry) {
+	k := entry.key
+	k.subnet = ""
+	var subnets []*net.IPNet
+	for _, subnet := range c.scopes[k] {
+		if subnet.String() != entry.key.subnet {
+			subnets = append(subnets, subnet)
+		}
+	}
+
+	if len(subnets) == 0 {
+		delete(c.scopes, k)
+	} else {
+		c.scopes[k] = subnets
+	}
+}
+
+// called with lock held, the entry of the most specific subnet matching
+// client is returned, the unscoped entry is used if no subnet matches
+func (c *RRsetCache) find(k key, client *net.IPNet, stale bool) (*rrsetEntry, g53.RRTTL) {
+	if client != nil {
+		var subnets []*net.IPNet
+		for _, subnet := range c.scopes[k] {
+			if scopeMatch(subnet, client) {
+				subnets = append(subnets, subnet)
+			}
+		}
+		sort.Slice(subnets, func(i, j int) bool {
+			return prefixLen(subnets[i]) > prefixLen(subnets[j])
+		})
+
+		for _, subnet := range subnets {
+			if e, ttl := c.getEntry(scopedKey(k, subnet), stale); e != nil {
+				return e, ttl
+			}
+		}
+	}
+	return c.getEntry(k, stale)
+}
+
+// LookupSubnet is same as Lookup except that answers scoped by client subnet
+// are used if client ip belongs to the subnet
+func (c *RRsetCache) LookupSubnet(name *g53.Name, typ g53.RRType, class g53.RRClass, client net.IP) *g53.Message {
+	resp, _ := c.lookup(name, typ, class, hostSubnet(client), false)
+	return resp
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/server"
+)
+
+func scopedResponse(name, client string, source, scope uint8, ttl int, addr string) *g53.Message {
+	n, _ := g53.NameFromString(name)
+	resp := g53.MakeQuery(n, g53.RR_A, 4096, false).MakeResponse()
+	resp.Edns = &g53.EDNS{UdpSize: 4096}
+	if client != "" {
+		subnet := g53.NewSubnetOpt(net.ParseIP(client), source)
+		subnet.SetScopePrefix(scope)
+		resp.Edns.Options = []g53.Option{subnet}
+	}
+	resp.AddRRset(g53.AnswerSection, g53.BuildRRset(name, g53.RR_A, ttl, addr))
+	return resp
+}
+
+func lookupSubnet(c *RRsetCache, name, client string) string {
+	n, _ := g53.NameFromString(name)
+	resp := c.LookupSubnet(n, g53.RR_A, g53.CLASS_IN, net.ParseIP(client))
+	if resp == nil {
+		return ""
+	}
+	return resp.Sections[g53.AnswerSection][0].Rdatas[0].String()
+}
+
+func TestScopedCache(t *testing.T) {
+	clock := newFakeClock()
+	c := NewRRsetCache(0)
+	c.now = clock.Now
+
+	c.AddMessage(scopedResponse("www.example.com.", "", 0, 0, 300, "9.9.9.9"))
+	c.AddMessage(scopedResponse("www.example.com.", "10.1.1.1", 24, 16, 300, "1.1.1.1"))
+	c.AddMessage(scopedResponse("www.example.com.", "10.1.2.1", 24, 24, 60, "2.2.2.2"))
+	//scope longer than source prefix is reduced to source prefix
+	c.AddMessage(scopedResponse("www.example.com.", "10.1.3.1", 24, 28, 300, "3.3.3.3"))
+	//zero scope is valid for all clients
+	c.AddMessage(scopedResponse("ftp.example.com.", "10.1.1.1", 24, 0, 300, "4.4.4.4"))
+
+	g53.Equal(t, lookupSubnet(c, "www.example.com.", "10.1.2.3"), "2.2.2.2")
+	g53.Equal(t, lookupSubnet(c, "www.example.com.", "10.1.3.200"), "3.3.3.3")
+	g53.Equal(t, lookupSubnet(c, "www.example.com.", "10.1.4.3"), "1.1.1.1")
+	g53.Equal(t, lookupSubnet(c, "www.example.com.", "10.2.0.1"), "9.9.9.9")
+	g53.Equal(t, lookupSubnet(c, "ftp.example.com.", "192.168.0.1"), "4.4.4.4")
+	n, _ := g53.NameFromString("www.example.com.")
+	g53.Equal(t, c.Lookup(n, g53.RR_A, g53.CLASS_IN).Sections[g53.AnswerSection][0].Rdatas[0].String(), "9.9.9.9")
+
+	//expired subnet falls back to the less specific one
+	clock.Advance(100 * time.Second)
+	g53.Equal(t, lookupSubnet(c, "www.example.com.", "10.1.2.3"), "1.1.1.1")
+}
+
+func TestScopedCacheLimit(t *testing.T) {
+	c := NewRRsetCache(0)
+	c.now = newFakeClock().Now
+	c.MaxScopes = 2
+
+	c.AddMessage(scopedResponse("www.example.com.", "10.1.1.1", 24, 24, 300, "1.1.1.1"))
+	c.AddMessage(scopedResponse("www.example.com.", "10.1.2.1", 24, 24, 300, "2.2.2.2"))
+	c.AddMessage(scopedResponse("www.example.com.", "10.1.3.1", 24, 24, 300, "3.3.3.3"))
+	//refreshing a subnet doesn't add another variant
+	c.AddMessage(scopedResponse("www.example.com.", "10.1.3.1", 24, 24, 300, "3.3.3.4"))
+	g53.Equal(t, c.Len(), 2)
+	g53.Equal(t, lookupSubnet(c, "www.example.com.", "10.1.1.1"), "")
+	g53.Equal(t, lookupSubnet(c, "www.example.com.", "10.1.2.1"), "2.2.2.2")
+	g53.Equal(t, lookupSubnet(c, "www.example.com.", "10.1.3.1"), "3.3.3.4")
+}
+
+func TestHandlerScopedCache(t *testing.T) {
+	queries := 0
+	upstream := server.HandlerFunc(func(w server.ResponseWriter, req *g53.Message) {
+		queries += 1
+		subnet := req.Edns.SubnetOpt()
+		resp := scopedResponse(req.Question.Name.String(false), subnet.IP().String(), subnet.SourcePrefix(), 16, 300, "1.1.1.1")
+		resp.Header.Id = req.Header.Id
+		w.Write(resp)
+	})
+	c := NewRRsetCache(0)
+	c.now = newFakeClock().Now
+	h := NewHandler(c, upstream)
+
+	serveSubnet := func(client string) *g53.Message {
+		n, _ := g53.NameFromString("www.example.com.")
+		req := g53.MakeQuery(n, g53.RR_A, 4096, false)
+		req.Edns.Options = []g53.Option{g53.NewSubnetOpt(net.ParseIP(client), 24)}
+		w := &recordWriter{}
+		h.ServeDNS(w, req)
+		return w.resp
+	}
+
+	serveSubnet("10.1.1.1")
+	resp := serveSubnet("10.1.2.1")
+	g53.Equal(t, queries, 1)
+	subnet := resp.Edns.SubnetOpt()
+	g53.Equal(t, subnet.IP().String(), "10.1.2.0")
+	g53.Equal(t, subnet.SourcePrefix(), uint8(24))
+	g53.Equal(t, subnet.ScopePrefix(), uint8(16))
+
+	serveSubnet("10.2.1.1")
+	g53.Equal(t, queries, 2)
+}
+
+func TestHandlerShortSourcePrefix(t *testing.T) {
+	queries := 0
+	upstream := server.HandlerFunc(func(w server.ResponseWriter, req *g53.Message) {
+		queries += 1
+		subnet := req.Edns.SubnetOpt()
+		resp := scopedResponse(req.Question.Name.String(false), subnet.IP().String(), subnet.SourcePrefix(), 24, 300, "1.1.1.1")
+		resp.Header.Id = req.Header.Id
+		w.Write(resp)
+	})
+	c := NewRRsetCache(0)
+	c.now = newFakeClock().Now
+	h := NewHandler(c, upstream)
+
+	serveSubnet := func(client string, source uint8) {
+		n, _ := g53.NameFromString("www.example.com.")
+		req := g53.MakeQuery(n, g53.RR_A, 4096, false)
+		req.Edns.Options = []g53.Option{g53.NewSubnetOpt(net.ParseIP(client), source)}
+		h.ServeDNS(&recordWriter{}, req)
+	}
+
+	serveSubnet("10.1.2.1", 24)
+	g53.Equal(t, queries, 1)
+	//source prefix shorter than the cached scope is compared on its own length
+	serveSubnet("10.1.0.0", 16)
+	g53.Equal(t, queries, 1)
+	serveSubnet("10.2.0.0", 16)
+	g53.Equal(t, queries, 2)
+	serveSubnet("10.1.5.1", 24)
+	g53.Equal(t, queries, 3)
+}
@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/server"
+)
+
+const (
+	DefaultPrefetchHits  = 3
+	DefaultPrefetchRatio = 0.1
+	// client response timer recommended by rfc8767
+	DefaultStaleAnswerTimeout = 1800 * time.Millisecond
+	EdnsUDPSize               = 1232
+)
+
+// Handler answers queries from cache and passes the misses to Next, whose
+// responses are cached. Entry which has been looked up PrefetchHits times is
+// refreshed in background once its remaining ttl drops below PrefetchRatio
+// of the original ttl, there is at most one refresh for each question. The
+// refresh query is marked with FLAG_FETCH, which bypasses cache lookup so
+// clients may use it to refresh an entry as well.
+//
+// With MaxStale of Cache set, stale data is answered as rfc8767 if Next
+// returns SERVFAIL or doesn't answer in StaleAnswerTimeout, in the latter
+// case the resolution goes on in background to refresh the cache.
+//
+// Answer scoped by client subnet is only used for clients in the subnet,
+// client is identified by the client subnet option of query or its source
+// address
+type Handler struct {
+	Cache              *RRsetCache
+	Next               server.Handler
+	PrefetchHits       uint64
+	PrefetchRatio      float64
+	StaleAnswerTimeout time.Duration
+
+	lock       sync.Mutex
+	fetching   map[key]struct{}
+	background sync.WaitGroup
+}
+
+func NewHandler(cache *RRsetCache, next server.Handler) *Handler {
+	return &Handler{
+		Cache:              cache,
+		Next:               next,
+		PrefetchHits:       DefaultPrefetchHits,
+		PrefetchRatio:      DefaultPrefetchRatio,
+		StaleAnswerTimeout: DefaultStaleAnswerTimeout,
+		fetching:           make(map[key]struct{}),
+	}
+}
+
+func (h *Handler) staleAnswerTimeout() time.Duration {
+	if h.StaleAnswerTimeout <= 0 {
+		return DefaultStaleAnswerTimeout
+	}
+	return h.StaleAnswerTimeout
+}
+
+func (h *Handler) ServeDNS(w server.ResponseWriter, req *g53.Message) {
+	if req.Header.Opcode != g53.OP_QUERY || req.Question == nil {
+		h.Next.ServeDNS(w, req)
+		return
+	}
+
+	q := req.Question
+	if req.Header.GetFlag(g53.FLAG_FETCH) == false {
+		if cached, u := h.Cache.lookup(q.Name, q.Type, q.Class, clientSubnet(w, req), false); cached != nil {
+			if h.isPrefetchable(u) {
+				h.prefetch(w, req)
+			}
+			w.Write(makeResponse(req, cached, u.scope))
+			return
+		}
+	}
+
+	if h.Cache.MaxStale <= 0 {
+		h.resolve(server.NewCaptureWriter(w), req).Flush()
+		return
+	}
+	h.serveStale(w, req)
+}
+
+func (h *Handler) serveStale(w server.ResponseWriter, req *g53.Message) {
+	cw := server.NewCaptureWriter(w)
+	done := make(chan *server.CaptureWriter, 1)
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		done <- h.resolve(cw, req)
+	}()
+
+	timer := time.NewTimer(h.staleAnswerTimeout())
+	defer timer.Stop()
+	select {
+	case cw = <-done:
+		if cw.Response == nil || cw.Response.Header.Rcode == g53.R_SERVFAIL {
+			if h.writeStale(w, req) {
+				return
+			}
+		}
+	case <-timer.C:
+		if h.writeStale(w, req) {
+			return
+		}
+		cw = <-done
+	}
+	cw.Flush()
+}
+
+// writeStale answers req with stale data, extended error is added if req
+// has edns, false is returned if there is no stale data
+func (h *Handler) writeStale(w server.ResponseWriter, req *g53.Message) bool {
+	q := req.Question
+	cached, u := h.Cache.lookup(q.Name, q.Type, q.Class, clientSubnet(w, req), true)
+	if cached == nil {
+		return false
+	}
+
+	resp := makeResponse(req, cached, u.scope)
+	if resp.Edns != nil {
+		resp.Edns.Options = append(resp.Edns.Options, &g53.ExtendedErrorOpt{InfoCode: g53.EDE_STALE_ANSWER})
+	}
+	w.Write(resp)
+	return true
+}
+
+func (h *Handler) isPrefetchable(u usage) bool {
+	if h.PrefetchRatio <= 0 || u.hits < h.PrefetchHits {
+		return false
+	}
+	return float64(u.remaining) <= float64(u.ttl)*h.PrefetchRatio
+}
+
+// prefetch refreshes the answer of req in background unless it is being
+// refreshed
+func (h *Handler) prefetch(w server.ResponseWriter, req *g53.Message) {
+	k := makeKey(req.Question.Name, req.Question.Type, req.Question.Class)
+	h.lock.Lock()
+	if h.fetching == nil {
+		h.fetching = make(map[key]struct{})
+	}
+	if _, ok := h.fetching[k]; ok {
+		h.lock.Unlock()
+		return
+	}
+	h.fetching[k] = struct{}{}
+	h.background.Add(1)
+	h.lock.Unlock()
+
+	header := *req.Header
+	header.SetFlag(g53.FLAG_FETCH, true)
+	fetch := &g53.Message{
+		Header:   &header,
+		Question: req.Question,
+		Edns:     req.Edns,
+	}
+	fw := server.NewCaptureWriter(w)
+	go func() {
+		defer func() {
+			h.lock.Lock()
+			delete(h.fetching, k)
+			h.lock.Unlock()
+			h.background.Done()
+		}()
+		h.resolve(fw, fetch)
+	}()
+}
+
+// resolve passes req to Next and caches the successful response
+func (h *Handler) resolve(cw *server.CaptureWriter, req *g53.Message) *server.CaptureWriter {
+	h.Next.ServeDNS(cw, req)
+	if resp := cw.Response; resp != nil && resp.Header.GetFlag(g53.FLAG_TC) == false {
+		switch resp.Header.Rcode {
+		case g53.R_NOERROR, g53.R_NXDOMAIN:
+			h.Cache.AddMessage(resp)
+		}
+	}
+	return cw
+}
+
+// clientSubnet is the subnet in client subnet option of req, or the source
+// address of req if it has no such option, nil is returned if client asks
+// for no subnet by source prefix 0
+func clientSubnet(w server.ResponseWriter, req *g53.Message) *net.IPNet {
+	if req.Edns != nil {
+		if subnet := req.Edns.SubnetOpt(); subnet != nil {
+			if subnet.SourcePrefix() == 0 {
+				return nil
+			}
+			return subnet.Network(subnet.SourcePrefix())
+		}
+	}
+
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return hostSubnet(addr.IP)
+	case *net.TCPAddr:
+		return hostSubnet(addr.IP)
+	}
+	return nil
+}
+
+// client subnet in req is echoed back with scope of the cached answer
+func makeResponse(req *g53.Message, cached *g53.Message, scope uint8) *g53.Message {
+	resp := req.MakeResponse()
+	resp.Header.SetFlag(g53.FLAG_RA, true)
+	resp.Header.Rcode = cached.Header.Rcode
+	resp.Sections = cached.Sections
+	if req.Edns != nil {
+		resp.Edns = &g53.EDNS{UdpSize: EdnsUDPSize}
+		if subnet := req.Edns.SubnetOpt(); subnet != nil {
+			echo := g53.NewSubnetOpt(subnet.IP(), subnet.SourcePrefix())
+			echo.SetScopePrefix(scope)
+			resp.Edns.Options = append(resp.Edns.Options, echo)
+		}
+	}
+	return resp
+}
@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/server"
+)
+
+// fakeUpstream answers A queries with 1.1.1.1 or SERVFAIL if servfail is
+// set, fetch queries wait until release is closed and other queries wait
+// until hang is closed if they are set
+type fakeUpstream struct {
+	lock     sync.Mutex
+	queries  []*g53.Message
+	release  chan struct{}
+	hang     chan struct{}
+	servfail bool
+}
+
+func (u *fakeUpstream) ServeDNS(w server.ResponseWriter, req *g53.Message) {
+	u.lock.Lock()
+	u.queries = append(u.queries, req)
+	release, hang, servfail := u.release, u.hang, u.servfail
+	u.lock.Unlock()
+	wait := hang
+	if req.Header.GetFlag(g53.FLAG_FETCH) {
+		wait = release
+	}
+	if wait != nil {
+		<-wait
+	}
+
+	resp := req.MakeResponse()
+	resp.Header.SetFlag(g53.FLAG_RA, true)
+	if servfail {
+		resp.Header.Rcode = g53.R_SERVFAIL
+		w.Write(resp)
+		return
+	}
+	resp.AddRRset(g53.AnswerSection, g53.BuildRRset(req.Question.Name.String(false), g53.RR_A, 100, "1.1.1.1"))
+	w.Write(resp)
+}
+
+func (u *fakeUpstream) count() int {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return len(u.queries)
+}
+
+func (u *fakeUpstream) query(i int) *g53.Message {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.queries[i]
+}
+
+type recordWriter struct {
+	resp *g53.Message
+}
+
+func (w *recordWriter) LocalAddr() net.Addr         { return nil }
+func (w *recordWriter) RemoteAddr() net.Addr        { return nil }
+func (w *recordWriter) Transport() server.Transport { return server.TRANSPORT_UDP }
+func (w *recordWriter) WriteData(data []byte) error { return nil }
+func (w *recordWriter) Write(resp *g53.Message) error {
+	w.resp = resp
+	return nil
+}
+
+func serve(h *Handler, name string, fetch bool) *g53.Message {
+	n, _ := g53.NameFromString(name)
+	req := g53.MakeQuery(n, g53.RR_A, 4096, false)
+	req.Header.Id = 1234
+	req.Header.SetFlag(g53.FLAG_FETCH, fetch)
+	w := &recordWriter{}
+	h.ServeDNS(w, req)
+	return w.resp
+}
+
+func TestHandlerCache(t *testing.T) {
+	upstream := &fakeUpstream{}
+	c := NewRRsetCache(0)
+	c.now = newFakeClock().Now
+	h := NewHandler(c, upstream)
+
+	resp := serve(h, "www.example.com.", false)
+	g53.Equal(t, upstream.count(), 1)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "1.1.1.1")
+
+	resp = serve(h, "www.example.com.", false)
+	g53.Equal(t, upstream.count(), 1)
+	g53.Equal(t, resp.Header.Id, uint16(1234))
+	g53.Assert(t, resp.Header.GetFlag(g53.FLAG_RA), "cached response should set ra")
+	g53.Assert(t, resp.Edns != nil, "cached response should have edns")
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "1.1.1.1")
+
+	//fetch query bypasses cache
+	serve(h, "www.example.com.", true)
+	g53.Equal(t, upstream.count(), 2)
+}
+
+func TestHandlerPrefetch(t *testing.T) {
+	upstream := &fakeUpstream{}
+	clock := newFakeClock()
+	c := NewRRsetCache(0)
+	c.now = clock.Now
+	h := NewHandler(c, upstream)
+	h.PrefetchHits = 2
+	h.PrefetchRatio = 0.1
+
+	serve(h, "www.example.com.", false)
+	clock.Advance(50 * time.Second)
+	serve(h, "www.example.com.", false)
+	serve(h, "www.example.com.", false)
+	h.background.Wait()
+	g53.Equal(t, upstream.count(), 1)
+
+	//popular entry close to expire is refreshed once
+	upstream.lock.Lock()
+	upstream.release = make(chan struct{})
+	upstream.lock.Unlock()
+	clock.Advance(45 * time.Second)
+	resp := serve(h, "www.example.com.", false)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Ttl, g53.RRTTL(5))
+	serve(h, "www.example.com.", false)
+	close(upstream.release)
+	h.background.Wait()
+	g53.Equal(t, upstream.count(), 2)
+	g53.Assert(t, upstream.query(1).Header.GetFlag(g53.FLAG_FETCH), "refresh query should set fetch flag")
+
+	rrset, _, ok := get(c, "www.example.com.", g53.RR_A)
+	g53.Assert(t, ok, "refreshed rrset should be cached")
+	g53.Equal(t, rrset.Ttl, g53.RRTTL(100))
+	n, _ := g53.NameFromString("www.example.com.")
+	g53.Assert(t, c.Hits(n, g53.RR_A, g53.CLASS_IN) >= 4, "hits should be kept after refresh")
+
+	//unpopular entry isn't prefetched
+	serve(h, "other.example.com.", false)
+	clock.Advance(95 * time.Second)
+	serve(h, "other.example.com.", false)
+	h.background.Wait()
+	g53.Equal(t, upstream.count(), 3)
+}
+
+func TestHandlerServeStale(t *testing.T) {
+	upstream := &fakeUpstream{}
+	clock := newFakeClock()
+	c := NewRRsetCache(0)
+	c.now = clock.Now
+	c.MaxStale = time.Hour
+	h := NewHandler(c, upstream)
+	h.StaleAnswerTimeout = 50 * time.Millisecond
+
+	serve(h, "www.example.com.", false)
+	clock.Advance(200 * time.Second)
+	upstream.lock.Lock()
+	upstream.servfail = true
+	upstream.lock.Unlock()
+	resp := serve(h, "www.example.com.", false)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Ttl, StaleTTL)
+	g53.Equal(t, resp.Edns.ExtendedErrorOpt().InfoCode, g53.ExtendedErrorCode(g53.EDE_STALE_ANSWER))
+	g53.Equal(t, upstream.count(), 2)
+
+	//stale data is answered on timeout, and refreshed after resolution
+	upstream.lock.Lock()
+	upstream.servfail = false
+	upstream.hang = make(chan struct{})
+	upstream.lock.Unlock()
+	resp = serve(h, "www.example.com.", false)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Ttl, StaleTTL)
+	close(upstream.hang)
+	h.background.Wait()
+	rrset, _, ok := get(c, "www.example.com.", g53.RR_A)
+	g53.Assert(t, ok, "rrset should be refreshed")
+	g53.Equal(t, rrset.Ttl, g53.RRTTL(100))
+
+	//data expired longer than max stale is removed
+	clock.Advance(2 * time.Hour)
+	upstream.lock.Lock()
+	upstream.servfail = true
+	upstream.lock.Unlock()
+	resp = serve(h, "www.example.com.", false)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_SERVFAIL))
+	g53.Equal(t, c.Len(), 0)
+}
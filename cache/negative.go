@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"net"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+)
+
+// NXDOMAIN is cached with this type since it covers all the types of name
+const nxdomainType = g53.RRType(0)
+
+// maximum length of cname chain followed by Lookup
+const maxChainLen = 8
+
+type negativeAnswer struct {
+	rcode g53.Rcode
+	soa   *g53.RRset
+}
+
+// finalName follows the cname chain of qname in answer section
+func finalName(resp *g53.Message) *g53.Name {
+	name := resp.Question.Name
+	for i := 0; i < maxChainLen; i++ {
+		rrset := findRRset(resp.Sections[g53.AnswerSection], name, g53.RR_CNAME)
+		if rrset == nil || len(rrset.Rdatas) == 0 {
+			break
+		}
+		name = rrset.Rdatas[0].(*g53.CName).Name
+	}
+	return name
+}
+
+func findRRset(section g53.Section, name *g53.Name, typ g53.RRType) *g53.RRset {
+	for _, rrset := range section {
+		if rrset.Type == typ && rrset.Name.Equals(name) {
+			return rrset
+		}
+	}
+	return nil
+}
+
+// AddNegative caches NXDOMAIN or NODATA response as rfc2308, the negative
+// answer belongs to the last name of the cname chain, and its ttl is the
+// smaller one of soa ttl and soa minimum. Response without soa in authority
+// section isn't cached, and the negative answer of response with client
+// subnet scope is only valid for the subnet
+func (c *RRsetCache) AddNegative(resp *g53.Message) bool {
+	if resp.Question == nil {
+		return false
+	}
+
+	name := finalName(resp)
+	typ := resp.Question.Type
+	switch resp.Header.Rcode {
+	case g53.R_NXDOMAIN:
+		typ = nxdomainType
+	case g53.R_NOERROR:
+		if findRRset(resp.Sections[g53.AnswerSection], name, typ) != nil {
+			return false
+		}
+	default:
+		return false
+	}
+
+	var soa *g53.RRset
+	for _, rrset := range resp.Sections[g53.AuthSection] {
+		if rrset.Type == g53.RR_SOA && len(rrset.Rdatas) > 0 {
+			soa = copyRRset(rrset)
+			break
+		}
+	}
+	if soa == nil {
+		return false
+	}
+
+	if minimum := soa.Rdatas[0].(*g53.SOA).Minimum; uint32(soa.Ttl) > minimum {
+		soa.Ttl = g53.RRTTL(minimum)
+	}
+	ttl := c.capTTL(soa.Ttl)
+	if ttl == 0 {
+		return false
+	}
+
+	entry := &rrsetEntry{
+		key: makeKey(name, typ, resp.Question.Class),
+		negative: &negativeAnswer{
+			rcode: resp.Header.Rcode,
+			soa:   soa,
+		},
+		trust: TrustOf(g53.AnswerSection, resp.Header.GetFlag(g53.FLAG_AA)),
+	}
+	if subnet := scopeOf(resp); subnet != nil {
+		entry.key = scopedKey(entry.key, subnet)
+		entry.subnet = subnet
+	}
+	return c.put(entry, ttl)
+}
+
+// GetNegative returns NXDOMAIN if name doesn't exist, or NOERROR if name
+// has no rrset of typ, together with the soa of decayed ttl
+func (c *RRsetCache) GetNegative(name *g53.Name, typ g53.RRType, class g53.RRClass) (g53.Rcode, *g53.RRset, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, t := range []g53.RRType{nxdomainType, typ} {
+		if e, ttl := c.get(makeKey(name, t, class)); e != nil && e.negative != nil {
+			soa := copyRRset(e.negative.soa)
+			soa.Ttl = ttl
+			return e.negative.rcode, soa, true
+		}
+	}
+	return g53.R_NOERROR, nil, false
+}
+
+// Lookup synthesizes the response of name and typ from cache, cname chain
+// is followed, nil is returned if the answer of any name in the chain isn't
+// cached
+func (c *RRsetCache) Lookup(name *g53.Name, typ g53.RRType, class g53.RRClass) *g53.Message {
+	resp, _ := c.lookup(name, typ, class, nil, false)
+	return resp
+}
+
+// LookupStale is same as Lookup except that data expired in MaxStale is
+// used with StaleTTL
+func (c *RRsetCache) LookupStale(name *g53.Name, typ g53.RRType, class g53.RRClass) *g53.Message {
+	resp, _ := c.lookup(name, typ, class, nil, true)
+	return resp
+}
+
+// usage of the entries used by a response, hits and ttl are of the one
+// which expires first, scope is the longest subnet prefix of them
+type usage struct {
+	hits      uint64
+	ttl       time.Duration
+	remaining time.Duration
+	scope     uint8
+}
+
+func (c *RRsetCache) lookup(name *g53.Name, typ g53.RRType, class g53.RRClass, client *net.IPNet, stale bool) (*g53.Message, usage) {
+	query := g53.MakeQuery(name, typ, 0, false)
+	query.Question.Class = class
+	query.Edns = nil
+	resp := query.MakeResponse()
+	resp.Header.SetFlag(g53.FLAG_RD, false)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var u usage
+	use := func(e *rrsetEntry) {
+		remaining := e.expire.Sub(c.now())
+		if u.ttl == 0 || remaining < u.remaining {
+			u.hits, u.ttl, u.remaining = e.hits, e.ttl, remaining
+		}
+		if e.subnet != nil && prefixLen(e.subnet) > int(u.scope) {
+			u.scope = uint8(prefixLen(e.subnet))
+		}
+	}
+
+	for i := 0; i <= maxChainLen; i++ {
+		if e, ttl := c.find(makeKey(name, typ, class), client, stale); e != nil && e.rrset != nil {
+			use(e)
+			rrset := copyRRset(e.rrset)
+			rrset.Ttl = ttl
+			resp.AddRRset(g53.AnswerSection, rrset)
+			return resp, u
+		}
+
+		for _, t := range []g53.RRType{nxdomainType, typ} {
+			if e, ttl := c.find(makeKey(name, t, class), client, stale); e != nil && e.negative != nil {
+				use(e)
+				soa := copyRRset(e.negative.soa)
+				soa.Ttl = ttl
+				resp.Header.Rcode = e.negative.rcode
+				resp.AddRRset(g53.AuthSection, soa)
+				return resp, u
+			}
+		}
+
+		if typ == g53.RR_CNAME {
+			return nil, u
+		}
+		e, ttl := c.find(makeKey(name, g53.RR_CNAME, class), client, stale)
+		if e == nil || e.rrset == nil {
+			return nil, u
+		}
+		use(e)
+		cname := copyRRset(e.rrset)
+		cname.Ttl = ttl
+		resp.AddRRset(g53.AnswerSection, cname)
+		name = cname.Rdatas[0].(*g53.CName).Name
+	}
+	return nil, u
+}
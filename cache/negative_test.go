@@ -0,0 +1,143 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/server"
+)
+
+func negativeResponse(name string, typ g53.RRType, rcode g53.Rcode, soaTTL int, minimum string) *g53.Message {
+	n, _ := g53.NameFromString(name)
+	resp := g53.MakeQuery(n, typ, 512, false).MakeResponse()
+	resp.Header.Rcode = rcode
+	resp.Header.SetFlag(g53.FLAG_AA, true)
+	resp.AddRRset(g53.AuthSection, g53.BuildRRset("example.com.", g53.RR_SOA, soaTTL, "ns.example.com. root.example.com. 1 3600 900 "+minimum))
+	return resp
+}
+
+func TestNegativeCache(t *testing.T) {
+	clock := newFakeClock()
+	c := NewRRsetCache(0)
+	c.now = clock.Now
+
+	//ttl is the soa minimum which is smaller than soa ttl
+	c.AddMessage(negativeResponse("nonexist.example.com.", g53.RR_A, g53.R_NXDOMAIN, 3600, "300"))
+	//ttl is the soa ttl
+	c.AddMessage(negativeResponse("www.example.com.", g53.RRType(g53.RR_AAAA), g53.R_NOERROR, 60, "300"))
+
+	clock.Advance(10 * time.Second)
+	name, _ := g53.NameFromString("nonexist.example.com.")
+	for _, typ := range []g53.RRType{g53.RR_A, g53.RRType(g53.RR_AAAA), g53.RRType(g53.RR_MX)} {
+		rcode, soa, ok := c.GetNegative(name, typ, g53.CLASS_IN)
+		g53.Assert(t, ok, "nxdomain covers all types")
+		g53.Equal(t, rcode, g53.Rcode(g53.R_NXDOMAIN))
+		g53.Equal(t, soa.Ttl, g53.RRTTL(290))
+	}
+
+	name, _ = g53.NameFromString("www.example.com.")
+	rcode, soa, ok := c.GetNegative(name, g53.RRType(g53.RR_AAAA), g53.CLASS_IN)
+	g53.Assert(t, ok, "nodata should be cached")
+	g53.Equal(t, rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, soa.Ttl, g53.RRTTL(50))
+	_, _, ok = c.GetNegative(name, g53.RR_A, g53.CLASS_IN)
+	g53.Assert(t, ok == false, "nodata is per type")
+	_, _, ok = c.Get(name, g53.RRType(g53.RR_AAAA), g53.CLASS_IN)
+	g53.Assert(t, ok == false, "negative answer isn't rrset")
+
+	resp := c.Lookup(name, g53.RRType(g53.RR_AAAA), g53.CLASS_IN)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, len(resp.Sections[g53.AnswerSection]), 0)
+	g53.Equal(t, resp.Sections[g53.AuthSection][0].Type, g53.RRType(g53.RR_SOA))
+	g53.Equal(t, resp.Sections[g53.AuthSection][0].Ttl, g53.RRTTL(50))
+	g53.Assert(t, resp.Question.Name.Equals(name), "question should be set")
+
+	clock.Advance(50 * time.Second)
+	_, _, ok = c.GetNegative(name, g53.RRType(g53.RR_AAAA), g53.CLASS_IN)
+	g53.Assert(t, ok == false, "nodata should expire")
+
+	//response without soa or with answer isn't negative
+	resp = negativeResponse("nosoa.example.com.", g53.RR_A, g53.R_NXDOMAIN, 3600, "300")
+	resp.Sections[g53.AuthSection] = nil
+	g53.Assert(t, c.AddNegative(resp) == false, "response without soa isn't cached")
+	resp = negativeResponse("www.example.com.", g53.RR_A, g53.R_NOERROR, 3600, "300")
+	resp.AddRRset(g53.AnswerSection, g53.BuildRRset("www.example.com.", g53.RR_A, 300, "1.1.1.1"))
+	g53.Assert(t, c.AddNegative(resp) == false, "positive response isn't negative")
+	resp = negativeResponse("www.example.com.", g53.RR_A, g53.R_SERVFAIL, 3600, "300")
+	g53.Assert(t, c.AddNegative(resp) == false, "servfail isn't cached")
+}
+
+func TestNegativeCacheChain(t *testing.T) {
+	c := NewRRsetCache(0)
+	c.now = newFakeClock().Now
+
+	//nxdomain belongs to the target of cname
+	resp := negativeResponse("alias.example.com.", g53.RR_A, g53.R_NXDOMAIN, 3600, "300")
+	resp.AddRRset(g53.AnswerSection, g53.BuildRRset("alias.example.com.", g53.RR_CNAME, 600, "nonexist.example.com."))
+	c.AddMessage(resp)
+
+	name, _ := g53.NameFromString("alias.example.com.")
+	_, _, ok := c.GetNegative(name, g53.RR_A, g53.CLASS_IN)
+	g53.Assert(t, ok == false, "alias exists")
+
+	resp = c.Lookup(name, g53.RR_A, g53.CLASS_IN)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NXDOMAIN))
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Type, g53.RRType(g53.RR_CNAME))
+	g53.Equal(t, resp.Sections[g53.AuthSection][0].Ttl, g53.RRTTL(300))
+
+	//positive answer through cname
+	c.Add(g53.BuildRRset("www.example.com.", g53.RR_A, 300, "1.1.1.1"), TRUST_AUTH_ANSWER)
+	c.Add(g53.BuildRRset("web.example.com.", g53.RR_CNAME, 300, "www.example.com."), TRUST_AUTH_ANSWER)
+	name, _ = g53.NameFromString("web.example.com.")
+	resp = c.Lookup(name, g53.RR_A, g53.CLASS_IN)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, len(resp.Sections[g53.AnswerSection]), 2)
+
+	//the answer of cname target isn't cached
+	g53.Assert(t, c.Lookup(name, g53.RR_MX, g53.CLASS_IN) == nil, "lookup should miss")
+	name, _ = g53.NameFromString("unknown.example.com.")
+	g53.Assert(t, c.Lookup(name, g53.RR_A, g53.CLASS_IN) == nil, "lookup should miss")
+}
+
+func TestLookupStale(t *testing.T) {
+	clock := newFakeClock()
+	c := NewRRsetCache(0)
+	c.now = clock.Now
+	c.MaxStale = time.Hour
+
+	c.AddMessage(negativeResponse("nonexist.example.com.", g53.RR_A, g53.R_NXDOMAIN, 60, "300"))
+	c.Add(g53.BuildRRset("www.example.com.", g53.RR_A, 600, "1.1.1.1"), TRUST_ANSWER)
+	clock.Advance(120 * time.Second)
+
+	name, _ := g53.NameFromString("nonexist.example.com.")
+	g53.Assert(t, c.Lookup(name, g53.RR_A, g53.CLASS_IN) == nil, "expired data isn't fresh")
+	resp := c.LookupStale(name, g53.RR_A, g53.CLASS_IN)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NXDOMAIN))
+	g53.Equal(t, resp.Sections[g53.AuthSection][0].Ttl, StaleTTL)
+
+	//unexpired data keeps its ttl
+	name, _ = g53.NameFromString("www.example.com.")
+	resp = c.LookupStale(name, g53.RR_A, g53.CLASS_IN)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Ttl, g53.RRTTL(480))
+
+	clock.Advance(time.Hour)
+	name, _ = g53.NameFromString("nonexist.example.com.")
+	g53.Assert(t, c.LookupStale(name, g53.RR_A, g53.CLASS_IN) == nil, "data expired longer than max stale is removed")
+}
+
+func TestNegativeCacheEmptyRdata(t *testing.T) {
+	c := NewRRsetCache(0)
+	h := NewHandler(c, server.HandlerFunc(func(w server.ResponseWriter, req *g53.Message) {
+		resp := negativeResponse("alias.example.com.", g53.RR_A, g53.R_NXDOMAIN, 3600, "300")
+		resp.Header.Id = req.Header.Id
+		resp.AddRRset(g53.AnswerSection, &g53.RRset{Name: req.Question.Name, Type: g53.RR_CNAME, Class: g53.CLASS_ANY})
+		render := g53.NewMsgRender()
+		resp.Rend(render)
+		w.WriteData(render.Data())
+	}))
+
+	g53.Assert(t, serve(h, "alias.example.com.", false) == nil, "malformed response shouldn't be sent")
+	name, _ := g53.NameFromString("alias.example.com.")
+	g53.Assert(t, c.Lookup(name, g53.RR_A, g53.CLASS_IN) == nil, "malformed response shouldn't be cached")
+}
@@ -0,0 +1,262 @@
+package cache
+
+import (
+	"container/list"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+)
+
+const (
+	DefaultCacheSize = 10000
+	DefaultMaxTTL    = 7 * 24 * time.Hour
+	// ttl of stale data in response, rfc8767
+	StaleTTL = g53.RRTTL(30)
+)
+
+// subnet is set for answer scoped by client subnet
+type key struct {
+	name   string
+	typ    g53.RRType
+	class  g53.RRClass
+	subnet string
+}
+
+func makeKey(name *g53.Name, typ g53.RRType, class g53.RRClass) key {
+	return key{
+		name:  strings.ToLower(name.String(false)),
+		typ:   typ,
+		class: class,
+	}
+}
+
+// entry has either rrset or negative answer, hits is kept when the entry
+// is refreshed
+type rrsetEntry struct {
+	key      key
+	rrset    *g53.RRset
+	negative *negativeAnswer
+	trust    Trust
+	subnet   *net.IPNet
+	ttl      time.Duration
+	expire   time.Time
+	hits     uint64
+}
+
+// RRsetCache keeps at most maxSize rrsets, the least recently used one is
+// evicted when it's full. RRset is returned with its ttl decreased by the
+// time it has been in cache. Expired rrset is kept for MaxStale to be served
+// as stale answer, 0 disables serve-stale. Answer of response with client
+// subnet scope is cached per subnet, at most MaxScopes subnets are kept for
+// one rrset
+type RRsetCache struct {
+	MaxTTL    time.Duration
+	MaxStale  time.Duration
+	MaxScopes int
+
+	lock    sync.Mutex
+	maxSize int
+	entries map[key]*list.Element
+	scopes  map[key][]*net.IPNet
+	lru     *list.List
+	now     func() time.Time
+}
+
+func NewRRsetCache(maxSize int) *RRsetCache {
+	if maxSize <= 0 {
+		maxSize = DefaultCacheSize
+	}
+	return &RRsetCache{
+		MaxTTL:    DefaultMaxTTL,
+		MaxScopes: DefaultMaxScopes,
+		maxSize:   maxSize,
+		entries:   make(map[key]*list.Element),
+		scopes:    make(map[key][]*net.IPNet),
+		lru:       list.New(),
+		now:       time.Now,
+	}
+}
+
+// Add caches rrset unless there is unexpired rrset of higher trust, rrset
+// with zero ttl or without rdata isn't cached, true is returned if rrset is
+// cached
+func (c *RRsetCache) Add(rrset *g53.RRset, trust Trust) bool {
+	ttl := c.capTTL(rrset.Ttl)
+	if ttl == 0 || len(rrset.Rdatas) == 0 {
+		return false
+	}
+
+	return c.put(&rrsetEntry{
+		key:   makeKey(rrset.Name, rrset.Type, rrset.Class),
+		rrset: copyRRset(rrset),
+		trust: trust,
+	}, ttl)
+}
+
+// addScoped caches rrset which is only valid for clients in subnet
+func (c *RRsetCache) addScoped(rrset *g53.RRset, trust Trust, subnet *net.IPNet) bool {
+	ttl := c.capTTL(rrset.Ttl)
+	if ttl == 0 || len(rrset.Rdatas) == 0 {
+		return false
+	}
+
+	return c.put(&rrsetEntry{
+		key:    scopedKey(makeKey(rrset.Name, rrset.Type, rrset.Class), subnet),
+		rrset:  copyRRset(rrset),
+		trust:  trust,
+		subnet: subnet,
+	}, ttl)
+}
+
+func (c *RRsetCache) capTTL(ttl g53.RRTTL) time.Duration {
+	d := time.Duration(ttl) * time.Second
+	if c.MaxTTL > 0 && d > c.MaxTTL {
+		return c.MaxTTL
+	}
+	return d
+}
+
+func (c *RRsetCache) put(entry *rrsetEntry, ttl time.Duration) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	entry.ttl = ttl
+	entry.expire = now.Add(ttl)
+	if elem, ok := c.entries[entry.key]; ok {
+		e := elem.Value.(*rrsetEntry)
+		if e.trust > entry.trust && e.expire.After(now) {
+			return false
+		}
+		entry.hits = e.hits
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return true
+	}
+
+	if entry.subnet != nil {
+		c.addScope(entry)
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.maxSize {
+		c.removeElement(c.lru.Back())
+	}
+	return true
+}
+
+// AddMessage caches all the rrsets of response with the trust of the
+// section they belong to, and the negative answer if response is NXDOMAIN
+// or NODATA. Answer section of response with client subnet scope is only
+// valid for the subnet
+func (c *RRsetCache) AddMessage(resp *g53.Message) {
+	aa := resp.Header.GetFlag(g53.FLAG_AA)
+	subnet := scopeOf(resp)
+	for i, section := range resp.Sections {
+		trust := TrustOf(g53.SectionType(i), aa)
+		for _, rrset := range section {
+			if subnet != nil && g53.SectionType(i) == g53.AnswerSection {
+				c.addScoped(rrset, trust, subnet)
+			} else {
+				c.Add(rrset, trust)
+			}
+		}
+	}
+	c.AddNegative(resp)
+}
+
+// Get returns the copy of cached rrset with decayed ttl, expired rrset is
+// removed
+func (c *RRsetCache) Get(name *g53.Name, typ g53.RRType, class g53.RRClass) (*g53.RRset, Trust, bool) {
+	k := makeKey(name, typ, class)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ttl := c.get(k)
+	if e == nil || e.rrset == nil {
+		return nil, 0, false
+	}
+
+	rrset := copyRRset(e.rrset)
+	rrset.Ttl = g53.RRTTL(ttl)
+	return rrset, e.trust, true
+}
+
+// called with lock held, the remaining ttl of the entry is returned
+func (c *RRsetCache) get(k key) (*rrsetEntry, g53.RRTTL) {
+	return c.getEntry(k, false)
+}
+
+// expired entry is only returned with stale set, its ttl is StaleTTL, and
+// it's removed once it has been expired for MaxStale
+func (c *RRsetCache) getEntry(k key, stale bool) (*rrsetEntry, g53.RRTTL) {
+	elem, ok := c.entries[k]
+	if ok == false {
+		return nil, 0
+	}
+
+	e := elem.Value.(*rrsetEntry)
+	now := c.now()
+	ttl := e.expire.Sub(now) / time.Second
+	if ttl <= 0 {
+		if now.Before(e.expire.Add(c.MaxStale)) == false {
+			c.removeElement(elem)
+			return nil, 0
+		}
+		if stale == false {
+			return nil, 0
+		}
+		ttl = time.Duration(StaleTTL)
+	}
+
+	e.hits += 1
+	c.lru.MoveToFront(elem)
+	return e, g53.RRTTL(ttl)
+}
+
+// Hits returns how many times the rrset or negative answer of name and
+// typ has been looked up
+func (c *RRsetCache) Hits(name *g53.Name, typ g53.RRType, class g53.RRClass) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, t := range []g53.RRType{typ, nxdomainType} {
+		if elem, ok := c.entries[makeKey(name, t, class)]; ok {
+			return elem.Value.(*rrsetEntry).hits
+		}
+	}
+	return 0
+}
+
+func (c *RRsetCache) Remove(name *g53.Name, typ g53.RRType, class g53.RRClass) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[makeKey(name, typ, class)]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *RRsetCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
+
+// called with lock held
+func (c *RRsetCache) removeElement(elem *list.Element) {
+	e := elem.Value.(*rrsetEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, e.key)
+	if e.subnet != nil {
+		c.removeScope(e)
+	}
+}
+
+func copyRRset(rrset *g53.RRset) *g53.RRset {
+	return &g53.RRset{
+		Name:   rrset.Name,
+		Type:   rrset.Type,
+		Class:  rrset.Class,
+		Ttl:    rrset.Ttl,
+		Rdatas: append([]g53.Rdata{}, rrset.Rdatas...),
+	}
+}
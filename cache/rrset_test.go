@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+)
+
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1600000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func get(c *RRsetCache, name string, typ g53.RRType) (*g53.RRset, Trust, bool) {
+	n, _ := g53.NameFromString(name)
+	return c.Get(n, typ, g53.CLASS_IN)
+}
+
+func TestRRsetCacheTTL(t *testing.T) {
+	clock := newFakeClock()
+	c := NewRRsetCache(0)
+	c.now = clock.Now
+
+	g53.Assert(t, c.Add(g53.BuildRRset("www.example.com.", g53.RR_A, 300, "1.1.1.1", "2.2.2.2"), TRUST_ANSWER), "rrset should be cached")
+	g53.Assert(t, c.Add(g53.BuildRRset("zero.example.com.", g53.RR_A, 0, "1.1.1.1"), TRUST_ANSWER) == false, "rrset with zero ttl isn't cached")
+	g53.Assert(t, c.Add(g53.BuildRRset("empty.example.com.", g53.RR_A, 300), TRUST_ANSWER) == false, "rrset without rdata isn't cached")
+
+	clock.Advance(100 * time.Second)
+	rrset, trust, ok := get(c, "WWW.example.com.", g53.RR_A)
+	g53.Assert(t, ok, "rrset should be found")
+	g53.Equal(t, rrset.Ttl, g53.RRTTL(200))
+	g53.Equal(t, trust, Trust(TRUST_ANSWER))
+	g53.Equal(t, rrset.RrCount(), 2)
+
+	//returned rrset is a copy
+	rrset.Rdatas = nil
+	rrset, _, _ = get(c, "www.example.com.", g53.RR_A)
+	g53.Equal(t, rrset.RrCount(), 2)
+
+	_, _, ok = get(c, "www.example.com.", g53.RRType(g53.RR_AAAA))
+	g53.Assert(t, ok == false, "aaaa isn't cached")
+
+	clock.Advance(200 * time.Second)
+	_, _, ok = get(c, "www.example.com.", g53.RR_A)
+	g53.Assert(t, ok == false, "rrset should expire")
+	g53.Equal(t, c.Len(), 0)
+
+	c.MaxTTL = time.Hour
+	c.Add(g53.BuildRRset("www.example.com.", g53.RR_A, 86400, "1.1.1.1"), TRUST_ANSWER)
+	rrset, _, _ = get(c, "www.example.com.", g53.RR_A)
+	g53.Equal(t, rrset.Ttl, g53.RRTTL(3600))
+
+	name, _ := g53.NameFromString("www.example.com.")
+	c.Remove(name, g53.RR_A, g53.CLASS_IN)
+	_, _, ok = get(c, "www.example.com.", g53.RR_A)
+	g53.Assert(t, ok == false, "rrset should be removed")
+}
+
+func TestRRsetCacheTrust(t *testing.T) {
+	clock := newFakeClock()
+	c := NewRRsetCache(0)
+	c.now = clock.Now
+
+	c.Add(g53.BuildRRset("ns.example.com.", g53.RR_A, 300, "1.1.1.1"), TRUST_AUTH_ANSWER)
+	g53.Assert(t, c.Add(g53.BuildRRset("ns.example.com.", g53.RR_A, 300, "2.2.2.2"), TRUST_GLUE) == false, "glue can't replace auth answer")
+	rrset, trust, _ := get(c, "ns.example.com.", g53.RR_A)
+	g53.Equal(t, rrset.Rdatas[0].String(), "1.1.1.1")
+	g53.Equal(t, trust, Trust(TRUST_AUTH_ANSWER))
+
+	g53.Assert(t, c.Add(g53.BuildRRset("ns.example.com.", g53.RR_A, 300, "3.3.3.3"), TRUST_AUTH_ANSWER), "same trust replaces")
+	rrset, _, _ = get(c, "ns.example.com.", g53.RR_A)
+	g53.Equal(t, rrset.Rdatas[0].String(), "3.3.3.3")
+
+	clock.Advance(301 * time.Second)
+	g53.Assert(t, c.Add(g53.BuildRRset("ns.example.com.", g53.RR_A, 300, "2.2.2.2"), TRUST_GLUE), "glue replaces expired data")
+	_, trust, _ = get(c, "ns.example.com.", g53.RR_A)
+	g53.Equal(t, trust, Trust(TRUST_GLUE))
+
+	//referral from non authoritative server
+	name, _ := g53.NameFromString("www.example.com.")
+	resp := g53.MakeQuery(name, g53.RR_A, 512, false).MakeResponse()
+	resp.AddRRset(g53.AnswerSection, g53.BuildRRset("www.example.com.", g53.RR_A, 300, "4.4.4.4"))
+	resp.AddRRset(g53.AuthSection, g53.BuildRRset("example.com.", g53.RR_NS, 300, "ns.example.com."))
+	resp.AddRRset(g53.AdditionalSection, g53.BuildRRset("ns.example.com.", g53.RR_A, 300, "5.5.5.5"))
+	c.AddMessage(resp)
+	_, trust, _ = get(c, "www.example.com.", g53.RR_A)
+	g53.Equal(t, trust, Trust(TRUST_ANSWER))
+	_, trust, _ = get(c, "example.com.", g53.RR_NS)
+	g53.Equal(t, trust, Trust(TRUST_AUTHORITY))
+	rrset, trust, _ = get(c, "ns.example.com.", g53.RR_A)
+	g53.Equal(t, rrset.Rdatas[0].String(), "5.5.5.5")
+	g53.Equal(t, trust, Trust(TRUST_GLUE))
+
+	resp.Header.SetFlag(g53.FLAG_AA, true)
+	c.AddMessage(resp)
+	_, trust, _ = get(c, "www.example.com.", g53.RR_A)
+	g53.Equal(t, trust, Trust(TRUST_AUTH_ANSWER))
+	_, trust, _ = get(c, "example.com.", g53.RR_NS)
+	g53.Equal(t, trust, Trust(TRUST_AUTH_AUTHORITY))
+}
+
+func TestRRsetCacheLRU(t *testing.T) {
+	c := NewRRsetCache(3)
+	for i := 0; i < 3; i++ {
+		c.Add(g53.BuildRRset(fmt.Sprintf("n%d.example.com.", i), g53.RR_A, 300, "1.1.1.1"), TRUST_ANSWER)
+	}
+	//n0 becomes the most recently used
+	_, _, ok := get(c, "n0.example.com.", g53.RR_A)
+	g53.Assert(t, ok, "n0 should be cached")
+
+	c.Add(g53.BuildRRset("n3.example.com.", g53.RR_A, 300, "1.1.1.1"), TRUST_ANSWER)
+	g53.Equal(t, c.Len(), 3)
+	_, _, ok = get(c, "n1.example.com.", g53.RR_A)
+	g53.Assert(t, ok == false, "n1 should be evicted")
+	for _, name := range []string{"n0.example.com.", "n2.example.com.", "n3.example.com."} {
+		_, _, ok = get(c, name, g53.RR_A)
+		g53.Assert(t, ok, "%s should be cached", name)
+	}
+}
+
+func TestRRsetCacheConcurrency(t *testing.T) {
+	c := NewRRsetCache(100)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				name := fmt.Sprintf("n%d.example.com.", j%150)
+				c.Add(g53.BuildRRset(name, g53.RR_A, 300, "1.1.1.1"), Trust(i%5))
+				get(c, name, g53.RR_A)
+			}
+		}(i)
+	}
+	wg.Wait()
+	g53.Equal(t, c.Len(), 100)
+}
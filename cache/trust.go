@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"github.com/mistletoeChao/g53"
+)
+
+// Trust is the credibility of cached data as rfc2181 5.4.1, data of lower
+// trust never replaces data of higher trust before it expires
+type Trust uint8
+
+const (
+	TRUST_GLUE           Trust = 0
+	TRUST_AUTHORITY            = 1
+	TRUST_ANSWER               = 2
+	TRUST_AUTH_AUTHORITY       = 3
+	TRUST_AUTH_ANSWER          = 4
+)
+
+var TrustStr = map[Trust]string{
+	TRUST_GLUE:           "glue",
+	TRUST_AUTHORITY:      "authority",
+	TRUST_ANSWER:         "answer",
+	TRUST_AUTH_AUTHORITY: "auth-authority",
+	TRUST_AUTH_ANSWER:    "auth-answer",
+}
+
+func (t Trust) String() string {
+	return TrustStr[t]
+}
+
+// TrustOf returns the trust of rrset in section of response, additional
+// section is glue even if the response is authoritative
+func TrustOf(st g53.SectionType, aa bool) Trust {
+	switch st {
+	case g53.AnswerSection:
+		if aa {
+			return TRUST_AUTH_ANSWER
+		}
+		return TRUST_ANSWER
+	case g53.AuthSection:
+		if aa {
+			return TRUST_AUTH_AUTHORITY
+		}
+		return TRUST_AUTHORITY
+	default:
+		return TRUST_GLUE
+	}
+}
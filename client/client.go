@@ -0,0 +1,182 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+const (
+	DefaultTimeout = 2 * time.Second
+	DefaultRetries = 2
+	MinUDPSize     = 512
+)
+
+var ErrNoServer = errors.New("no server to send query")
+
+// Client sends query to servers over udp and retries over tcp if the
+// response is truncated. Each round the servers are tried in order, and
+// Retries is the number of rounds after the first one
+type Client struct {
+	Timeout time.Duration
+	Retries int
+	TCPOnly bool
+}
+
+func NewClient() *Client {
+	return &Client{
+		Timeout: DefaultTimeout,
+		Retries: DefaultRetries,
+	}
+}
+
+// Exchange sends query and returns the response together with the round
+// trip time of the exchange which succeeds, a random id is set to query
+// before each try
+func (c *Client) Exchange(query *g53.Message, servers []string) (*g53.Message, time.Duration, error) {
+	if len(servers) == 0 {
+		return nil, 0, ErrNoServer
+	}
+
+	lastErr := ErrNoServer
+	for i := 0; i <= c.Retries; i++ {
+		for _, server := range servers {
+			response, rtt, err := c.ExchangeWith(query, server)
+			if err == nil {
+				return response, rtt, nil
+			}
+			lastErr = err
+		}
+	}
+	return nil, 0, lastErr
+}
+
+// ExchangeWith tries server once, tcp is used if udp response has tc set
+func (c *Client) ExchangeWith(query *g53.Message, server string) (*g53.Message, time.Duration, error) {
+	query.Header.Id = uint16(rand.Uint32())
+	render := g53.NewMsgRender()
+	query.Rend(render)
+
+	start := time.Now()
+	if c.TCPOnly == false {
+		response, err := c.exchangeUDP(query, render.Data(), server)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if response.Header.GetFlag(g53.FLAG_TC) == false {
+			return response, time.Since(start), nil
+		}
+	}
+
+	response, err := c.exchangeTCP(query, render.Data(), server)
+	if err != nil {
+		return nil, 0, err
+	}
+	return response, time.Since(start), nil
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return c.Timeout
+}
+
+func udpBufferSize(query *g53.Message) int {
+	if query.Edns != nil && int(query.Edns.UdpSize) > MinUDPSize {
+		return int(query.Edns.UdpSize)
+	}
+	return MinUDPSize
+}
+
+// responses which don't match the query are dropped, since they may be
+// spoofed or late answers of previous queries
+func (c *Client) exchangeUDP(query *g53.Message, data []byte, server string) (*g53.Message, error) {
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(c.timeout()))
+	if _, err := conn.Write(data); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, udpBufferSize(query))
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := g53.MessageFromWire(util.NewInputBuffer(buf[0:n]))
+		if err != nil {
+			continue
+		}
+
+		if IsResponseOf(query, response) {
+			return response, nil
+		}
+	}
+}
+
+func (c *Client) exchangeTCP(query *g53.Message, data []byte, server string) (*g53.Message, error) {
+	conn, err := net.DialTimeout("tcp", server, c.timeout())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(c.timeout()))
+	if err := WriteTCPMessage(conn, data); err != nil {
+		return nil, err
+	}
+
+	for {
+		data, err := ReadTCPMessage(conn)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := g53.MessageFromWire(util.NewInputBuffer(data))
+		if err != nil {
+			return nil, err
+		}
+
+		if IsResponseOf(query, response) {
+			return response, nil
+		}
+	}
+}
+
+// IsResponseOf checks id, qr flag and question of response against query
+func IsResponseOf(query, response *g53.Message) bool {
+	if response.Header.Id != query.Header.Id || response.Header.GetFlag(g53.FLAG_QR) == false {
+		return false
+	}
+
+	if query.Question == nil {
+		return response.Question == nil
+	}
+
+	q := response.Question
+	return q != nil && q.Type == query.Question.Type && q.Class == query.Question.Class &&
+		q.Name.Equals(query.Question.Name)
+}
+
+// Query is the shortcut to look up name and type with default settings
+func Query(name *g53.Name, typ g53.RRType, servers []string) (*g53.Message, error) {
+	query := g53.MakeQuery(name, typ, 4096, false)
+	response, _, err := NewClient().Exchange(query, servers)
+	if err != nil {
+		return nil, fmt.Errorf("query %s %s failed: %v", name.String(false), typ.String(), err)
+	}
+	return response, nil
+}
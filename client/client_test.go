@@ -0,0 +1,165 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+type handler func(query *g53.Message, tcp bool) [][]byte
+
+func render(msg *g53.Message) []byte {
+	r := g53.NewMsgRender()
+	msg.Rend(r)
+	return append([]byte{}, r.Data()...)
+}
+
+// serve listens on udp and tcp of the same port
+func serve(t *testing.T, h handler) (string, func()) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp failed:%v", err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("listen tcp failed:%v", err)
+	}
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query, err := g53.MessageFromWire(util.NewInputBuffer(buf[0:n]))
+			if err != nil {
+				continue
+			}
+			for _, data := range h(query, false) {
+				udp.WriteTo(data, addr)
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			data, err := ReadTCPMessage(conn)
+			if err == nil {
+				query, err := g53.MessageFromWire(util.NewInputBuffer(data))
+				if err == nil {
+					for _, data := range h(query, true) {
+						WriteTCPMessage(conn, data)
+					}
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return udp.LocalAddr().String(), func() { udp.Close(); tcp.Close() }
+}
+
+func answer(query *g53.Message, count int) *g53.Message {
+	response := query.MakeResponse()
+	for i := 0; i < count; i++ {
+		a, _ := g53.AFromString(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		response.AddRr(g53.AnswerSection, query.Question.Name, g53.RR_A, g53.CLASS_IN, a, true)
+	}
+	return response
+}
+
+func TestExchange(t *testing.T) {
+	addr, stop := serve(t, func(query *g53.Message, tcp bool) [][]byte {
+		//a response with wrong id and one with wrong question are ignored
+		wrongId := answer(query, 1)
+		wrongId.Header.Id += 1
+		wrongQuestion := answer(query, 1)
+		wrongQuestion.Question = &g53.Question{Name: g53.Root, Type: g53.RR_A, Class: g53.CLASS_IN}
+		return [][]byte{render(wrongId), render(wrongQuestion), render(answer(query, 2))}
+	})
+	defer stop()
+
+	name, _ := g53.NameFromString("www.example.com.")
+	query := g53.MakeQuery(name, g53.RR_A, 512, false)
+	c := NewClient()
+	response, rtt, err := c.Exchange(query, []string{addr})
+	g53.Assert(t, err == nil, "exchange failed:%v", err)
+	g53.Assert(t, rtt > 0, "rtt should be returned")
+	g53.Equal(t, response.Header.Id, query.Header.Id)
+	g53.Equal(t, response.Sections[g53.AnswerSection][0].RrCount(), 2)
+}
+
+func TestExchangeRetry(t *testing.T) {
+	silent, stopSilent := serve(t, func(query *g53.Message, tcp bool) [][]byte {
+		return nil
+	})
+	defer stopSilent()
+
+	var tries int32
+	addr, stop := serve(t, func(query *g53.Message, tcp bool) [][]byte {
+		if atomic.AddInt32(&tries, 1) == 1 {
+			return nil
+		}
+		return [][]byte{render(answer(query, 1))}
+	})
+	defer stop()
+
+	name, _ := g53.NameFromString("www.example.com.")
+	query := g53.MakeQuery(name, g53.RR_A, 512, false)
+	c := &Client{Timeout: 100 * time.Millisecond, Retries: 1}
+	response, _, err := c.Exchange(query, []string{silent, addr})
+	g53.Assert(t, err == nil, "exchange should succeed in second round:%v", err)
+	g53.Equal(t, len(response.Sections[g53.AnswerSection]), 1)
+	g53.Equal(t, atomic.LoadInt32(&tries), int32(2))
+
+	_, _, err = c.Exchange(query, []string{silent})
+	g53.Assert(t, err != nil, "silent server should time out")
+	_, _, err = c.Exchange(query, nil)
+	g53.Equal(t, err, ErrNoServer)
+}
+
+func TestExchangeTCPFallback(t *testing.T) {
+	addr, stop := serve(t, func(query *g53.Message, tcp bool) [][]byte {
+		if tcp {
+			return [][]byte{render(answer(query, 100))}
+		}
+		response := query.MakeResponse()
+		response.Header.SetFlag(g53.FLAG_TC, true)
+		return [][]byte{render(response)}
+	})
+	defer stop()
+
+	name, _ := g53.NameFromString("www.example.com.")
+	query := g53.MakeQuery(name, g53.RR_A, 512, false)
+	response, _, err := NewClient().Exchange(query, []string{addr})
+	g53.Assert(t, err == nil, "exchange failed:%v", err)
+	g53.Equal(t, response.Header.GetFlag(g53.FLAG_TC), false)
+	g53.Equal(t, response.Sections[g53.AnswerSection][0].RrCount(), 100)
+}
+
+func TestExchangeBufferSize(t *testing.T) {
+	addr, stop := serve(t, func(query *g53.Message, tcp bool) [][]byte {
+		return [][]byte{render(answer(query, 100))}
+	})
+	defer stop()
+
+	name, _ := g53.NameFromString("www.example.com.")
+	query := g53.MakeQuery(name, g53.RR_A, 4096, false)
+	response, _, err := NewClient().Exchange(query, []string{addr})
+	g53.Assert(t, err == nil, "large udp response should be received:%v", err)
+	g53.Equal(t, response.Sections[g53.AnswerSection][0].RrCount(), 100)
+
+	query = g53.MakeQuery(name, g53.RR_A, 512, false)
+	c := &Client{Timeout: 100 * time.Millisecond}
+	_, _, err = c.Exchange(query, []string{addr})
+	g53.Assert(t, err != nil, "response larger than 512 can't be received")
+}
@@ -0,0 +1,236 @@
+package client
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+const DefaultIdleTimeout = 10 * time.Second
+
+var (
+	ErrConnClosed  = errors.New("connection is closed")
+	ErrIdleTimeout = errors.New("connection is closed after idle timeout")
+)
+
+type pendingQuery struct {
+	query  *g53.Message
+	result chan *g53.Message
+}
+
+// Conn is a tcp connection which pipelines queries as rfc7766, responses
+// may come back in any order and are matched to queries by id. The
+// connection is closed after it has been idle for the timeout, which is
+// updated by the edns-tcp-keepalive option from server
+type Conn struct {
+	conn        net.Conn
+	writeLock   sync.Mutex
+	lock        sync.Mutex
+	pending     map[uint16]*pendingQuery
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	padding     int
+	err         error
+	done        chan struct{}
+	dso         *dsoSession
+	dsoHandler  func(*g53.Message)
+	retryDelay  time.Duration
+}
+
+func Dial(server string, timeout time.Duration) (*Conn, error) {
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn), nil
+}
+
+func NewConn(conn net.Conn) *Conn {
+	c := &Conn{
+		conn:        conn,
+		pending:     make(map[uint16]*pendingQuery),
+		idleTimeout: DefaultIdleTimeout,
+		done:        make(chan struct{}),
+	}
+	c.idleTimer = time.AfterFunc(c.idleTimeout, c.onIdle)
+	go c.readLoop()
+	return c
+}
+
+func (c *Conn) IdleTimeout() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.idleTimeout
+}
+
+// SetPadding pads queries to multiple of blockSize, 0 disables padding
+func (c *Conn) SetPadding(blockSize int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.padding = blockSize
+}
+
+// Err returns why the connection is closed, nil if it is still open
+func (c *Conn) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+func (c *Conn) Close() error {
+	c.closeWithErr(ErrConnClosed)
+	return nil
+}
+
+func (c *Conn) closeWithErr(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	c.idleTimer.Stop()
+	if c.dso != nil && c.dso.keepaliveTimer != nil {
+		c.dso.keepaliveTimer.Stop()
+	}
+	c.conn.Close()
+	close(c.done)
+}
+
+func (c *Conn) onIdle() {
+	c.lock.Lock()
+	idle := len(c.pending) == 0 && c.err == nil && c.dso.idleClosable()
+	c.lock.Unlock()
+	if idle {
+		c.closeWithErr(ErrIdleTimeout)
+	}
+}
+
+// called with lock held
+func (c *Conn) removePending(id uint16) {
+	delete(c.pending, id)
+	c.resetIdleTimer()
+}
+
+// called with lock held
+func (c *Conn) resetIdleTimer() {
+	if len(c.pending) == 0 && c.err == nil && c.dso.idleClosable() {
+		c.idleTimer.Reset(c.idleTimeout)
+	}
+}
+
+// Exchange sends query with a random id which isn't used by other pending
+// queries, and waits for the response until timeout. edns-tcp-keepalive is
+// only added before dso session is established
+func (c *Conn) Exchange(query *g53.Message, timeout time.Duration) (*g53.Message, time.Duration, error) {
+	p := &pendingQuery{
+		query:  query,
+		result: make(chan *g53.Message, 1),
+	}
+
+	c.lock.Lock()
+	if c.err != nil {
+		err := c.err
+		c.lock.Unlock()
+		return nil, 0, err
+	}
+	if c.dso == nil && query.Edns != nil && query.Edns.KeepaliveOpt() == nil {
+		query.Edns.Options = append(query.Edns.Options, &g53.KeepaliveOpt{})
+	}
+	for {
+		query.Header.Id = uint16(rand.Uint32())
+		if _, ok := c.pending[query.Header.Id]; ok == false {
+			break
+		}
+	}
+	id := query.Header.Id
+	c.pending[id] = p
+	c.idleTimer.Stop()
+	padding := c.padding
+	c.lock.Unlock()
+
+	if padding > 0 {
+		g53.PadMessage(query, padding)
+	}
+
+	start := time.Now()
+	if err := c.Send(query); err != nil {
+		return nil, 0, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case response := <-p.result:
+		return response, time.Since(start), nil
+	case <-timer.C:
+		c.lock.Lock()
+		c.removePending(id)
+		c.lock.Unlock()
+		return nil, 0, errors.New("query timeout")
+	case <-c.done:
+		return nil, 0, c.Err()
+	}
+}
+
+// Send writes msg without waiting for response, it's used for dso
+// unidirectional message and response to the request from server
+func (c *Conn) Send(msg *g53.Message) error {
+	render := g53.NewMsgRender()
+	msg.Rend(render)
+	c.writeLock.Lock()
+	err := WriteTCPMessage(c.conn, render.Data())
+	c.writeLock.Unlock()
+	if err != nil {
+		c.closeWithErr(err)
+		return err
+	}
+
+	c.lock.Lock()
+	c.dso.resetKeepalive()
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *Conn) readLoop() {
+	for {
+		data, err := ReadTCPMessage(c.conn)
+		if err != nil {
+			c.closeWithErr(err)
+			return
+		}
+
+		response, err := g53.MessageFromWire(util.NewInputBuffer(data))
+		if err != nil {
+			continue
+		}
+
+		if response.Header.GetFlag(g53.FLAG_QR) == false {
+			c.handleServerMessage(response)
+			continue
+		}
+
+		c.lock.Lock()
+		if response.Edns != nil && c.dso == nil {
+			if ka := response.Edns.KeepaliveOpt(); ka != nil && ka.HasTimeout {
+				c.idleTimeout = ka.Duration()
+			}
+		}
+
+		p, ok := c.pending[response.Header.Id]
+		if ok && IsResponseOf(p.query, response) {
+			if response.Header.Opcode == g53.OP_DSO {
+				c.handleDSOResponse(response)
+			}
+			p.result <- response
+			c.removePending(response.Header.Id)
+		}
+		c.lock.Unlock()
+	}
+}
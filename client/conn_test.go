@@ -0,0 +1,103 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+// servePipelined reads count queries before answering them in reverse order
+func servePipelined(t *testing.T, count int, keepalive time.Duration) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed:%v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				var queries []*g53.Message
+				for len(queries) < count {
+					data, err := ReadTCPMessage(conn)
+					if err != nil {
+						return
+					}
+					query, _ := g53.MessageFromWire(util.NewInputBuffer(data))
+					queries = append(queries, query)
+				}
+
+				for i := len(queries) - 1; i >= 0; i-- {
+					response := answer(queries[i], 1)
+					if queries[i].Edns != nil && queries[i].Edns.KeepaliveOpt() != nil {
+						response.Edns = &g53.EDNS{
+							UdpSize: 4096,
+							Options: []g53.Option{g53.NewKeepaliveOpt(keepalive)},
+						}
+					}
+					WriteTCPMessage(conn, render(response))
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestConnPipeline(t *testing.T) {
+	addr, stop := servePipelined(t, 3, 200*time.Millisecond)
+	defer stop()
+
+	conn, err := Dial(addr, time.Second)
+	g53.Assert(t, err == nil, "dial failed:%v", err)
+	defer conn.Close()
+
+	names := []string{"a.example.com.", "b.example.com.", "c.example.com."}
+	var wg sync.WaitGroup
+	errs := make([]error, len(names))
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			qname, _ := g53.NameFromString(name)
+			query := g53.MakeQuery(qname, g53.RR_A, 4096, false)
+			response, _, err := conn.Exchange(query, 2*time.Second)
+			if err == nil && response.Question.Name.Equals(qname) == false {
+				err = ErrConnClosed
+			}
+			errs[i] = err
+		}(i, name)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		g53.Assert(t, err == nil, "query %s failed:%v", names[i], err)
+	}
+
+	g53.Equal(t, conn.IdleTimeout(), 200*time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	g53.Equal(t, conn.Err(), ErrIdleTimeout)
+
+	qname, _ := g53.NameFromString("d.example.com.")
+	_, _, err = conn.Exchange(g53.MakeQuery(qname, g53.RR_A, 4096, false), time.Second)
+	g53.Equal(t, err, ErrIdleTimeout)
+}
+
+func TestConnTimeout(t *testing.T) {
+	addr, stop := servePipelined(t, 2, time.Second)
+	defer stop()
+
+	conn, err := Dial(addr, time.Second)
+	g53.Assert(t, err == nil, "dial failed:%v", err)
+	defer conn.Close()
+
+	qname, _ := g53.NameFromString("a.example.com.")
+	_, _, err = conn.Exchange(g53.MakeQuery(qname, g53.RR_A, 4096, false), 100*time.Millisecond)
+	g53.Assert(t, err != nil, "server waits for second query so the first should time out")
+	g53.Assert(t, conn.Err() == nil, "timeout of one query shouldn't close connection")
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+const DoHMediaType = "application/dns-message"
+
+// DoHClient sends queries over https as rfc8484, the id of query is set to
+// 0 to make the http responses cache friendly. Queries are sent by POST
+// unless UseGET is set
+type DoHClient struct {
+	Client *http.Client
+	UseGET bool
+}
+
+func NewDoHClient(client *http.Client) *DoHClient {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &DoHClient{Client: client}
+}
+
+func (c *DoHClient) newRequest(data []byte, url string) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	if c.UseGET {
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		req, err = http.NewRequest(http.MethodGet, url+sep+"dns="+base64.RawURLEncoding.EncodeToString(data), nil)
+	} else {
+		req, err = http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+		if err == nil {
+			req.Header.Set("Content-Type", DoHMediaType)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", DoHMediaType)
+	return req, nil
+}
+
+// Exchange sends query to the doh server at url, like
+// https://dns.example.com/dns-query
+func (c *DoHClient) Exchange(query *g53.Message, url string) (*g53.Message, time.Duration, error) {
+	query.Header.Id = 0
+	render := g53.NewMsgRender()
+	query.Rend(render)
+	req, err := c.newRequest(render.Data(), url)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := time.Now()
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("doh server returns %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != DoHMediaType {
+		return nil, 0, fmt.Errorf("unexpected content type %s", ct)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxTCPMessageLen+1))
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) > MaxTCPMessageLen {
+		return nil, 0, errors.New("doh response is too long")
+	}
+
+	response, err := g53.MessageFromWire(util.NewInputBuffer(data))
+	if err != nil {
+		return nil, 0, err
+	}
+	if IsResponseOf(query, response) == false {
+		return nil, 0, errors.New("doh response doesn't match query")
+	}
+	return response, time.Since(start), nil
+}
@@ -0,0 +1,188 @@
+package client
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+)
+
+var (
+	ErrRetryDelay   = errors.New("connection is closed by retry delay from server")
+	ErrDSORefused   = errors.New("server refuses dso session")
+	ErrDSOEstablish = errors.New("dso session is already established")
+)
+
+// dsoSession is the state of dso session on connection, keepalive request
+// is sent when there is no traffic in keepalive interval, and connection is
+// closed after inactivity timeout unless long-lived operations are held
+type dsoSession struct {
+	conn           *Conn
+	inactivity     time.Duration
+	interval       time.Duration
+	keepaliveTimer *time.Timer
+	holds          int
+}
+
+// nil session means dso isn't used, idle close follows edns-tcp-keepalive
+func (s *dsoSession) idleClosable() bool {
+	return s == nil || (s.inactivity > 0 && s.holds == 0)
+}
+
+// called with lock held
+func (s *dsoSession) update(ka *g53.KeepaliveTLV) {
+	s.inactivity = ka.Inactivity()
+	s.interval = ka.Interval()
+	s.conn.idleTimeout = s.inactivity
+	s.conn.idleTimer.Stop()
+	s.conn.resetIdleTimer()
+	s.resetKeepalive()
+}
+
+// called with lock held
+func (s *dsoSession) resetKeepalive() {
+	if s == nil {
+		return
+	}
+
+	if s.keepaliveTimer != nil {
+		s.keepaliveTimer.Stop()
+	}
+	if s.interval > 0 {
+		s.keepaliveTimer = time.AfterFunc(s.interval, s.sendKeepalive)
+	}
+}
+
+func (s *dsoSession) sendKeepalive() {
+	s.conn.lock.Lock()
+	ka := g53.NewKeepaliveTLV(s.inactivity, s.interval)
+	s.conn.lock.Unlock()
+	go s.conn.Exchange(g53.MakeDSO(ka), DefaultTimeout)
+}
+
+// EstablishDSO starts dso session of rfc8490 by keepalive request with the
+// timeouts client asks for, the session uses the timeouts server responds
+func (c *Conn) EstablishDSO(inactivity, interval, timeout time.Duration) (*g53.KeepaliveTLV, error) {
+	c.lock.Lock()
+	established := c.dso != nil
+	c.lock.Unlock()
+	if established {
+		return nil, ErrDSOEstablish
+	}
+
+	response, _, err := c.Exchange(g53.MakeDSO(g53.NewKeepaliveTLV(inactivity, interval)), timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Header.Rcode != g53.R_NOERROR {
+		if rd, ok := response.GetDSOTLV(g53.DSO_RETRY_DELAY).(*g53.RetryDelayTLV); ok {
+			c.lock.Lock()
+			c.retryDelay = rd.Duration()
+			c.lock.Unlock()
+		}
+		return nil, ErrDSORefused
+	}
+
+	ka, ok := response.DSOPrimary().(*g53.KeepaliveTLV)
+	if ok == false {
+		return nil, errors.New("dso response has no keepalive tlv")
+	}
+
+	return ka, nil
+}
+
+// session is established before the response is returned, so messages
+// from server right after the response aren't missed, called with lock held
+func (c *Conn) handleDSOResponse(response *g53.Message) {
+	ka, ok := response.DSOPrimary().(*g53.KeepaliveTLV)
+	if ok == false || response.Header.Rcode != g53.R_NOERROR {
+		return
+	}
+
+	if c.dso == nil {
+		c.dso = &dsoSession{conn: c}
+	}
+	c.dso.update(ka)
+}
+
+func (c *Conn) DSOEstablished() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.dso != nil
+}
+
+// RetryDelay is the time client shouldn't reconnect the server in, which is
+// sent by server when it closes the session
+func (c *Conn) RetryDelay() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.retryDelay
+}
+
+// HandleDSO sets the handler of dso messages initiated by server except
+// keepalive and retry delay, like push notifications. Handler should
+// respond to the request with non-zero id by Send
+func (c *Conn) HandleDSO(handler func(*g53.Message)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dsoHandler = handler
+}
+
+// HoldSession marks a long-lived operation like subscription, the session
+// isn't closed for inactivity until all the operations are released
+func (c *Conn) HoldSession() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.dso != nil {
+		c.dso.holds += 1
+		c.idleTimer.Stop()
+	}
+}
+
+func (c *Conn) ReleaseSession() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.dso != nil && c.dso.holds > 0 {
+		c.dso.holds -= 1
+		c.resetIdleTimer()
+	}
+}
+
+// messages from server are only valid after dso session is established,
+// unknown request with id is answered with DSOTYPENI
+func (c *Conn) handleServerMessage(msg *g53.Message) {
+	c.lock.Lock()
+	session := c.dso
+	handler := c.dsoHandler
+	c.lock.Unlock()
+	if session == nil || msg.Header.Opcode != g53.OP_DSO {
+		return
+	}
+
+	switch primary := msg.DSOPrimary().(type) {
+	case *g53.KeepaliveTLV:
+		if msg.Header.Id == 0 {
+			c.lock.Lock()
+			session.update(primary)
+			c.lock.Unlock()
+		}
+		return
+	case *g53.RetryDelayTLV:
+		if msg.Header.Id == 0 {
+			c.lock.Lock()
+			c.retryDelay = primary.Duration()
+			c.lock.Unlock()
+			c.closeWithErr(ErrRetryDelay)
+		}
+		return
+	}
+
+	if handler != nil {
+		handler(msg)
+	} else if msg.Header.Id != 0 {
+		response := msg.MakeResponse()
+		response.Header.Rcode = g53.R_DSOTYPENI
+		c.Send(response)
+	}
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+// serveDSO answers keepalive requests with the timeouts, and sends a dso
+// request with unknown tlv after the session is established
+func serveDSO(t *testing.T, inactivity, interval time.Duration, keepalives *int32, unknown chan *g53.Message) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed:%v", err)
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			data, err := ReadTCPMessage(conn)
+			if err != nil {
+				return
+			}
+			msg, _ := g53.MessageFromWire(util.NewInputBuffer(data))
+			if msg.Header.GetFlag(g53.FLAG_QR) {
+				unknown <- msg
+				continue
+			}
+			if _, ok := msg.DSOPrimary().(*g53.KeepaliveTLV); ok {
+				resp := msg.MakeResponse()
+				resp.DSO = []g53.DSOTLV{g53.NewKeepaliveTLV(inactivity, interval)}
+				WriteTCPMessage(conn, render(resp))
+				if atomic.AddInt32(keepalives, 1) == 1 {
+					req := g53.MakeDSO(&g53.UnknownTLV{Typ: 0x40})
+					req.Header.Id = 100
+					WriteTCPMessage(conn, render(req))
+				}
+			}
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestDSOKeepalive(t *testing.T) {
+	var keepalives int32
+	unknown := make(chan *g53.Message, 1)
+	addr, stop := serveDSO(t, 0, 50*time.Millisecond, &keepalives, unknown)
+	defer stop()
+
+	conn, err := Dial(addr, time.Second)
+	g53.Assert(t, err == nil, "dial failed:%v", err)
+	defer conn.Close()
+	_, err = conn.EstablishDSO(time.Second, time.Second, time.Second)
+	g53.Assert(t, err == nil, "establish dso failed:%v", err)
+
+	//request from server without handler
+	select {
+	case resp := <-unknown:
+		g53.Equal(t, resp.Header.Id, uint16(100))
+		g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_DSOTYPENI))
+	case <-time.After(time.Second):
+		t.Fatalf("dso request from server isn't answered")
+	}
+
+	//keepalive traffic is sent in keepalive interval, and connection with
+	//infinite inactivity timeout isn't closed
+	time.Sleep(300 * time.Millisecond)
+	g53.Assert(t, atomic.LoadInt32(&keepalives) >= 3, "keepalive should be sent periodically")
+	g53.Assert(t, conn.Err() == nil, "connection shouldn't be closed")
+}
+
+func TestDSOInactivity(t *testing.T) {
+	var keepalives int32
+	unknown := make(chan *g53.Message, 1)
+	addr, stop := serveDSO(t, 100*time.Millisecond, 0, &keepalives, unknown)
+	defer stop()
+
+	conn, err := Dial(addr, time.Second)
+	g53.Assert(t, err == nil, "dial failed:%v", err)
+	defer conn.Close()
+	conn.HandleDSO(func(*g53.Message) {})
+	_, err = conn.EstablishDSO(time.Second, time.Second, time.Second)
+	g53.Assert(t, err == nil, "establish dso failed:%v", err)
+
+	//long-lived operation keeps the session
+	conn.HoldSession()
+	time.Sleep(250 * time.Millisecond)
+	g53.Assert(t, conn.Err() == nil, "session with long-lived operation shouldn't be closed")
+
+	conn.ReleaseSession()
+	time.Sleep(250 * time.Millisecond)
+	g53.Equal(t, conn.Err(), ErrIdleTimeout)
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const MaxTCPMessageLen = 65535
+
+// WriteTCPMessage writes data with the two byte length prefix of tcp
+func WriteTCPMessage(w io.Writer, data []byte) error {
+	if len(data) > MaxTCPMessageLen {
+		return errors.New("message is too long for tcp")
+	}
+
+	buf := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(buf, uint16(len(data)))
+	copy(buf[2:], data)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadTCPMessage reads one length prefixed message
+func ReadTCPMessage(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+)
+
+const DefaultTLSPort = "853"
+
+// SPKIPin is the base64 encoded sha256 digest of the subject public key info
+// of cert, which is the pin format of rfc7858
+func SPKIPin(cert *x509.Certificate) string {
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// VerifySPKIPins accepts the server if its leaf certificate matches one of
+// the pins, other certificates in the chain aren't checked since the
+// handshake only proves the ownership of the leaf key
+func VerifySPKIPins(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate from server")
+		}
+
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+
+		pin := SPKIPin(cert)
+		for _, p := range pins {
+			if p == pin {
+				return nil
+			}
+		}
+		return errors.New("leaf certificate doesn't match spki pins")
+	}
+}
+
+// TLSClient sends queries over tls as rfc7858, one pipelined connection is
+// kept for each server and reused until it is closed. With Pins set the
+// server is authenticated by spki pinning instead of certificate chain
+type TLSClient struct {
+	Config  *tls.Config
+	Pins    []string
+	Timeout time.Duration
+	Padding int
+
+	lock  sync.Mutex
+	conns map[string]*Conn
+}
+
+func NewTLSClient(config *tls.Config, pins []string) *TLSClient {
+	return &TLSClient{
+		Config:  config,
+		Pins:    pins,
+		Timeout: DefaultTimeout,
+		Padding: g53.QUERY_PADDING_BLOCK,
+		conns:   make(map[string]*Conn),
+	}
+}
+
+func (c *TLSClient) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return c.Timeout
+}
+
+func (c *TLSClient) tlsConfig(server string) *tls.Config {
+	var config *tls.Config
+	if c.Config != nil {
+		config = c.Config.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(server); err == nil {
+			config.ServerName = host
+		}
+	}
+
+	if len(c.Pins) > 0 {
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = VerifySPKIPins(c.Pins)
+	}
+	return config
+}
+
+func (c *TLSClient) conn(server string) (*Conn, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conns == nil {
+		c.conns = make(map[string]*Conn)
+	}
+
+	if conn, ok := c.conns[server]; ok && conn.Err() == nil {
+		return conn, nil
+	}
+
+	dialer := &net.Dialer{Timeout: c.timeout()}
+	tlsConn, err := tls.DialWithDialer(dialer, "tcp", server, c.tlsConfig(server))
+	if err != nil {
+		return nil, err
+	}
+
+	conn := NewConn(tlsConn)
+	conn.SetPadding(c.Padding)
+	c.conns[server] = conn
+	return conn, nil
+}
+
+// Exchange sends query through the connection to server, a new connection
+// is made if the cached one has been closed by server
+func (c *TLSClient) Exchange(query *g53.Message, server string) (*g53.Message, time.Duration, error) {
+	var lastErr error
+	for i := 0; i < 2; i++ {
+		conn, err := c.conn(server)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		response, rtt, err := conn.Exchange(query, c.timeout())
+		if err == nil || conn.Err() == nil {
+			return response, rtt, err
+		}
+		lastErr = err
+	}
+	return nil, 0, lastErr
+}
+
+func (c *TLSClient) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for server, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, server)
+	}
+}
@@ -0,0 +1,140 @@
+package dns64
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mistletoeChao/g53"
+)
+
+// the well-known prefix of rfc 6052
+const WellKnownPrefix = "64:ff9b::/96"
+
+// bits 64 to 71 of the synthesized address, which is the u octet, must be
+// zero, so ipv4 address skips it
+const uOctet = 8
+
+var (
+	ErrInvalidPrefixLen = errors.New("prefix length should be 32, 40, 48, 56, 64 or 96")
+	ErrNonZeroUOctet    = errors.New("bits 64 to 71 of prefix should be zero")
+)
+
+var validPrefixLens = map[int]bool{
+	32: true,
+	40: true,
+	48: true,
+	56: true,
+	64: true,
+	96: true,
+}
+
+// ParsePrefix parses the ipv6 prefix in cidr notation, which ipv4 address
+// is embedded in
+func ParsePrefix(s string) (*net.IPNet, error) {
+	ip, prefix, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("%s isn't ipv6 prefix", s)
+	}
+	if err := checkPrefix(prefix); err != nil {
+		return nil, err
+	}
+	return prefix, nil
+}
+
+func checkPrefix(prefix *net.IPNet) error {
+	ones, bits := prefix.Mask.Size()
+	if bits != 8*net.IPv6len || validPrefixLens[ones] == false {
+		return ErrInvalidPrefixLen
+	}
+	if prefix.IP.To16()[uOctet] != 0 {
+		return ErrNonZeroUOctet
+	}
+	return nil
+}
+
+// octets of ipv6 address which hold the ipv4 address
+func ipv4Octets(prefix *net.IPNet) []int {
+	ones, _ := prefix.Mask.Size()
+	octets := make([]int, 0, net.IPv4len)
+	for i := ones / 8; len(octets) < net.IPv4len; i++ {
+		if i != uOctet {
+			octets = append(octets, i)
+		}
+	}
+	return octets
+}
+
+// Synthesize embeds ipv4 address in prefix as rfc 6052, the suffix is zero
+func Synthesize(prefix *net.IPNet, ip net.IP) net.IP {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil
+	}
+
+	ip6 := make(net.IP, net.IPv6len)
+	copy(ip6, prefix.IP.To16())
+	for i, octet := range ipv4Octets(prefix) {
+		ip6[octet] = ip4[i]
+	}
+	return ip6
+}
+
+// Extract returns the ipv4 address embedded in ip, nil if ip isn't
+// synthesized from prefix, whose u octet and suffix are zero
+func Extract(prefix *net.IPNet, ip net.IP) net.IP {
+	ip6 := ip.To16()
+	if ip6 == nil || ip.To4() != nil || prefix.Contains(ip6) == false {
+		return nil
+	}
+
+	ip4 := make(net.IP, net.IPv4len)
+	for i, octet := range ipv4Octets(prefix) {
+		ip4[i] = ip6[octet]
+	}
+	if Synthesize(prefix, ip4).Equal(ip6) == false {
+		return nil
+	}
+	return ip4
+}
+
+// reverseIPv6 returns the address of ip6.arpa name, nil if name isn't the
+// full 32 nibbles
+func reverseIPv6(name *g53.Name) net.IP {
+	s := strings.ToLower(name.String(false))
+	if strings.HasSuffix(s, ".ip6.arpa.") == false {
+		return nil
+	}
+
+	nibbles := strings.Split(strings.TrimSuffix(s, ".ip6.arpa."), ".")
+	if len(nibbles) != 2*net.IPv6len {
+		return nil
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	for i, nibble := range nibbles {
+		if len(nibble) != 1 {
+			return nil
+		}
+		v := strings.IndexByte("0123456789abcdef", nibble[0])
+		if v < 0 {
+			return nil
+		}
+		octet := net.IPv6len - 1 - i/2
+		if i%2 == 0 {
+			ip[octet] |= byte(v)
+		} else {
+			ip[octet] |= byte(v) << 4
+		}
+	}
+	return ip
+}
+
+func reverseIPv4(ip net.IP) (*g53.Name, error) {
+	ip4 := ip.To4()
+	return g53.NameFromString(fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0]))
+}
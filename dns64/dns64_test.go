@@ -0,0 +1,68 @@
+package dns64
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mistletoeChao/g53"
+)
+
+func mustPrefix(t *testing.T, s string) *net.IPNet {
+	prefix, err := ParsePrefix(s)
+	g53.Assert(t, err == nil, "parse prefix %s failed:%v", s, err)
+	return prefix
+}
+
+func TestSynthesize(t *testing.T) {
+	//examples of rfc 6052
+	cases := []struct {
+		prefix string
+		ip6    string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::192.0.2.33"},
+		{WellKnownPrefix, "64:ff9b::192.0.2.33"},
+	}
+
+	ip4 := net.ParseIP("192.0.2.33")
+	for _, c := range cases {
+		prefix := mustPrefix(t, c.prefix)
+		ip6 := Synthesize(prefix, ip4)
+		g53.Assert(t, ip6.Equal(net.ParseIP(c.ip6)), "synthesize in %s get %s", c.prefix, ip6)
+		g53.Assert(t, Extract(prefix, ip6).Equal(ip4), "extract from %s failed", ip6)
+	}
+
+	prefix := mustPrefix(t, "2001:db8:122:344::/64")
+	g53.Assert(t, Extract(prefix, net.ParseIP("2001:db8:122:344:c0:2:2100:1")) == nil, "suffix should be zero")
+	g53.Assert(t, Extract(prefix, net.ParseIP("2001:db8:122:345:c0:2:2100:0")) == nil, "address out of prefix")
+	g53.Assert(t, Synthesize(prefix, net.ParseIP("2001:db8::1")) == nil, "ipv6 address can't be embedded")
+}
+
+func TestParsePrefix(t *testing.T) {
+	_, err := ParsePrefix("2001:db8::/80")
+	g53.Equal(t, err, ErrInvalidPrefixLen)
+	_, err = ParsePrefix("2001:db8:0:0:ff00::/96")
+	g53.Equal(t, err, ErrNonZeroUOctet)
+	_, err = ParsePrefix("10.0.0.0/8")
+	g53.Assert(t, err != nil, "ipv4 prefix should fail")
+	_, err = ParsePrefix("2001:db8::")
+	g53.Assert(t, err != nil, "prefix length is needed")
+}
+
+func TestReverseName(t *testing.T) {
+	name, _ := g53.NameFromString("1.2.2.0.0.0.0.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.b.9.f.f.4.6.0.0.ip6.arpa.")
+	ip := reverseIPv6(name)
+	g53.Assert(t, ip.Equal(net.ParseIP("64:ff9b::c000:221")), "reverse name get %s", ip)
+
+	reverse, _ := reverseIPv4(Extract(mustPrefix(t, WellKnownPrefix), ip))
+	g53.Equal(t, reverse.String(false), "33.2.0.192.in-addr.arpa.")
+
+	for _, s := range []string{"b.9.f.f.4.6.0.0.ip6.arpa.", "33.2.0.192.in-addr.arpa.", "www.example.com."} {
+		name, _ := g53.NameFromString(s)
+		g53.Assert(t, reverseIPv6(name) == nil, "%s isn't full ip6.arpa name", s)
+	}
+}
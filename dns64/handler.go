@@ -0,0 +1,242 @@
+package dns64
+
+import (
+	"net"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/server"
+)
+
+// ttl of the cname synthesized for ptr query
+const CNameTTL = g53.RRTTL(300)
+
+// ipv4-mapped addresses are excluded by default as rfc 6147 suggests
+var defaultExclude = []string{"::ffff:0:0/96"}
+
+// Handler synthesizes AAAA records for ipv6 only clients as rfc 6147. When
+// the AAAA query to Next gets no answer, A query for the same name is sent
+// to Next and AAAA records are made by embedding the ipv4 addresses in
+// Prefix. AAAA records in Exclude are ignored as if they don't exist, and
+// ipv4 addresses in ExcludeIPv4 aren't synthesized. PTR query for address
+// synthesized from Prefix is answered by a cname to the in-addr.arpa name,
+// which is resolved by Next as well.
+//
+// Queries with both DO and CD set aren't synthesized, since the validating
+// client can't validate the synthesized records
+type Handler struct {
+	Next        server.Handler
+	Prefix      *net.IPNet
+	Exclude     []*net.IPNet
+	ExcludeIPv4 []*net.IPNet
+}
+
+func NewHandler(prefix *net.IPNet, next server.Handler) (*Handler, error) {
+	if err := checkPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	exclude, err := server.ParsePrefixes(defaultExclude...)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{
+		Next:    next,
+		Prefix:  prefix,
+		Exclude: exclude,
+	}, nil
+}
+
+func (h *Handler) ServeDNS(w server.ResponseWriter, req *g53.Message) {
+	q := req.Question
+	if req.Header.Opcode != g53.OP_QUERY || q == nil || q.Class != g53.CLASS_IN {
+		h.Next.ServeDNS(w, req)
+		return
+	}
+
+	switch q.Type {
+	case g53.RR_AAAA:
+		h.serveAAAA(w, req)
+	case g53.RR_PTR:
+		h.servePTR(w, req)
+	default:
+		h.Next.ServeDNS(w, req)
+	}
+}
+
+func (h *Handler) serveAAAA(w server.ResponseWriter, req *g53.Message) {
+	if req.Header.GetFlag(g53.FLAG_CD) && req.Edns != nil && req.Edns.DnssecAware {
+		h.Next.ServeDNS(w, req)
+		return
+	}
+
+	cw := server.NewCaptureWriter(w)
+	h.Next.ServeDNS(cw, req)
+	if cw.Response == nil {
+		return
+	}
+
+	if h.needSynthesis(cw.Response) {
+		if resp := h.synthesize(w, req, cw.Response); resp != nil {
+			w.Write(resp)
+			return
+		}
+	}
+	cw.Flush()
+}
+
+// name error is returned as it is, other errors are treated as no answer
+func (h *Handler) needSynthesis(resp *g53.Message) bool {
+	if resp.Header.Rcode == g53.R_NXDOMAIN || resp.Header.GetFlag(g53.FLAG_TC) {
+		return false
+	}
+
+	for _, rrset := range resp.Sections[g53.AnswerSection] {
+		if rrset.Type != g53.RR_AAAA {
+			continue
+		}
+		for _, rdata := range rrset.Rdatas {
+			if inPrefixes(h.Exclude, rdata.(*g53.AAAA).Host) == false {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nil is returned if there is no address to synthesize from
+func (h *Handler) synthesize(w server.ResponseWriter, req *g53.Message, aaaaResp *g53.Message) *g53.Message {
+	query := server.CopyQuery(req)
+	query.Question = &g53.Question{
+		Name:  req.Question.Name,
+		Type:  g53.RR_A,
+		Class: req.Question.Class,
+	}
+	cw := server.NewCaptureWriter(w)
+	h.Next.ServeDNS(cw, query)
+	if cw.Response == nil || cw.Response.Header.Rcode != g53.R_NOERROR {
+		return nil
+	}
+
+	maxTTL, capped := negativeTTL(aaaaResp)
+	var answer g53.Section
+	synthesized := false
+	for _, rrset := range cw.Response.Sections[g53.AnswerSection] {
+		switch rrset.Type {
+		case g53.RR_CNAME, g53.RR_DNAME:
+			answer = append(answer, rrset)
+		case g53.RR_A:
+			if aaaa := h.synthesizeRRset(rrset); aaaa != nil {
+				if capped && aaaa.Ttl > maxTTL {
+					aaaa.Ttl = maxTTL
+				}
+				answer = append(answer, aaaa)
+				synthesized = true
+			}
+		}
+	}
+	if synthesized == false {
+		return nil
+	}
+
+	header := *cw.Response.Header
+	header.Id = req.Header.Id
+	header.SetFlag(g53.FLAG_AD, false)
+	resp := &g53.Message{
+		Header:   &header,
+		Question: req.Question,
+		Edns:     cw.Response.Edns,
+	}
+	resp.Sections[g53.AnswerSection] = answer
+	return resp
+}
+
+func (h *Handler) synthesizeRRset(a *g53.RRset) *g53.RRset {
+	aaaa := &g53.RRset{
+		Name:  a.Name,
+		Type:  g53.RR_AAAA,
+		Class: a.Class,
+		Ttl:   a.Ttl,
+	}
+	for _, rdata := range a.Rdatas {
+		ip := rdata.(*g53.A).Host
+		if inPrefixes(h.ExcludeIPv4, ip) == false {
+			aaaa.AddRdata(&g53.AAAA{Host: Synthesize(h.Prefix, ip)})
+		}
+	}
+	if len(aaaa.Rdatas) == 0 {
+		return nil
+	}
+	return aaaa
+}
+
+// ttl of synthesized records shouldn't exceed the ttl of negative answer
+func negativeTTL(resp *g53.Message) (g53.RRTTL, bool) {
+	for _, rrset := range resp.Sections[g53.AuthSection] {
+		if rrset.Type == g53.RR_SOA && len(rrset.Rdatas) > 0 {
+			ttl := rrset.Ttl
+			if minimum := g53.RRTTL(rrset.Rdatas[0].(*g53.SOA).Minimum); minimum < ttl {
+				ttl = minimum
+			}
+			return ttl, true
+		}
+	}
+	return 0, false
+}
+
+func (h *Handler) servePTR(w server.ResponseWriter, req *g53.Message) {
+	var ip4 net.IP
+	if ip := reverseIPv6(req.Question.Name); ip != nil {
+		ip4 = Extract(h.Prefix, ip)
+	}
+	if ip4 == nil {
+		h.Next.ServeDNS(w, req)
+		return
+	}
+
+	target, err := reverseIPv4(ip4)
+	if err != nil {
+		h.Next.ServeDNS(w, req)
+		return
+	}
+
+	resp := req.MakeResponse()
+	resp.Header.SetFlag(g53.FLAG_RA, true)
+	if req.Edns != nil {
+		resp.Edns = &g53.EDNS{UdpSize: req.Edns.UdpSize}
+	}
+	resp.AddRRset(g53.AnswerSection, &g53.RRset{
+		Name:   req.Question.Name,
+		Type:   g53.RR_CNAME,
+		Class:  req.Question.Class,
+		Ttl:    CNameTTL,
+		Rdatas: []g53.Rdata{&g53.CName{Name: target}},
+	})
+
+	query := server.CopyQuery(req)
+	query.Question = &g53.Question{
+		Name:  target,
+		Type:  g53.RR_PTR,
+		Class: req.Question.Class,
+	}
+	cw := server.NewCaptureWriter(w)
+	h.Next.ServeDNS(cw, query)
+	if cw.Response != nil {
+		resp.Header.Rcode = cw.Response.Header.Rcode
+		for _, rrset := range cw.Response.Sections[g53.AnswerSection] {
+			resp.AddRRset(g53.AnswerSection, rrset)
+		}
+		for _, rrset := range cw.Response.Sections[g53.AuthSection] {
+			resp.AddRRset(g53.AuthSection, rrset)
+		}
+	}
+	w.Write(resp)
+}
+
+func inPrefixes(prefixes []*net.IPNet, ip net.IP) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
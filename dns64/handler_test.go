@@ -0,0 +1,127 @@
+package dns64
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/server"
+)
+
+type recordWriter struct {
+	resp *g53.Message
+}
+
+func (w *recordWriter) LocalAddr() net.Addr           { return nil }
+func (w *recordWriter) RemoteAddr() net.Addr          { return nil }
+func (w *recordWriter) Transport() server.Transport   { return server.TRANSPORT_UDP }
+func (w *recordWriter) WriteData(data []byte) error   { return nil }
+func (w *recordWriter) Write(resp *g53.Message) error { w.resp = resp; return nil }
+
+// upstream serves example.com., v4 has only ipv4 address, v6 has both, the
+// AAAA of mapped is ipv4-mapped, alias is a cname of v4 and private has
+// only excluded ipv4 address
+func upstream(queries *[]string) server.Handler {
+	data := map[string][]*g53.RRset{
+		"v6.example.com. AAAA":         {g53.BuildRRset("v6.example.com.", g53.RR_AAAA, 300, "2001:db8::1")},
+		"v6.example.com. A":            {g53.BuildRRset("v6.example.com.", g53.RR_A, 300, "192.0.2.1")},
+		"v4.example.com. A":            {g53.BuildRRset("v4.example.com.", g53.RR_A, 3600, "192.0.2.33", "10.0.0.1")},
+		"mapped.example.com. AAAA":     {g53.BuildRRset("mapped.example.com.", g53.RR_AAAA, 300, "::ffff:192.0.2.2")},
+		"mapped.example.com. A":        {g53.BuildRRset("mapped.example.com.", g53.RR_A, 300, "192.0.2.2")},
+		"alias.example.com. AAAA":      {g53.BuildRRset("alias.example.com.", g53.RR_CNAME, 300, "v4.example.com.")},
+		"private.example.com. A":       {g53.BuildRRset("private.example.com.", g53.RR_A, 300, "10.0.0.2")},
+		"33.2.0.192.in-addr.arpa. PTR": {g53.BuildRRset("33.2.0.192.in-addr.arpa.", g53.RR_PTR, 300, "v4.example.com.")},
+		"alias.example.com. A": {
+			g53.BuildRRset("alias.example.com.", g53.RR_CNAME, 300, "v4.example.com."),
+			g53.BuildRRset("v4.example.com.", g53.RR_A, 3600, "192.0.2.33"),
+		},
+	}
+
+	return server.HandlerFunc(func(w server.ResponseWriter, req *g53.Message) {
+		name := req.Question.Name.String(false)
+		key := name + " " + strings.ToUpper(req.Question.Type.String())
+		*queries = append(*queries, key)
+
+		resp := req.MakeResponse()
+		if strings.HasPrefix(name, "nx.") {
+			resp.Header.Rcode = g53.R_NXDOMAIN
+		}
+		for _, rrset := range data[key] {
+			resp.AddRRset(g53.AnswerSection, rrset)
+		}
+		if len(resp.Sections[g53.AnswerSection]) == 0 {
+			resp.AddRRset(g53.AuthSection, g53.BuildRRset("example.com.", g53.RR_SOA, 3600, "ns.example.com. root.example.com. 1 3600 900 60"))
+		}
+		w.Write(resp)
+	})
+}
+
+func TestHandler(t *testing.T) {
+	var queries []string
+	h, err := NewHandler(mustPrefix(t, WellKnownPrefix), upstream(&queries))
+	g53.Assert(t, err == nil, "new handler failed:%v", err)
+	h.ExcludeIPv4, _ = server.ParsePrefixes("10.0.0.0/8")
+
+	serve := func(name string, typ g53.RRType) *g53.Message {
+		queries = nil
+		n, _ := g53.NameFromString(name)
+		w := &recordWriter{}
+		h.ServeDNS(w, g53.MakeQuery(n, typ, 4096, false))
+		return w.resp
+	}
+	answer := func(resp *g53.Message) []string {
+		var rrs []string
+		for _, rrset := range resp.Sections[g53.AnswerSection] {
+			rrs = append(rrs, strings.Split(strings.TrimSpace(rrset.String()), "\n")...)
+		}
+		return rrs
+	}
+
+	resp := serve("v6.example.com.", g53.RRType(g53.RR_AAAA))
+	g53.Equal(t, answer(resp), []string{"v6.example.com.\t300\tIN\tAAAA\t2001:db8::1"})
+	g53.Equal(t, queries, []string{"v6.example.com. AAAA"})
+
+	//ttl is capped by the negative ttl, excluded ipv4 isn't synthesized
+	resp = serve("v4.example.com.", g53.RRType(g53.RR_AAAA))
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, answer(resp), []string{"v4.example.com.\t60\tIN\tAAAA\t64:ff9b::c000:221"})
+	g53.Equal(t, len(resp.Sections[g53.AuthSection]), 0)
+	g53.Equal(t, queries, []string{"v4.example.com. AAAA", "v4.example.com. A"})
+
+	resp = serve("mapped.example.com.", g53.RRType(g53.RR_AAAA))
+	g53.Equal(t, answer(resp), []string{"mapped.example.com.\t300\tIN\tAAAA\t64:ff9b::c000:202"})
+
+	resp = serve("alias.example.com.", g53.RRType(g53.RR_AAAA))
+	g53.Equal(t, answer(resp), []string{
+		"alias.example.com.\t300\tIN\tCNAME\tv4.example.com.",
+		"v4.example.com.\t3600\tIN\tAAAA\t64:ff9b::c000:221",
+	})
+
+	resp = serve("private.example.com.", g53.RRType(g53.RR_AAAA))
+	g53.Equal(t, len(resp.Sections[g53.AnswerSection]), 0)
+	g53.Equal(t, resp.Sections[g53.AuthSection][0].Type, g53.RRType(g53.RR_SOA))
+
+	resp = serve("nx.example.com.", g53.RRType(g53.RR_AAAA))
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NXDOMAIN))
+	g53.Equal(t, queries, []string{"nx.example.com. AAAA"})
+
+	//validating client gets the original response
+	n, _ := g53.NameFromString("v4.example.com.")
+	req := g53.MakeQuery(n, g53.RRType(g53.RR_AAAA), 4096, true)
+	req.Header.SetFlag(g53.FLAG_CD, true)
+	w := &recordWriter{}
+	h.ServeDNS(w, req)
+	g53.Equal(t, len(w.resp.Sections[g53.AnswerSection]), 0)
+
+	resp = serve("1.2.2.0.0.0.0.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.b.9.f.f.4.6.0.0.ip6.arpa.", g53.RR_PTR)
+	g53.Equal(t, answer(resp), []string{
+		"1.2.2.0.0.0.0.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.b.9.f.f.4.6.0.0.ip6.arpa.\t300\tIN\tCNAME\t33.2.0.192.in-addr.arpa.",
+		"33.2.0.192.in-addr.arpa.\t300\tIN\tPTR\tv4.example.com.",
+	})
+
+	//ptr out of prefix goes to next
+	resp = serve("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", g53.RR_PTR)
+	g53.Equal(t, len(resp.Sections[g53.AnswerSection]), 0)
+	g53.Equal(t, len(queries), 1)
+}
@@ -0,0 +1,271 @@
+package g53
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mistletoeChao/g53/util"
+)
+
+type DSOType uint16
+
+const (
+	DSO_KEEPALIVE          DSOType = 1
+	DSO_RETRY_DELAY                = 2
+	DSO_ENCRYPTION_PADDING         = 3
+)
+
+var DSOTypeStr = map[DSOType]string{
+	DSO_KEEPALIVE:          "KEEPALIVE",
+	DSO_RETRY_DELAY:        "RETRY-DELAY",
+	DSO_ENCRYPTION_PADDING: "ENCRYPTION-PADDING",
+}
+
+func (t DSOType) String() string {
+	if s, ok := DSOTypeStr[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("TYPE%d", uint16(t))
+}
+
+// timeout or interval which never expires
+const DSO_INFINITE = 0xffffffff
+
+var ErrInvalidDSO = errors.New("dso message with non-zero section count")
+
+// DSOTLV is one tlv of dso message of rfc8490, the first tlv of request is
+// the primary tlv which decides the operation, the rest are additional tlvs
+type DSOTLV interface {
+	Type() DSOType
+	Rend(*MsgRender)
+	String() string
+}
+
+// KeepaliveTLV has the inactivity timeout and keepalive interval of the
+// session in milliseconds
+type KeepaliveTLV struct {
+	InactivityTimeout uint32
+	KeepaliveInterval uint32
+}
+
+func NewKeepaliveTLV(inactivity, interval time.Duration) *KeepaliveTLV {
+	return &KeepaliveTLV{
+		InactivityTimeout: dsoMilliseconds(inactivity),
+		KeepaliveInterval: dsoMilliseconds(interval),
+	}
+}
+
+func (ka *KeepaliveTLV) Type() DSOType {
+	return DSO_KEEPALIVE
+}
+
+func (ka *KeepaliveTLV) Rend(render *MsgRender) {
+	render.WriteUint16(uint16(DSO_KEEPALIVE))
+	render.WriteUint16(8)
+	render.WriteUint32(ka.InactivityTimeout)
+	render.WriteUint32(ka.KeepaliveInterval)
+}
+
+func (ka *KeepaliveTLV) String() string {
+	return fmt.Sprintf("; KEEPALIVE: inactivity %s, interval %s\n",
+		dsoDurationStr(ka.InactivityTimeout), dsoDurationStr(ka.KeepaliveInterval))
+}
+
+// durations of 0xffffffff are returned as 0 which means never expire
+func (ka *KeepaliveTLV) Inactivity() time.Duration {
+	return dsoDuration(ka.InactivityTimeout)
+}
+
+func (ka *KeepaliveTLV) Interval() time.Duration {
+	return dsoDuration(ka.KeepaliveInterval)
+}
+
+// RetryDelayTLV is sent by server to ask client to close the session and
+// not to reconnect in Delay milliseconds
+type RetryDelayTLV struct {
+	Delay uint32
+}
+
+func (rd *RetryDelayTLV) Type() DSOType {
+	return DSO_RETRY_DELAY
+}
+
+func (rd *RetryDelayTLV) Rend(render *MsgRender) {
+	render.WriteUint16(uint16(DSO_RETRY_DELAY))
+	render.WriteUint16(4)
+	render.WriteUint32(rd.Delay)
+}
+
+func (rd *RetryDelayTLV) String() string {
+	return fmt.Sprintf("; RETRY-DELAY: %s\n", dsoDurationStr(rd.Delay))
+}
+
+func (rd *RetryDelayTLV) Duration() time.Duration {
+	return time.Duration(rd.Delay) * time.Millisecond
+}
+
+// PaddingTLV is zero filled data which can only be an additional tlv
+type PaddingTLV struct {
+	Length uint16
+}
+
+func (p *PaddingTLV) Type() DSOType {
+	return DSO_ENCRYPTION_PADDING
+}
+
+func (p *PaddingTLV) Rend(render *MsgRender) {
+	render.WriteUint16(uint16(DSO_ENCRYPTION_PADDING))
+	render.WriteUint16(p.Length)
+	render.WriteData(make([]byte, p.Length))
+}
+
+func (p *PaddingTLV) String() string {
+	return fmt.Sprintf("; ENCRYPTION-PADDING: %d bytes\n", p.Length)
+}
+
+// UnknownTLV keeps tlv which isn't supported, so the receiver could answer
+// DSOTYPENI for unknown primary tlv
+type UnknownTLV struct {
+	Typ  DSOType
+	Data []byte
+}
+
+func (u *UnknownTLV) Type() DSOType {
+	return u.Typ
+}
+
+func (u *UnknownTLV) Rend(render *MsgRender) {
+	render.WriteUint16(uint16(u.Typ))
+	render.WriteUint16(uint16(len(u.Data)))
+	render.WriteData(u.Data)
+}
+
+func (u *UnknownTLV) String() string {
+	return fmt.Sprintf("; %s: %x\n", u.Typ.String(), u.Data)
+}
+
+func dsoMilliseconds(d time.Duration) uint32 {
+	if d <= 0 || d/time.Millisecond >= DSO_INFINITE {
+		return DSO_INFINITE
+	}
+	return uint32(d / time.Millisecond)
+}
+
+func dsoDuration(ms uint32) time.Duration {
+	if ms == DSO_INFINITE {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+func dsoDurationStr(ms uint32) string {
+	if ms == DSO_INFINITE {
+		return "infinite"
+	}
+	return dsoDuration(ms).String()
+}
+
+func dsoTLVFromWire(buffer *util.InputBuffer) (DSOTLV, error) {
+	t, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+
+	switch DSOType(t) {
+	case DSO_KEEPALIVE:
+		if l != 8 {
+			return nil, fmt.Errorf("invalid keepalive tlv length %d", l)
+		}
+		inactivity, err := buffer.ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		interval, err := buffer.ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		return &KeepaliveTLV{
+			InactivityTimeout: inactivity,
+			KeepaliveInterval: interval,
+		}, nil
+	case DSO_RETRY_DELAY:
+		if l != 4 {
+			return nil, fmt.Errorf("invalid retry delay tlv length %d", l)
+		}
+		delay, err := buffer.ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		return &RetryDelayTLV{Delay: delay}, nil
+	}
+
+	data, err := buffer.ReadBytes(uint(l))
+	if err != nil {
+		return nil, err
+	}
+	if DSOType(t) == DSO_ENCRYPTION_PADDING {
+		return &PaddingTLV{Length: l}, nil
+	}
+	return &UnknownTLV{
+		Typ:  DSOType(t),
+		Data: append([]byte{}, data...),
+	}, nil
+}
+
+// dso message has no question and rrs, tlvs take the rest of the message
+func dsoFromWire(h *Header, buffer *util.InputBuffer) ([]DSOTLV, error) {
+	if h.QDCount != 0 || h.ANCount != 0 || h.NSCount != 0 || h.ARCount != 0 {
+		return nil, ErrInvalidDSO
+	}
+
+	var tlvs []DSOTLV
+	for buffer.Len() > buffer.Position() {
+		tlv, err := dsoTLVFromWire(buffer)
+		if err != nil {
+			return nil, err
+		}
+		tlvs = append(tlvs, tlv)
+	}
+	return tlvs, nil
+}
+
+// MakeDSO creates dso request, the id should be set to 0 for unidirectional
+// message which expects no response
+func MakeDSO(primary DSOTLV, additional ...DSOTLV) *Message {
+	return &Message{
+		Header: &Header{Opcode: OP_DSO},
+		DSO:    append([]DSOTLV{primary}, additional...),
+	}
+}
+
+// DSOPrimary returns the primary tlv of dso message, nil if there is none
+func (m *Message) DSOPrimary() DSOTLV {
+	if m.Header.Opcode != OP_DSO || len(m.DSO) == 0 {
+		return nil
+	}
+	return m.DSO[0]
+}
+
+// GetDSOTLV returns the first tlv of typ in dso message
+func (m *Message) GetDSOTLV(typ DSOType) DSOTLV {
+	for _, tlv := range m.DSO {
+		if tlv.Type() == typ {
+			return tlv
+		}
+	}
+	return nil
+}
+
+func (m *Message) dsoString(buf *bytes.Buffer) {
+	buf.WriteString(";; DSO SECTION:\n")
+	for _, tlv := range m.DSO {
+		buf.WriteString(tlv.String())
+	}
+}
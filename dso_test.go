@@ -0,0 +1,71 @@
+package g53
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53/util"
+)
+
+func TestDSOFromToWire(t *testing.T) {
+	wire, _ := util.HexStrToBytes("123430000000000000000000" +
+		"00010008" + "0000ea60" + "00003a98" +
+		"00030004" + "00000000" +
+		"00400002" + "abcd")
+	msg, err := MessageFromWire(util.NewInputBuffer(wire))
+	Assert(t, err == nil, "dso message is valid:%v", err)
+	Equal(t, msg.Header.Opcode, Opcode(OP_DSO))
+	Equal(t, len(msg.DSO), 3)
+
+	ka, ok := msg.DSOPrimary().(*KeepaliveTLV)
+	Assert(t, ok, "primary tlv should be keepalive")
+	Equal(t, ka.Inactivity(), time.Minute)
+	Equal(t, ka.Interval(), 15*time.Second)
+	Equal(t, msg.GetDSOTLV(DSO_ENCRYPTION_PADDING).(*PaddingTLV).Length, uint16(4))
+	unknown := msg.GetDSOTLV(DSOType(0x40)).(*UnknownTLV)
+	WireMatch(t, unknown.Data, []byte{0xab, 0xcd})
+	Assert(t, msg.GetDSOTLV(DSO_RETRY_DELAY) == nil, "retry delay tlv doesn't exist")
+	Assert(t, strings.Contains(msg.String(), "KEEPALIVE: inactivity 1m0s, interval 15s"), "keepalive should be printed")
+
+	render := NewMsgRender()
+	msg.Rend(render)
+	WireMatch(t, render.Data(), wire)
+
+	//dso with question
+	wire, _ = util.HexStrToBytes("123430000001000000000000")
+	_, err = MessageFromWire(util.NewInputBuffer(wire))
+	Equal(t, err, ErrInvalidDSO)
+
+	//truncated tlv
+	wire, _ = util.HexStrToBytes("123430000000000000000000" + "00020004" + "0000")
+	_, err = MessageFromWire(util.NewInputBuffer(wire))
+	Assert(t, err != nil, "truncated tlv should fail")
+
+	wire, _ = util.HexStrToBytes("123430000000000000000000" + "00010004" + "00000000")
+	_, err = MessageFromWire(util.NewInputBuffer(wire))
+	Assert(t, err != nil, "keepalive tlv with invalid length should fail")
+}
+
+func TestMakeDSO(t *testing.T) {
+	msg := MakeDSO(NewKeepaliveTLV(0, 10*time.Second), &PaddingTLV{Length: 2})
+	msg.Header.Id = 1
+	render := NewMsgRender()
+	msg.Rend(render)
+	expect, _ := util.HexStrToBytes("000130000000000000000000" +
+		"00010008" + "ffffffff" + "00002710" +
+		"00030002" + "0000")
+	WireMatch(t, render.Data(), expect)
+
+	msg, _ = MessageFromWire(util.NewInputBuffer(render.Data()))
+	ka := msg.DSOPrimary().(*KeepaliveTLV)
+	Equal(t, ka.Inactivity(), time.Duration(0))
+	Equal(t, ka.InactivityTimeout, uint32(DSO_INFINITE))
+
+	rd := &RetryDelayTLV{Delay: 1500}
+	msg = MakeDSO(rd)
+	render.Clear()
+	msg.Rend(render)
+	msg, _ = MessageFromWire(util.NewInputBuffer(render.Data()))
+	Equal(t, msg.DSOPrimary().(*RetryDelayTLV).Duration(), 1500*time.Millisecond)
+}
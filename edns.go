@@ -49,24 +49,14 @@ func EdnsFromWire(buffer *util.InputBuffer) (*EDNS, error) {
 	extendedRcode := uint8(uint32(flags_) >> EXTRCODE_SHIFT)
 	version := uint8((uint32(flags_) & VERSION_MASK) >> VERSION_SHIFT)
 
-	rdlen, _ := buffer.ReadUint16()
-	options := []Option{}
-	if rdlen != 0 {
-		code, _ := buffer.ReadUint16()
-		switch code {
-		case EDNS_SUBNET:
-			if opt, err := subnetOptFromWire(buffer); err == nil {
-				options = append(options, opt)
-			} else {
-				return nil, err
-			}
-		case EDNS_VIEW:
-			if opt, err := viewOptFromWire(buffer); err == nil {
-				options = append(options, opt)
-			} else {
-				return nil, err
-			}
-		}
+	rdlen, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := optionsFromWire(buffer, rdlen)
+	if err != nil {
+		return nil, err
 	}
 
 	return &EDNS{
@@ -87,12 +77,10 @@ func EdnsFromRRset(rrset *RRset) *EDNS {
 	version := uint8((flags & VERSION_MASK) >> VERSION_SHIFT)
 
 	options := []Option{}
-	if len(rrset.Rdatas) > 0 {
-		for _, rdata := range rrset.Rdatas {
-			opt := subnetOptFromRdata(rdata)
-			if opt != nil {
-				options = append(options, opt)
-			}
+	for _, rdata := range rrset.Rdatas {
+		data := rdata.(*OPT).Data
+		if opts, err := optionsFromWire(util.NewInputBuffer(data), uint16(len(data))); err == nil {
+			options = append(options, opts...)
 		}
 	}
 
@@ -105,6 +93,54 @@ func EdnsFromRRset(rrset *RRset) *EDNS {
 	}
 }
 
+// option parser reads from OPTION-LENGTH, unknown options are skipped
+func optionFromWire(code uint16, buffer *util.InputBuffer) (Option, error) {
+	switch code {
+	case EDNS_SUBNET:
+		return subnetOptFromWire(buffer)
+	case EDNS_VIEW:
+		return viewOptFromWire(buffer)
+	case EDNS_TCP_KEEPALIVE:
+		return keepaliveOptFromWire(buffer)
+	case EDNS_PADDING:
+		return paddingOptFromWire(buffer)
+	case EDNS_EDE:
+		return extendedErrorOptFromWire(buffer)
+	default:
+		l, err := buffer.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+		_, err = buffer.ReadBytes(uint(l))
+		return nil, err
+	}
+}
+
+func optionsFromWire(buffer *util.InputBuffer, rdlen uint16) ([]Option, error) {
+	options := []Option{}
+	end := buffer.Position() + uint(rdlen)
+	for buffer.Position() < end {
+		code, err := buffer.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+
+		opt, err := optionFromWire(code, buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		if opt != nil {
+			options = append(options, opt)
+		}
+	}
+
+	if buffer.Position() != end {
+		return nil, errors.New("edns option exceeds rdata")
+	}
+	return options, nil
+}
+
 func (e *EDNS) Rend(r *MsgRender) {
 	flags := uint32(e.extendedRcode) << EXTRCODE_SHIFT
 	flags |= (uint32(e.Version) << VERSION_SHIFT) & VERSION_MASK
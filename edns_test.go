@@ -2,8 +2,10 @@ package g53
 
 import (
 	//	"fmt"
-	"g53/util"
+	"github.com/mistletoeChao/g53/util"
+	"net"
 	"testing"
+	"time"
 )
 
 func matchEdns(t *testing.T, rawData string, expectEdns EDNS) {
@@ -47,3 +49,110 @@ func TestEdnsFromToWire(t *testing.T) {
 		DnssecAware:   true,
 	})
 }
+
+func TestEdnsKeepalive(t *testing.T) {
+	edns := &EDNS{
+		UdpSize: 4096,
+		Options: []Option{&KeepaliveOpt{}},
+	}
+	render := NewMsgRender()
+	edns.Rend(render)
+	parsed, err := EdnsFromWire(util.NewInputBuffer(render.Data()))
+	Assert(t, err == nil, "edns with keepalive should be valid:%v", err)
+	ka := parsed.KeepaliveOpt()
+	Assert(t, ka != nil && ka.HasTimeout == false, "client keepalive has no timeout")
+
+	edns.Options = []Option{NewKeepaliveOpt(12 * time.Second)}
+	render.Clear()
+	edns.Rend(render)
+	parsed, _ = EdnsFromWire(util.NewInputBuffer(render.Data()))
+	Equal(t, parsed.KeepaliveOpt().Duration(), 12*time.Second)
+}
+
+func TestPadMessage(t *testing.T) {
+	name, _ := NameFromString("www.example.com.")
+	for _, block := range []int{QUERY_PADDING_BLOCK, RESPONSE_PADDING_BLOCK} {
+		msg := MakeQuery(name, RR_A, 4096, false)
+		msg.Edns.AddSubnetV4("1.1.1.1")
+		PadMessage(msg, block)
+		PadMessage(msg, block)
+		render := NewMsgRender()
+		msg.Rend(render)
+		Equal(t, int(render.Len())%block, 0)
+
+		parsed, err := MessageFromWire(util.NewInputBuffer(render.Data()))
+		Assert(t, err == nil, "padded message should be valid:%v", err)
+		Equal(t, len(parsed.Edns.Options), 2)
+		Assert(t, parsed.Edns.PaddingOpt() != nil, "padding should be parsed")
+	}
+}
+
+func TestPadMessageSharedOptions(t *testing.T) {
+	name, _ := NameFromString("www.example.com.")
+	shared := []Option{&PaddingOpt{Length: 10}, &ExtendedErrorOpt{InfoCode: EDE_BLOCKED}}
+	msg := MakeQuery(name, RR_A, 4096, false)
+	msg.Edns.Options = shared
+	PadMessage(msg, QUERY_PADDING_BLOCK)
+	Equal(t, len(msg.Edns.Options), 2)
+	po, ok := shared[0].(*PaddingOpt)
+	Assert(t, ok && po.Length == 10, "shared padding shouldn't be modified")
+	_, ok = shared[1].(*ExtendedErrorOpt)
+	Assert(t, ok, "shared options shouldn't be reordered")
+}
+
+func TestEdnsExtendedError(t *testing.T) {
+	edns := &EDNS{
+		UdpSize: 4096,
+		Options: []Option{
+			&ExtendedErrorOpt{InfoCode: EDE_STALE_ANSWER},
+			&ExtendedErrorOpt{InfoCode: EDE_BLOCKED, ExtraText: "blocked by policy"},
+		},
+	}
+	render := NewMsgRender()
+	edns.Rend(render)
+	parsed, err := EdnsFromWire(util.NewInputBuffer(render.Data()))
+	Assert(t, err == nil, "edns with extended error should be valid:%v", err)
+	Equal(t, len(parsed.Options), 2)
+	eo := parsed.ExtendedErrorOpt()
+	Equal(t, eo.InfoCode, ExtendedErrorCode(EDE_STALE_ANSWER))
+	Equal(t, eo.ExtraText, "")
+	Equal(t, eo.String(), "; EDE: 3 (Stale Answer)\n")
+	eo = parsed.Options[1].(*ExtendedErrorOpt)
+	Equal(t, eo.InfoCode, ExtendedErrorCode(EDE_BLOCKED))
+	Equal(t, eo.ExtraText, "blocked by policy")
+}
+
+func TestEdnsSubnet(t *testing.T) {
+	subnet := NewSubnetOpt(net.ParseIP("192.168.10.10"), 24)
+	subnet.SetScopePrefix(16)
+	edns := &EDNS{
+		UdpSize: 4096,
+		Options: []Option{subnet},
+	}
+	render := NewMsgRender()
+	edns.Rend(render)
+	parsed, err := EdnsFromWire(util.NewInputBuffer(render.Data()))
+	Assert(t, err == nil, "edns with subnet should be valid:%v", err)
+	subnet = parsed.SubnetOpt()
+	Equal(t, subnet.Family(), uint16(1))
+	Equal(t, subnet.SourcePrefix(), uint8(24))
+	Equal(t, subnet.ScopePrefix(), uint8(16))
+	Equal(t, subnet.Network(24).String(), "192.168.10.0/24")
+	Equal(t, subnet.Network(16).String(), "192.168.0.0/16")
+
+	subnet = NewSubnetOpt(net.ParseIP("2001:db8:1:2::1"), 56)
+	Equal(t, subnet.Family(), uint16(2))
+	Equal(t, subnet.Network(200).String(), "2001:db8:1::/128")
+	Equal(t, subnet.Network(48).String(), "2001:db8:1::/48")
+}
+
+func TestEdnsView(t *testing.T) {
+	edns := &EDNS{UdpSize: 4096}
+	edns.AddSubnetView("internal")
+	render := NewMsgRender()
+	edns.Rend(render)
+	parsed, err := EdnsFromWire(util.NewInputBuffer(render.Data()))
+	Assert(t, err == nil, "edns with view should be valid:%v", err)
+	Equal(t, parsed.ViewOpt().View(), "internal")
+	Assert(t, parsed.SubnetOpt() == nil, "edns has no subnet")
+}
@@ -0,0 +1,133 @@
+package g53
+
+import (
+	"fmt"
+
+	"github.com/mistletoeChao/g53/util"
+)
+
+const (
+	EDNS_EDE = 15
+)
+
+// info codes of extended dns error, rfc8914
+type ExtendedErrorCode uint16
+
+const (
+	EDE_OTHER                        ExtendedErrorCode = 0
+	EDE_UNSUPPORTED_DNSKEY_ALGORITHM                   = 1
+	EDE_UNSUPPORTED_DS_DIGEST_TYPE                     = 2
+	EDE_STALE_ANSWER                                   = 3
+	EDE_FORGED_ANSWER                                  = 4
+	EDE_DNSSEC_INDETERMINATE                           = 5
+	EDE_DNSSEC_BOGUS                                   = 6
+	EDE_SIGNATURE_EXPIRED                              = 7
+	EDE_SIGNATURE_NOT_YET_VALID                        = 8
+	EDE_DNSKEY_MISSING                                 = 9
+	EDE_RRSIGS_MISSING                                 = 10
+	EDE_NO_ZONE_KEY_BIT_SET                            = 11
+	EDE_NSEC_MISSING                                   = 12
+	EDE_CACHED_ERROR                                   = 13
+	EDE_NOT_READY                                      = 14
+	EDE_BLOCKED                                        = 15
+	EDE_CENSORED                                       = 16
+	EDE_FILTERED                                       = 17
+	EDE_PROHIBITED                                     = 18
+	EDE_STALE_NXDOMAIN_ANSWER                          = 19
+	EDE_NOT_AUTHORITATIVE                              = 20
+	EDE_NOT_SUPPORTED                                  = 21
+	EDE_NO_REACHABLE_AUTHORITY                         = 22
+	EDE_NETWORK_ERROR                                  = 23
+	EDE_INVALID_DATA                                   = 24
+)
+
+var ExtendedErrorCodeStr = map[ExtendedErrorCode]string{
+	EDE_OTHER:                        "Other",
+	EDE_UNSUPPORTED_DNSKEY_ALGORITHM: "Unsupported DNSKEY Algorithm",
+	EDE_UNSUPPORTED_DS_DIGEST_TYPE:   "Unsupported DS Digest Type",
+	EDE_STALE_ANSWER:                 "Stale Answer",
+	EDE_FORGED_ANSWER:                "Forged Answer",
+	EDE_DNSSEC_INDETERMINATE:         "DNSSEC Indeterminate",
+	EDE_DNSSEC_BOGUS:                 "DNSSEC Bogus",
+	EDE_SIGNATURE_EXPIRED:            "Signature Expired",
+	EDE_SIGNATURE_NOT_YET_VALID:      "Signature Not Yet Valid",
+	EDE_DNSKEY_MISSING:               "DNSKEY Missing",
+	EDE_RRSIGS_MISSING:               "RRSIGs Missing",
+	EDE_NO_ZONE_KEY_BIT_SET:          "No Zone Key Bit Set",
+	EDE_NSEC_MISSING:                 "NSEC Missing",
+	EDE_CACHED_ERROR:                 "Cached Error",
+	EDE_NOT_READY:                    "Not Ready",
+	EDE_BLOCKED:                      "Blocked",
+	EDE_CENSORED:                     "Censored",
+	EDE_FILTERED:                     "Filtered",
+	EDE_PROHIBITED:                   "Prohibited",
+	EDE_STALE_NXDOMAIN_ANSWER:        "Stale NXDOMAIN Answer",
+	EDE_NOT_AUTHORITATIVE:            "Not Authoritative",
+	EDE_NOT_SUPPORTED:                "Not Supported",
+	EDE_NO_REACHABLE_AUTHORITY:       "No Reachable Authority",
+	EDE_NETWORK_ERROR:                "Network Error",
+	EDE_INVALID_DATA:                 "Invalid Data",
+}
+
+func (c ExtendedErrorCode) String() string {
+	if s, ok := ExtendedErrorCodeStr[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("EDE%d", uint16(c))
+}
+
+// ExtendedErrorOpt carries the info code and optional utf-8 text explaining
+// the rcode of response
+type ExtendedErrorOpt struct {
+	InfoCode  ExtendedErrorCode
+	ExtraText string
+}
+
+func (eo *ExtendedErrorOpt) Rend(render *MsgRender) {
+	render.WriteUint16(EDNS_EDE)
+	render.WriteUint16(uint16(2 + len(eo.ExtraText)))
+	render.WriteUint16(uint16(eo.InfoCode))
+	render.WriteData([]byte(eo.ExtraText))
+}
+
+func (eo *ExtendedErrorOpt) String() string {
+	if eo.ExtraText != "" {
+		return fmt.Sprintf("; EDE: %d (%s): %s\n", uint16(eo.InfoCode), eo.InfoCode.String(), eo.ExtraText)
+	}
+	return fmt.Sprintf("; EDE: %d (%s)\n", uint16(eo.InfoCode), eo.InfoCode.String())
+}
+
+// read from OPTION-LENGTH
+func extendedErrorOptFromWire(buffer *util.InputBuffer) (Option, error) {
+	l, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+
+	if l < 2 {
+		return nil, fmt.Errorf("invalid extended error option length %d", l)
+	}
+
+	code, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := buffer.ReadBytes(uint(l - 2))
+	if err != nil {
+		return nil, err
+	}
+	return &ExtendedErrorOpt{
+		InfoCode:  ExtendedErrorCode(code),
+		ExtraText: string(text),
+	}, nil
+}
+
+func (e *EDNS) ExtendedErrorOpt() *ExtendedErrorOpt {
+	for _, opt := range e.Options {
+		if eo, ok := opt.(*ExtendedErrorOpt); ok {
+			return eo
+		}
+	}
+	return nil
+}
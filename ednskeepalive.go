@@ -0,0 +1,80 @@
+package g53
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mistletoeChao/g53/util"
+)
+
+const (
+	EDNS_TCP_KEEPALIVE = 11
+)
+
+// KeepaliveOpt is edns-tcp-keepalive of rfc7828, client sends it without
+// timeout, server responds with the idle timeout in units of 100ms
+type KeepaliveOpt struct {
+	HasTimeout bool
+	Timeout    uint16
+}
+
+func NewKeepaliveOpt(timeout time.Duration) *KeepaliveOpt {
+	return &KeepaliveOpt{
+		HasTimeout: true,
+		Timeout:    uint16(timeout / (100 * time.Millisecond)),
+	}
+}
+
+func (ka *KeepaliveOpt) Rend(render *MsgRender) {
+	render.WriteUint16(EDNS_TCP_KEEPALIVE)
+	if ka.HasTimeout {
+		render.WriteUint16(2)
+		render.WriteUint16(ka.Timeout)
+	} else {
+		render.WriteUint16(0)
+	}
+}
+
+func (ka *KeepaliveOpt) String() string {
+	if ka.HasTimeout {
+		return fmt.Sprintf("; TCP-KEEPALIVE: %v\n", ka.Duration())
+	}
+	return "; TCP-KEEPALIVE\n"
+}
+
+func (ka *KeepaliveOpt) Duration() time.Duration {
+	return time.Duration(ka.Timeout) * 100 * time.Millisecond
+}
+
+// read from OPTION-LENGTH
+func keepaliveOptFromWire(buffer *util.InputBuffer) (Option, error) {
+	l, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+
+	switch l {
+	case 0:
+		return &KeepaliveOpt{}, nil
+	case 2:
+		timeout, err := buffer.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+		return &KeepaliveOpt{
+			HasTimeout: true,
+			Timeout:    timeout,
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid tcp keepalive option length %d", l)
+	}
+}
+
+func (e *EDNS) KeepaliveOpt() *KeepaliveOpt {
+	for _, opt := range e.Options {
+		if ka, ok := opt.(*KeepaliveOpt); ok {
+			return ka
+		}
+	}
+	return nil
+}
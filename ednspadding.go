@@ -0,0 +1,78 @@
+package g53
+
+import (
+	"fmt"
+
+	"github.com/mistletoeChao/g53/util"
+)
+
+const (
+	EDNS_PADDING = 12
+)
+
+// block sizes recommended by rfc8467
+const (
+	QUERY_PADDING_BLOCK    = 128
+	RESPONSE_PADDING_BLOCK = 468
+)
+
+type PaddingOpt struct {
+	Length uint16
+}
+
+func (po *PaddingOpt) Rend(render *MsgRender) {
+	render.WriteUint16(EDNS_PADDING)
+	render.WriteUint16(po.Length)
+	render.WriteData(make([]byte, po.Length))
+}
+
+func (po *PaddingOpt) String() string {
+	return fmt.Sprintf("; PADDING: %d bytes\n", po.Length)
+}
+
+// read from OPTION-LENGTH
+func paddingOptFromWire(buffer *util.InputBuffer) (Option, error) {
+	l, err := buffer.ReadUint16()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := buffer.ReadBytes(uint(l)); err != nil {
+		return nil, err
+	}
+	return &PaddingOpt{Length: l}, nil
+}
+
+func (e *EDNS) PaddingOpt() *PaddingOpt {
+	for _, opt := range e.Options {
+		if po, ok := opt.(*PaddingOpt); ok {
+			return po
+		}
+	}
+	return nil
+}
+
+// PadMessage adds padding option to make the wire size of m a multiple of
+// blockSize, message without edns isn't padded
+func PadMessage(m *Message, blockSize int) {
+	if m.Edns == nil || blockSize <= 0 {
+		return
+	}
+
+	options := make([]Option, 0, len(m.Edns.Options)+1)
+	for _, opt := range m.Edns.Options {
+		if _, ok := opt.(*PaddingOpt); ok == false {
+			options = append(options, opt)
+		}
+	}
+	m.Edns.Options = options
+
+	render := NewMsgRender()
+	m.Rend(render)
+	size := int(render.Len()) + 4
+	padding := 0
+	if size%blockSize != 0 {
+		padding = blockSize - size%blockSize
+	}
+	m.Edns.Options = append(m.Edns.Options, &PaddingOpt{Length: uint16(padding)})
+}
@@ -74,26 +74,6 @@ func subnetOptFromWire(buffer *util.InputBuffer) (Option, error) {
 	}
 }
 
-func subnetOptFromRdata(rdata Rdata) Option {
-	opt := rdata.(*OPT)
-	if len(opt.Data) == 0 {
-		return nil
-	}
-
-	buffer := util.NewInputBuffer(opt.Data)
-	code, _ := buffer.ReadUint16()
-	if code != EDNS_SUBNET {
-		return nil
-	}
-
-	option, err := subnetOptFromWire(buffer)
-	if err == nil {
-		return option
-	} else {
-		return nil
-	}
-}
-
 func (e *EDNS) AddSubnetV4(ip_ string) error {
 	if ip := net.ParseIP(ip_); ip != nil {
 		e.Options = []Option{
@@ -109,3 +89,66 @@ func (e *EDNS) AddSubnetV4(ip_ string) error {
 		return fmt.Errorf("invalid ip address:%s", ip_)
 	}
 }
+
+// NewSubnetOpt makes client subnet option of the first mask bits of ip, the
+// family is decided by ip
+func NewSubnetOpt(ip net.IP, mask uint8) *SubnetOpt {
+	if ip4 := ip.To4(); ip4 != nil {
+		if mask > 32 {
+			mask = 32
+		}
+		return &SubnetOpt{family: 1, mask: mask, ip: ip4.Mask(net.CIDRMask(int(mask), 32))}
+	}
+
+	if mask > 128 {
+		mask = 128
+	}
+	return &SubnetOpt{family: 2, mask: mask, ip: ip.To16().Mask(net.CIDRMask(int(mask), 128))}
+}
+
+func (subnet *SubnetOpt) Family() uint16 {
+	return subnet.family
+}
+
+func (subnet *SubnetOpt) IP() net.IP {
+	return subnet.ip
+}
+
+func (subnet *SubnetOpt) SourcePrefix() uint8 {
+	return subnet.mask
+}
+
+func (subnet *SubnetOpt) ScopePrefix() uint8 {
+	return subnet.scope
+}
+
+func (subnet *SubnetOpt) SetScopePrefix(scope uint8) {
+	subnet.scope = scope
+}
+
+// Network returns the client subnet of prefix bits, prefix is capped by the
+// address length
+func (subnet *SubnetOpt) Network(prefix uint8) *net.IPNet {
+	ip, bits := subnet.ip.To16(), 128
+	if subnet.family == 1 {
+		ip, bits = subnet.ip.To4(), 32
+	}
+	if ip == nil {
+		return nil
+	}
+
+	if int(prefix) > bits {
+		prefix = uint8(bits)
+	}
+	mask := net.CIDRMask(int(prefix), bits)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+func (e *EDNS) SubnetOpt() *SubnetOpt {
+	for _, opt := range e.Options {
+		if subnet, ok := opt.(*SubnetOpt); ok {
+			return subnet
+		}
+	}
+	return nil
+}
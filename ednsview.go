@@ -40,29 +40,22 @@ func viewOptFromWire(buffer *util.InputBuffer) (Option, error) {
 	}, nil
 }
 
-func viewOptFromRdata(rdata Rdata) Option {
-	opt := rdata.(*OPT)
-	if len(opt.Data) == 0 {
-		return nil
-	}
-
-	buffer := util.NewInputBuffer(opt.Data)
-	code, _ := buffer.ReadUint16()
-	if code != EDNS_VIEW {
-		return nil
-	}
-
-	option, err := viewOptFromWire(buffer)
-	if err == nil {
-		return option
-	} else {
-		return nil
-	}
-}
-
 func (e *EDNS) AddSubnetView(view string) error {
 	e.Options = append(e.Options, &ViewOpt{
 		view: view,
 	})
 	return nil
 }
+
+func (vo *ViewOpt) View() string {
+	return vo.view
+}
+
+func (e *EDNS) ViewOpt() *ViewOpt {
+	for _, opt := range e.Options {
+		if vo, ok := opt.(*ViewOpt); ok {
+			return vo
+		}
+	}
+	return nil
+}
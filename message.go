@@ -30,6 +30,8 @@ type Message struct {
 	Question *Question
 	Sections [SectionCount]Section
 	Edns     *EDNS
+	Tsig     *RRset
+	DSO      []DSOTLV
 }
 
 func MakeQuery(name *Name, typ RRType, msgSize int, dnssec bool) *Message {
@@ -59,6 +61,14 @@ func MessageFromWire(buffer *util.InputBuffer) (*Message, error) {
 		return nil, err
 	}
 
+	if h.Opcode == OP_DSO {
+		tlvs, err := dsoFromWire(h, buffer)
+		if err != nil {
+			return nil, err
+		}
+		return &Message{Header: h, DSO: tlvs}, nil
+	}
+
 	var q *Question
 	if h.QDCount == 1 {
 		q, err = QuestionFromWire(buffer)
@@ -93,42 +103,56 @@ func (m *Message) sectionFromWire(st SectionType, buffer *util.InputBuffer) erro
 		count = m.Header.ARCount
 	}
 
+	allowEmpty := m.Header.Opcode == OP_UPDATE && (st == PrerequisiteSection || st == UpdateSection)
 	var lastRrset *RRset
 	for i := uint16(0); i < count; i++ {
-		rrset, err := RRsetFromWire(buffer)
+		rrset, err := rrsetFromWire(buffer, allowEmpty)
 		if err != nil {
 			return err
 		}
 
+		//rfc8945 5.1, tsig must be the last rr and appear only once
+		if st == AdditionalSection && rrset.Type == RR_TSIG && i != count-1 {
+			return ErrTSIGNotLast
+		}
+
 		if lastRrset == nil {
 			lastRrset = rrset
 			continue
 		}
 
-		if lastRrset.IsSameRrset(rrset) {
+		if lastRrset.IsSameRrset(rrset) && lastRrset.Class == rrset.Class &&
+			len(lastRrset.Rdatas) > 0 && len(rrset.Rdatas) > 0 {
 			lastRrset.Rdatas = append(lastRrset.Rdatas, rrset.Rdatas[0])
 		} else {
-			if st == AdditionalSection && lastRrset.Type == RR_OPT {
-				m.Edns = EdnsFromRRset(lastRrset)
-			} else {
-				s = append(s, lastRrset)
-			}
+			s = m.addParsedRRset(st, s, lastRrset)
 			lastRrset = rrset
 		}
 	}
 
 	if lastRrset != nil {
-		if st == AdditionalSection && lastRrset.Type == RR_OPT {
-			m.Edns = EdnsFromRRset(lastRrset)
-		} else {
-			s = append(s, lastRrset)
-		}
+		s = m.addParsedRRset(st, s, lastRrset)
 	}
 
 	m.Sections[st] = s
 	return nil
 }
 
+// opt and tsig in additional section are kept out of the section
+func (m *Message) addParsedRRset(st SectionType, s Section, rrset *RRset) Section {
+	if st == AdditionalSection {
+		switch rrset.Type {
+		case RR_OPT:
+			m.Edns = EdnsFromRRset(rrset)
+			return s
+		case RR_TSIG:
+			m.Tsig = rrset
+			return s
+		}
+	}
+	return append(s, rrset)
+}
+
 func (m *Message) Rend(r *MsgRender) {
 	if m.Question == nil {
 		m.Header.QDCount = 0
@@ -142,9 +166,16 @@ func (m *Message) Rend(r *MsgRender) {
 	if m.Edns != nil {
 		m.Header.ARCount += 1
 	}
+	if m.Tsig != nil {
+		m.Header.ARCount += 1
+	}
 
 	m.Header.Rend(r)
 
+	for _, tlv := range m.DSO {
+		tlv.Rend(r)
+	}
+
 	if m.Question != nil {
 		m.Question.Rend(r)
 	}
@@ -156,6 +187,10 @@ func (m *Message) Rend(r *MsgRender) {
 	if m.Edns != nil {
 		m.Edns.Rend(r)
 	}
+
+	if m.Tsig != nil {
+		rendTSIG(m.Tsig, r)
+	}
 }
 
 func (s Section) Rend(r *MsgRender) {
@@ -186,6 +221,11 @@ func (m *Message) String() string {
 	buf.WriteString(m.Header.String())
 	buf.WriteString("\n")
 
+	if m.Header.Opcode == OP_DSO {
+		m.dsoString(&buf)
+		return buf.String()
+	}
+
 	if m.Edns != nil {
 		buf.WriteString(";; OPT PSEUDOSECTION:\n")
 		buf.WriteString(m.Edns.String())
@@ -211,6 +251,11 @@ func (m *Message) String() string {
 		buf.WriteString("\n;; ADDITIONAL SECTION:\n")
 		buf.WriteString(m.Sections[AdditionalSection].String())
 	}
+
+	if m.Tsig != nil {
+		buf.WriteString("\n;; TSIG PSEUDOSECTION:\n")
+		buf.WriteString(m.Tsig.String())
+	}
 	return buf.String()
 }
 
@@ -232,6 +277,8 @@ func (m *Message) Clear() {
 	for i := 0; i < SectionCount; i++ {
 		m.Sections[i] = nil
 	}
+	m.Tsig = nil
+	m.DSO = nil
 }
 
 func (m *Message) AddRRset(st SectionType, rrset *RRset) {
@@ -276,7 +323,7 @@ func (m *Message) HasRRsetWithNameType(st SectionType, n *Name, t RRType) bool {
 func (m *Message) MakeResponse() *Message {
 	h := &Header{
 		Id:      m.Header.Id,
-		Opcode:  OP_QUERY,
+		Opcode:  m.Header.Opcode,
 		QDCount: m.Header.QDCount,
 	}
 
@@ -298,6 +345,7 @@ func (m *Message) ClearSection(s SectionType) {
 		m.Header.NSCount = 0
 	case AdditionalSection:
 		m.Edns = nil
+		m.Tsig = nil
 		m.Header.ARCount = 0
 	default:
 		panic("question section couldn't be cleared")
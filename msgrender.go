@@ -133,7 +133,7 @@ func (r *MsgRender) Clear() {
 	r.truncated = false
 	r.caseSensitive = false
 	for i := uint(0); i < BUCKETS; i++ {
-		r.table[i] = r.table[i][0:RESERVED_ITEMS]
+		r.table[i] = r.table[i][0:0]
 	}
 }
 
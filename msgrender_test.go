@@ -1,7 +1,7 @@
 package g53
 
 import (
-	"g53/util"
+	"github.com/mistletoeChao/g53/util"
 	"testing"
 )
 
@@ -47,3 +47,17 @@ func TestWriteName(t *testing.T) {
 	render.WriteName(aExampleOrg, true)
 	WireMatch(t, raw, render.Data())
 }
+
+func TestClearForgetsOffsets(t *testing.T) {
+	render := NewMsgRender()
+	aExampleCom, _ := NewName("a.example.com", true)
+	render.WriteName(aExampleCom, true)
+
+	// after Clear, bytes that happen to equal a name written before the
+	// clear aren't a name anymore, so they must not be compressed against
+	raw, _ := util.HexStrToBytes("0161076578616d706c6503636f6d000161076578616d706c6503636f6d00")
+	render.Clear()
+	render.WriteData(raw[:15])
+	render.WriteName(aExampleCom, true)
+	WireMatch(t, raw, render.Data())
+}
@@ -0,0 +1,67 @@
+package g53
+
+import (
+	"errors"
+)
+
+// MakeNotify builds the notify sent by primary to secondaries, soa is put
+// into answer section as a hint of the new serial
+func MakeNotify(zone *Name, soa *RRset) *Message {
+	h := &Header{
+		Opcode: OP_NOTIFY,
+	}
+	h.SetFlag(FLAG_AA, true)
+
+	m := &Message{
+		Header: h,
+		Question: &Question{
+			Name:  zone,
+			Type:  RR_SOA,
+			Class: CLASS_IN,
+		},
+	}
+
+	if soa != nil {
+		m.AddRRset(AnswerSection, soa)
+	}
+	return m
+}
+
+// ValidateNotify checks the format of a notify request, FORMERR should be
+// responded if error is returned
+func ValidateNotify(m *Message) error {
+	if m.Header.Opcode != OP_NOTIFY {
+		return errors.New("opcode isn't notify")
+	}
+
+	if m.Header.GetFlag(FLAG_QR) {
+		return errors.New("notify request has qr set")
+	}
+
+	if m.Question == nil {
+		return errors.New("notify should have exactly one question")
+	}
+
+	if m.Question.Type != RR_SOA {
+		return errors.New("notify question type isn't soa")
+	}
+
+	for _, rrset := range m.Sections[AnswerSection] {
+		if rrset.Type == RR_SOA && rrset.Name.Equals(m.Question.Name) == false {
+			return errors.New("soa in notify doesn't belong to the zone")
+		}
+	}
+	return nil
+}
+
+// NotifySerial returns the serial hint carried in notify
+func NotifySerial(m *Message) (uint32, bool) {
+	for _, rrset := range m.Sections[AnswerSection] {
+		if rrset.Type == RR_SOA && len(rrset.Rdatas) > 0 {
+			if soa, ok := rrset.Rdatas[0].(*SOA); ok {
+				return soa.Serial, true
+			}
+		}
+	}
+	return 0, false
+}
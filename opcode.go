@@ -11,7 +11,7 @@ const (
 	OP_RESERVED3         = 3  ///< 3: Reserved for future use (RFC1035)
 	OP_NOTIFY            = 4  ///< 4: Notify (RFC1996)
 	OP_UPDATE            = 5  ///< 5: Dynamic update (RFC2136)
-	OP_RESERVED6         = 6  ///< 6: Reserved for future use (RFC1035)
+	OP_DSO               = 6  ///< 6: DNS Stateful Operations (RFC8490)
 	OP_RESERVED7         = 7  ///< 7: Reserved for future use (RFC1035)
 	OP_RESERVED8         = 8  ///< 8: Reserved for future use (RFC1035)
 	OP_RESERVED9         = 9  ///< 9: Reserved for future use (RFC1035)
@@ -23,6 +23,9 @@ const (
 	OP_RESERVED15        = 15 ///< 15: Reserved for future use (RFC1035)
 )
 
+// Deprecated: opcode 6 is assigned to DSO, use OP_DSO instead
+const OP_RESERVED6 = OP_DSO
+
 var OpcodeStr = map[Opcode]string{
 	OP_QUERY:      "QUERY",
 	OP_IQUERY:     "IQUERY",
@@ -30,7 +33,7 @@ var OpcodeStr = map[Opcode]string{
 	OP_RESERVED3:  "RESERVED3",
 	OP_NOTIFY:     "NOTIFY",
 	OP_UPDATE:     "UPDATE",
-	OP_RESERVED6:  "RESERVED6",
+	OP_DSO:        "DSO",
 	OP_RESERVED7:  "RESERVED7",
 	OP_RESERVED8:  "RESERVED8",
 	OP_RESERVED9:  "RESERVED9",
@@ -16,13 +16,16 @@ const (
 	R_NXRRSET          = 8  ///< 8: RRset should exist but not (RFC2136)
 	R_NOTAUTH          = 9  ///< 9: Server isn't authoritative (RFC2136)
 	R_NOTZONE          = 10 ///< 10: Name is not within the zone (RFC2136)
-	R_RESERVED11       = 11 ///< 11: Reserved for future use (RFC1035)
+	R_DSOTYPENI        = 11 ///< 11: DSO-TYPE not implemented (RFC8490)
 	R_RESERVED12       = 12 ///< 12: Reserved for future use (RFC1035)
 	R_RESERVED13       = 13 ///< 13: Reserved for future use (RFC1035)
 	R_RESERVED14       = 14 ///< 14: Reserved for future use (RFC1035)
 	R_RESERVED15       = 15 ///< 15: Reserved for future use (RFC1035)
 )
 
+// Deprecated: rcode 11 is assigned to DSOTYPENI, use R_DSOTYPENI instead
+const R_RESERVED11 = R_DSOTYPENI
+
 var RcodeStr = map[Rcode]string{
 	R_NOERROR:    "NOERROR",
 	R_FORMERR:    "FORMERR",
@@ -35,7 +38,7 @@ var RcodeStr = map[Rcode]string{
 	R_NXRRSET:    "NXRRSET",
 	R_NOTAUTH:    "NOTAUTH",
 	R_NOTZONE:    "NOTZONE",
-	R_RESERVED11: "RESERVED11",
+	R_DSOTYPENI:  "DSOTYPENI",
 	R_RESERVED12: "RESERVED12",
 	R_RESERVED13: "RESERVED13",
 	R_RESERVED14: "RESERVED14",
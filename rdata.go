@@ -60,6 +60,8 @@ func RdataFromWire(t RRType, buffer *util.InputBuffer) (Rdata, error) {
 		return TxtFromWire(buffer, rdlen)
 	case RR_SPF:
 		return SPFFromWire(buffer, rdlen)
+	case RR_TSIG:
+		return TSIGFromWire(buffer, rdlen)
 	default:
 		return nil, fmt.Errorf("unimplement type: %v", t)
 	}
@@ -95,6 +97,8 @@ func RdataFromStr(t RRType, s string) (Rdata, error) {
 		return TxtFromString(s)
 	case RR_SPF:
 		return SPFFromString(s)
+	case RR_TSIG:
+		return TSIGFromString(s)
 	default:
 		return nil, errors.New("unimplement type")
 	}
@@ -149,3 +149,10 @@ func SOAFromString(s string) (*SOA, error) {
 
 	return &SOA{mname, rname, uint32(serial), uint32(refresh), uint32(retry), uint32(expire), uint32(minimum)}, nil
 }
+
+// SerialLess compares soa serials by the serial number arithmetic of
+// rfc1982, s1 is less than s2 if s2 is in the half of the serial space
+// after s1
+func SerialLess(s1, s2 uint32) bool {
+	return s1 != s2 && int32(s1-s2) < 0
+}
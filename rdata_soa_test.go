@@ -1,7 +1,7 @@
 package g53
 
 import (
-	"g53/util"
+	"github.com/mistletoeChao/g53/util"
 	"testing"
 )
 
@@ -26,3 +26,11 @@ func TestSOAFromToWire(t *testing.T) {
 	soa.Rend(render)
 	WireMatch(t, render.Data(), soa_wire)
 }
+
+func TestSerialLess(t *testing.T) {
+	Assert(t, SerialLess(1, 2), "1 is less than 2")
+	Assert(t, SerialLess(2, 1) == false, "2 isn't less than 1")
+	Assert(t, SerialLess(1, 1) == false, "serial isn't less than itself")
+	Assert(t, SerialLess(0xffffffff, 0), "serial wraps around")
+	Assert(t, SerialLess(0, 0x80000001) == false, "serial more than half space ahead is less")
+}
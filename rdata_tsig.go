@@ -0,0 +1,176 @@
+package g53
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/mistletoeChao/g53/util"
+)
+
+type TSIG struct {
+	Algorithm  *Name
+	TimeSigned uint64 //48 bits
+	Fudge      uint16
+	MAC        []uint8
+	OriginalId uint16
+	Error      uint16
+	OtherData  []uint8
+}
+
+func (tsig *TSIG) Rend(r *MsgRender) {
+	rendField(RDF_C_NAME_UNCOMPRESS, tsig.Algorithm, r)
+	rendField(RDF_C_UINT16, uint16(tsig.TimeSigned>>32), r)
+	rendField(RDF_C_UINT32, uint32(tsig.TimeSigned), r)
+	rendField(RDF_C_UINT16, tsig.Fudge, r)
+	rendField(RDF_C_UINT16, uint16(len(tsig.MAC)), r)
+	rendField(RDF_C_BINARY, tsig.MAC, r)
+	rendField(RDF_C_UINT16, tsig.OriginalId, r)
+	rendField(RDF_C_UINT16, tsig.Error, r)
+	rendField(RDF_C_UINT16, uint16(len(tsig.OtherData)), r)
+	rendField(RDF_C_BINARY, tsig.OtherData, r)
+}
+
+func (tsig *TSIG) ToWire(buffer *util.OutputBuffer) {
+	fieldToWire(RDF_C_NAME_UNCOMPRESS, tsig.Algorithm, buffer)
+	fieldToWire(RDF_C_UINT16, uint16(tsig.TimeSigned>>32), buffer)
+	fieldToWire(RDF_C_UINT32, uint32(tsig.TimeSigned), buffer)
+	fieldToWire(RDF_C_UINT16, tsig.Fudge, buffer)
+	fieldToWire(RDF_C_UINT16, uint16(len(tsig.MAC)), buffer)
+	fieldToWire(RDF_C_BINARY, tsig.MAC, buffer)
+	fieldToWire(RDF_C_UINT16, tsig.OriginalId, buffer)
+	fieldToWire(RDF_C_UINT16, tsig.Error, buffer)
+	fieldToWire(RDF_C_UINT16, uint16(len(tsig.OtherData)), buffer)
+	fieldToWire(RDF_C_BINARY, tsig.OtherData, buffer)
+}
+
+func (tsig *TSIG) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(fieldToStr(RDF_D_NAME, tsig.Algorithm))
+	buf.WriteString(" ")
+	buf.WriteString(strconv.FormatUint(tsig.TimeSigned, 10))
+	buf.WriteString(" ")
+	buf.WriteString(fieldToStr(RDF_D_INT, tsig.Fudge))
+	buf.WriteString(" ")
+	buf.WriteString(fieldToStr(RDF_D_B64, tsig.MAC))
+	buf.WriteString(" ")
+	buf.WriteString(fieldToStr(RDF_D_INT, tsig.OriginalId))
+	buf.WriteString(" ")
+	buf.WriteString(fieldToStr(RDF_D_INT, tsig.Error))
+	buf.WriteString(" ")
+	buf.WriteString(fieldToStr(RDF_D_B64, tsig.OtherData))
+	return buf.String()
+}
+
+func readSizedBinary(buffer *util.InputBuffer, ll uint16) ([]uint8, uint16, error) {
+	l, ll, err := fieldFromWire(RDF_C_UINT16, buffer, ll)
+	if err != nil {
+		return nil, ll, err
+	}
+
+	size, _ := l.(uint16)
+	if size > ll {
+		return nil, ll, errors.New("binary field is longer than rdata")
+	}
+
+	d, err := buffer.ReadBytes(uint(size))
+	if err != nil {
+		return nil, ll, err
+	}
+	return d, ll - size, nil
+}
+
+func TSIGFromWire(buffer *util.InputBuffer, ll uint16) (*TSIG, error) {
+	n, ll, err := fieldFromWire(RDF_C_NAME_UNCOMPRESS, buffer, ll)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, _ := n.(*Name)
+
+	high, ll, err := fieldFromWire(RDF_C_UINT16, buffer, ll)
+	if err != nil {
+		return nil, err
+	}
+
+	low, ll, err := fieldFromWire(RDF_C_UINT32, buffer, ll)
+	if err != nil {
+		return nil, err
+	}
+	timeSigned := uint64(high.(uint16))<<32 | uint64(low.(uint32))
+
+	fudge, ll, err := fieldFromWire(RDF_C_UINT16, buffer, ll)
+	if err != nil {
+		return nil, err
+	}
+
+	mac, ll, err := readSizedBinary(buffer, ll)
+	if err != nil {
+		return nil, err
+	}
+
+	originalId, ll, err := fieldFromWire(RDF_C_UINT16, buffer, ll)
+	if err != nil {
+		return nil, err
+	}
+
+	tsigErr, ll, err := fieldFromWire(RDF_C_UINT16, buffer, ll)
+	if err != nil {
+		return nil, err
+	}
+
+	otherData, ll, err := readSizedBinary(buffer, ll)
+	if err != nil {
+		return nil, err
+	}
+
+	if ll != 0 {
+		return nil, errors.New("extra data in rdata part")
+	}
+
+	return &TSIG{algorithm, timeSigned, fudge.(uint16), mac, originalId.(uint16), tsigErr.(uint16), otherData}, nil
+}
+
+func TSIGFromString(s string) (*TSIG, error) {
+	fields := strings.Split(s, " ")
+	if len(fields) != 7 {
+		return nil, errors.New("short of fields for tsig")
+	}
+
+	algorithm, err := fieldFromStr(RDF_D_NAME, fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	timeSigned, err := strconv.ParseUint(fields[1], 10, 48)
+	if err != nil {
+		return nil, err
+	}
+
+	fudge, err := fieldFromStr(RDF_D_INT, fields[2])
+	if err != nil {
+		return nil, err
+	}
+
+	mac, err := fieldFromStr(RDF_D_B64, fields[3])
+	if err != nil {
+		return nil, err
+	}
+
+	originalId, err := fieldFromStr(RDF_D_INT, fields[4])
+	if err != nil {
+		return nil, err
+	}
+
+	tsigErr, err := fieldFromStr(RDF_D_INT, fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	otherData, err := fieldFromStr(RDF_D_B64, fields[6])
+	if err != nil {
+		return nil, err
+	}
+
+	return &TSIG{algorithm.(*Name), timeSigned, uint16(fudge.(int)), mac.([]uint8), uint16(originalId.(int)), uint16(tsigErr.(int)), otherData.([]uint8)}, nil
+}
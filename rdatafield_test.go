@@ -1,7 +1,7 @@
 package g53
 
 import (
-	"g53/util"
+	"github.com/mistletoeChao/g53/util"
 	"testing"
 )
 
@@ -0,0 +1,400 @@
+package resolver
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+	"github.com/mistletoeChao/g53/server"
+)
+
+const (
+	DefaultMaxFails       = 3
+	DefaultProbeInterval  = 10 * time.Second
+	DefaultRaceTimeout    = 300 * time.Millisecond
+	DefaultSubnetV4Prefix = 24
+	DefaultSubnetV6Prefix = 56
+)
+
+var ErrNoUpstream = errors.New("no upstream to forward query")
+
+type SelectPolicy uint8
+
+const (
+	SELECT_FASTEST     SelectPolicy = 0
+	SELECT_ROUND_ROBIN              = 1
+	SELECT_RANDOM                   = 2
+)
+
+var SelectPolicyStr = map[SelectPolicy]string{
+	SELECT_FASTEST:     "fastest",
+	SELECT_ROUND_ROBIN: "round-robin",
+	SELECT_RANDOM:      "random",
+}
+
+func (p SelectPolicy) String() string {
+	return SelectPolicyStr[p]
+}
+
+// what to do with the client subnet option of query before forwarding
+type SubnetAction uint8
+
+const (
+	SUBNET_KEEP    SubnetAction = 0
+	SUBNET_STRIP                = 1
+	SUBNET_REWRITE              = 2
+)
+
+var SubnetActionStr = map[SubnetAction]string{
+	SUBNET_KEEP:    "keep",
+	SUBNET_STRIP:   "strip",
+	SUBNET_REWRITE: "rewrite",
+}
+
+func (a SubnetAction) String() string {
+	return SubnetActionStr[a]
+}
+
+type upstream struct {
+	addr      string
+	srtt      time.Duration
+	fails     int
+	down      bool
+	nextProbe time.Time
+}
+
+// Forwarder is a handler which forwards queries to upstreams. Upstreams are
+// ordered by Policy, the fastest one is the one with the smallest smoothed
+// rtt. Upstream is marked down after MaxFails consecutive failures and isn't
+// used until a probe query, which is sent every ProbeInterval, succeeds. If
+// the chosen upstream doesn't answer in RaceTimeout or fails, the query is
+// sent to the next one as well and the first response wins.
+//
+// With SUBNET_STRIP, client subnet option is removed from queries, and with
+// SUBNET_REWRITE it's replaced by the source address of client truncated to
+// SubnetV4Prefix or SubnetV6Prefix, in both cases the subnet option of client
+// is echoed back with the scope of upstream answer. Since the rewritten
+// answer is for the source address instead of the subnet client claims, its
+// scope is narrowed to the claimed subnet so that the answer isn't shared
+// with other subnets in the upstream scope
+type Forwarder struct {
+	Exchanger      Exchanger
+	Policy         SelectPolicy
+	MaxFails       int
+	ProbeInterval  time.Duration
+	RaceTimeout    time.Duration
+	SubnetAction   SubnetAction
+	SubnetV4Prefix uint8
+	SubnetV6Prefix uint8
+
+	lock      sync.Mutex
+	upstreams []*upstream
+	next      int
+	probes    sync.WaitGroup
+	now       func() time.Time
+}
+
+// NewForwarder forwards queries to servers, port 53 is used for server
+// without port
+func NewForwarder(servers []string) *Forwarder {
+	f := &Forwarder{
+		Exchanger: &client.Client{
+			Timeout: DefaultQueryTimeout,
+		},
+		MaxFails:       DefaultMaxFails,
+		ProbeInterval:  DefaultProbeInterval,
+		RaceTimeout:    DefaultRaceTimeout,
+		SubnetV4Prefix: DefaultSubnetV4Prefix,
+		SubnetV6Prefix: DefaultSubnetV6Prefix,
+		now:            time.Now,
+	}
+	for _, s := range servers {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, DefaultPort)
+		}
+		f.upstreams = append(f.upstreams, &upstream{addr: s})
+	}
+	return f
+}
+
+func (f *Forwarder) maxFails() int {
+	if f.MaxFails <= 0 {
+		return DefaultMaxFails
+	}
+	return f.MaxFails
+}
+
+func (f *Forwarder) probeInterval() time.Duration {
+	if f.ProbeInterval <= 0 {
+		return DefaultProbeInterval
+	}
+	return f.ProbeInterval
+}
+
+func (f *Forwarder) raceTimeout() time.Duration {
+	if f.RaceTimeout <= 0 {
+		return DefaultRaceTimeout
+	}
+	return f.RaceTimeout
+}
+
+// IsDown returns whether server has been marked down
+func (f *Forwarder) IsDown(server string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, u := range f.upstreams {
+		if u.addr == server {
+			return u.down
+		}
+	}
+	return false
+}
+
+// SRTT returns the smoothed rtt of server, 0 if it hasn't answered
+func (f *Forwarder) SRTT(server string) time.Duration {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, u := range f.upstreams {
+		if u.addr == server {
+			return u.srtt
+		}
+	}
+	return 0
+}
+
+// selectUpstreams orders the upstreams which are up by policy, all of them
+// are used if none is up. Probe is sent to the down ones whose probe time
+// has come
+func (f *Forwarder) selectUpstreams() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	now := f.now()
+	var up []*upstream
+	for _, u := range f.upstreams {
+		if u.down == false {
+			up = append(up, u)
+		} else if now.Before(u.nextProbe) == false {
+			u.nextProbe = now.Add(f.probeInterval())
+			f.probes.Add(1)
+			go f.probe(u.addr)
+		}
+	}
+	if len(up) == 0 {
+		up = append(up, f.upstreams...)
+	}
+
+	switch f.Policy {
+	case SELECT_ROUND_ROBIN:
+		start := f.next % len(up)
+		f.next += 1
+		up = append(up[start:], up[:start]...)
+	case SELECT_RANDOM:
+		rand.Shuffle(len(up), func(i, j int) {
+			up[i], up[j] = up[j], up[i]
+		})
+	default:
+		sort.SliceStable(up, func(i, j int) bool {
+			return up[i].srtt < up[j].srtt
+		})
+	}
+
+	servers := make([]string, 0, len(up))
+	for _, u := range up {
+		servers = append(servers, u.addr)
+	}
+	return servers
+}
+
+// srtt is updated with weight 0.3 of the new rtt
+func (f *Forwarder) succeed(server string, rtt time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, u := range f.upstreams {
+		if u.addr == server {
+			if u.srtt == 0 {
+				u.srtt = rtt
+			} else {
+				u.srtt = (u.srtt*7 + rtt*3) / 10
+			}
+			u.fails = 0
+			u.down = false
+		}
+	}
+}
+
+func (f *Forwarder) fail(server string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, u := range f.upstreams {
+		if u.addr == server && u.down == false {
+			u.fails += 1
+			if u.fails >= f.maxFails() {
+				u.down = true
+				u.nextProbe = f.now().Add(f.probeInterval())
+			}
+		}
+	}
+}
+
+// probe asks for root ns, any response means the server is up again
+func (f *Forwarder) probe(server string) {
+	defer f.probes.Done()
+	query := g53.MakeQuery(g53.Root, g53.RR_NS, EdnsUDPSize, false)
+	if _, rtt, err := f.Exchanger.ExchangeWith(query, server); err == nil {
+		f.succeed(server, rtt)
+	}
+}
+
+type forwardResult struct {
+	response *g53.Message
+	err      error
+}
+
+// Forward sends query to the selected upstreams until one of them answers,
+// the next upstream is tried when the previous one fails or doesn't answer
+// in RaceTimeout, query isn't modified
+func (f *Forwarder) Forward(query *g53.Message) (*g53.Message, error) {
+	servers := f.selectUpstreams()
+	if len(servers) == 0 {
+		return nil, ErrNoUpstream
+	}
+
+	results := make(chan forwardResult, len(servers))
+	exchange := func(addr string) {
+		//the id of query is changed by exchanger, so each exchange has a copy
+		response, rtt, err := f.Exchanger.ExchangeWith(server.CopyQuery(query), addr)
+		if err == nil {
+			f.succeed(addr, rtt)
+		} else {
+			f.fail(addr)
+		}
+		results <- forwardResult{response, err}
+	}
+
+	go exchange(servers[0])
+	sent, pending := 1, 1
+	var lastErr error
+	for pending > 0 {
+		timer := time.NewTimer(f.raceTimeout())
+		select {
+		case result := <-results:
+			timer.Stop()
+			pending -= 1
+			if result.err == nil {
+				return result.response, nil
+			}
+			lastErr = result.err
+		case <-timer.C:
+		}
+
+		if sent < len(servers) {
+			go exchange(servers[sent])
+			sent += 1
+			pending += 1
+		}
+	}
+	return nil, lastErr
+}
+
+// ServeDNS forwards the query of client, response id is restored, SERVFAIL
+// is returned if no upstream answers
+func (f *Forwarder) ServeDNS(w server.ResponseWriter, req *g53.Message) {
+	if req.Header.Opcode != g53.OP_QUERY || req.Question == nil {
+		resp := req.MakeResponse()
+		resp.Header.Rcode = g53.R_NOTIMP
+		if req.Question == nil {
+			resp.Header.Rcode = g53.R_FORMERR
+		}
+		w.Write(resp)
+		return
+	}
+
+	query := server.CopyQuery(req)
+	query.Tsig = nil
+	f.rewriteSubnet(w, query)
+	resp, err := f.Forward(query)
+	if err != nil {
+		resp = req.MakeResponse()
+		resp.Header.SetFlag(g53.FLAG_RA, true)
+		resp.Header.Rcode = g53.R_SERVFAIL
+		if req.Edns != nil {
+			resp.Edns = &g53.EDNS{UdpSize: EdnsUDPSize}
+		}
+		w.Write(resp)
+		return
+	}
+
+	resp.Header.Id = req.Header.Id
+	if f.SubnetAction != SUBNET_KEEP && resp.Edns != nil {
+		resp.Edns.Options = echoSubnet(req, resp.Edns, f.SubnetAction == SUBNET_REWRITE)
+	}
+	w.Write(resp)
+}
+
+// echoSubnet returns the options of response with the client subnet of req
+// which carries the scope of upstream answer, so the caller like cache still
+// knows which clients the answer is valid for. Subnet option is removed if
+// req has none. The scoped answer to a rewritten subnet is only valid for
+// the subnet of req itself
+func echoSubnet(req *g53.Message, resp *g53.EDNS, rewritten bool) []g53.Option {
+	var scope uint8
+	if subnet := resp.SubnetOpt(); subnet != nil {
+		scope = subnet.ScopePrefix()
+	}
+
+	options := withoutSubnet(resp.Options)
+	if req.Edns != nil {
+		if subnet := req.Edns.SubnetOpt(); subnet != nil {
+			if rewritten && scope > 0 {
+				scope = subnet.SourcePrefix()
+			}
+			echo := g53.NewSubnetOpt(subnet.IP(), subnet.SourcePrefix())
+			echo.SetScopePrefix(scope)
+			options = append(options, echo)
+		}
+	}
+	return options
+}
+
+func (f *Forwarder) rewriteSubnet(w server.ResponseWriter, query *g53.Message) {
+	if f.SubnetAction == SUBNET_KEEP || query.Edns == nil {
+		return
+	}
+
+	query.Edns.Options = withoutSubnet(query.Edns.Options)
+	if f.SubnetAction != SUBNET_REWRITE {
+		return
+	}
+
+	var ip net.IP
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	}
+	if ip == nil {
+		return
+	}
+
+	prefix := f.SubnetV6Prefix
+	if ip.To4() != nil {
+		prefix = f.SubnetV4Prefix
+	}
+	query.Edns.Options = append(query.Edns.Options, g53.NewSubnetOpt(ip, prefix))
+}
+
+func withoutSubnet(options []g53.Option) []g53.Option {
+	var kept []g53.Option
+	for _, opt := range options {
+		if _, ok := opt.(*g53.SubnetOpt); ok == false {
+			kept = append(kept, opt)
+		}
+	}
+	return kept
+}
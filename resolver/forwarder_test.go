@@ -0,0 +1,275 @@
+package resolver
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/cache"
+)
+
+// fakeUpstreams answers queries to each server with rtt, servers in down
+// fail and the ones in slow answer after delay
+type fakeUpstreams struct {
+	lock    sync.Mutex
+	rtts    map[string]time.Duration
+	down    map[string]bool
+	slow    map[string]time.Duration
+	queries map[string][]*g53.Message
+}
+
+func newFakeUpstreams() *fakeUpstreams {
+	return &fakeUpstreams{
+		rtts:    make(map[string]time.Duration),
+		down:    make(map[string]bool),
+		slow:    make(map[string]time.Duration),
+		queries: make(map[string][]*g53.Message),
+	}
+}
+
+func (u *fakeUpstreams) ExchangeWith(query *g53.Message, server string) (*g53.Message, time.Duration, error) {
+	u.lock.Lock()
+	u.queries[server] = append(u.queries[server], query)
+	rtt, down, delay := u.rtts[server], u.down[server], u.slow[server]
+	u.lock.Unlock()
+
+	time.Sleep(delay)
+	if down {
+		return nil, 0, errors.New("query timeout")
+	}
+	resp := query.MakeResponse()
+	resp.AddRRset(g53.AnswerSection, g53.BuildRRset(query.Question.Name.String(false), g53.RR_TXT, 300, server))
+	if query.Edns != nil {
+		resp.Edns = &g53.EDNS{UdpSize: query.Edns.UdpSize}
+		if subnet := query.Edns.SubnetOpt(); subnet != nil {
+			echo := g53.NewSubnetOpt(subnet.IP(), subnet.SourcePrefix())
+			echo.SetScopePrefix(16)
+			resp.Edns.Options = append(resp.Edns.Options, echo)
+		}
+	}
+	return resp, rtt, nil
+}
+
+func (u *fakeUpstreams) set(server string, rtt time.Duration, down bool) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.rtts[server] = rtt
+	u.down[server] = down
+}
+
+func (u *fakeUpstreams) count(server string) int {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return len(u.queries[server])
+}
+
+func (u *fakeUpstreams) reset() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.queries = make(map[string][]*g53.Message)
+}
+
+func forward(t *testing.T, f *Forwarder) string {
+	name, _ := g53.NameFromString("www.example.com.")
+	resp, err := f.Forward(g53.MakeQuery(name, g53.RR_TXT, 4096, false))
+	g53.Assert(t, err == nil, "forward failed:%v", err)
+	return resp.Sections[g53.AnswerSection][0].Rdatas[0].String()
+}
+
+func newTestForwarder(upstreams *fakeUpstreams, servers ...string) *Forwarder {
+	f := NewForwarder(servers)
+	f.Exchanger = upstreams
+	return f
+}
+
+func TestForwarderFastest(t *testing.T) {
+	upstreams := newFakeUpstreams()
+	upstreams.set("10.0.0.1:53", 50*time.Millisecond, false)
+	upstreams.set("10.0.0.2:53", 10*time.Millisecond, false)
+	f := newTestForwarder(upstreams, "10.0.0.1", "10.0.0.2:53")
+
+	//upstream without rtt is tried first
+	g53.Equal(t, forward(t, f), "\"10.0.0.1:53\"")
+	g53.Equal(t, forward(t, f), "\"10.0.0.2:53\"")
+	for i := 0; i < 5; i++ {
+		g53.Equal(t, forward(t, f), "\"10.0.0.2:53\"")
+	}
+	g53.Equal(t, f.SRTT("10.0.0.1:53"), 50*time.Millisecond)
+	g53.Equal(t, f.SRTT("10.0.0.2:53"), 10*time.Millisecond)
+
+	//srtt is smoothed
+	upstreams.set("10.0.0.2:53", 110*time.Millisecond, false)
+	forward(t, f)
+	g53.Equal(t, f.SRTT("10.0.0.2:53"), 40*time.Millisecond)
+	g53.Equal(t, forward(t, f), "\"10.0.0.2:53\"")
+	g53.Equal(t, forward(t, f), "\"10.0.0.1:53\"")
+}
+
+func TestForwarderPolicy(t *testing.T) {
+	upstreams := newFakeUpstreams()
+	servers := []string{"10.0.0.1:53", "10.0.0.2:53", "10.0.0.3:53"}
+	f := newTestForwarder(upstreams, servers...)
+	f.Policy = SELECT_ROUND_ROBIN
+	for i := 0; i < 6; i++ {
+		g53.Equal(t, forward(t, f), "\""+servers[i%3]+"\"")
+	}
+
+	upstreams.reset()
+	f.Policy = SELECT_RANDOM
+	for i := 0; i < 60; i++ {
+		forward(t, f)
+	}
+	for _, s := range servers {
+		g53.Assert(t, upstreams.count(s) > 0, "random policy should use all the servers")
+	}
+}
+
+func TestForwarderHealth(t *testing.T) {
+	upstreams := newFakeUpstreams()
+	upstreams.set("10.0.0.1:53", 0, true)
+	upstreams.set("10.0.0.2:53", 20*time.Millisecond, false)
+	clock := time.Unix(1600000000, 0)
+	f := newTestForwarder(upstreams, "10.0.0.1:53", "10.0.0.2:53")
+	f.now = func() time.Time { return clock }
+	f.Policy = SELECT_ROUND_ROBIN
+	f.MaxFails = 2
+	f.ProbeInterval = time.Minute
+
+	//query fails over to the next upstream
+	for i := 0; i < 4; i++ {
+		g53.Equal(t, forward(t, f), "\"10.0.0.2:53\"")
+	}
+	g53.Equal(t, upstreams.count("10.0.0.1:53"), 2)
+	g53.Assert(t, f.IsDown("10.0.0.1:53"), "server should be down after max fails")
+
+	upstreams.set("10.0.0.1:53", 20*time.Millisecond, false)
+	forward(t, f)
+	f.probes.Wait()
+	g53.Equal(t, upstreams.count("10.0.0.1:53"), 2)
+
+	//probe brings server back
+	clock = clock.Add(time.Minute)
+	forward(t, f)
+	f.probes.Wait()
+	g53.Equal(t, upstreams.count("10.0.0.1:53"), 3)
+	g53.Assert(t, f.IsDown("10.0.0.1:53") == false, "server should be up after probe")
+	upstreams.reset()
+	forward(t, f)
+	forward(t, f)
+	g53.Equal(t, upstreams.count("10.0.0.1:53"), 1)
+
+	//all the servers are used if all are down
+	upstreams.set("10.0.0.1:53", 0, true)
+	upstreams.set("10.0.0.2:53", 0, true)
+	name, _ := g53.NameFromString("www.example.com.")
+	for i := 0; i < 4; i++ {
+		f.Forward(g53.MakeQuery(name, g53.RR_A, 4096, false))
+	}
+	upstreams.reset()
+	upstreams.set("10.0.0.2:53", 0, false)
+	g53.Equal(t, forward(t, f), "\"10.0.0.2:53\"")
+}
+
+func TestForwarderRace(t *testing.T) {
+	upstreams := newFakeUpstreams()
+	upstreams.set("10.0.0.1:53", 0, false)
+	upstreams.set("10.0.0.2:53", 5*time.Millisecond, false)
+	upstreams.slow["10.0.0.1:53"] = time.Second
+	f := newTestForwarder(upstreams, "10.0.0.1:53", "10.0.0.2:53")
+	f.RaceTimeout = 20 * time.Millisecond
+
+	start := time.Now()
+	g53.Equal(t, forward(t, f), "\"10.0.0.2:53\"")
+	g53.Assert(t, time.Since(start) < time.Second, "second upstream should be raced")
+}
+
+type addrWriter struct {
+	recordWriter
+	addr net.Addr
+}
+
+func (w *addrWriter) RemoteAddr() net.Addr { return w.addr }
+
+func TestForwarderServeDNS(t *testing.T) {
+	upstreams := newFakeUpstreams()
+	f := newTestForwarder(upstreams, "10.0.0.1:53")
+	name, _ := g53.NameFromString("www.example.com.")
+	w := &addrWriter{addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.100"), Port: 5353}}
+
+	sentSubnet := func() *g53.SubnetOpt {
+		upstreams.lock.Lock()
+		defer upstreams.lock.Unlock()
+		queries := upstreams.queries["10.0.0.1:53"]
+		return queries[len(queries)-1].Edns.SubnetOpt()
+	}
+
+	req := g53.MakeQuery(name, g53.RR_TXT, 4096, false)
+	req.Header.Id = 1234
+	req.Edns.AddSubnetV4("1.2.3.4")
+	f.ServeDNS(w, req)
+	g53.Equal(t, w.resp.Header.Id, uint16(1234))
+	g53.Equal(t, sentSubnet().IP().String(), "1.2.3.4")
+	g53.Equal(t, len(req.Edns.Options), 1)
+
+	f.SubnetAction = SUBNET_STRIP
+	f.ServeDNS(w, req)
+	g53.Assert(t, sentSubnet() == nil, "subnet should be stripped")
+	g53.Equal(t, len(req.Edns.Options), 1)
+	//answer for no subnet is valid for all clients
+	echo := w.resp.Edns.SubnetOpt()
+	g53.Equal(t, echo.IP().String(), "1.2.3.4")
+	g53.Equal(t, echo.ScopePrefix(), uint8(0))
+
+	f.SubnetAction = SUBNET_REWRITE
+	f.ServeDNS(w, req)
+	g53.Equal(t, sentSubnet().IP().String(), "192.168.1.0")
+	g53.Equal(t, sentSubnet().SourcePrefix(), uint8(24))
+	//client gets its own subnet, the answer is only valid for it
+	echo = w.resp.Edns.SubnetOpt()
+	g53.Equal(t, echo.IP().String(), "1.2.3.4")
+	g53.Equal(t, echo.SourcePrefix(), uint8(32))
+	g53.Equal(t, echo.ScopePrefix(), uint8(32))
+
+	noSubnet := g53.MakeQuery(name, g53.RR_TXT, 4096, false)
+	f.ServeDNS(w, noSubnet)
+	g53.Equal(t, sentSubnet().IP().String(), "192.168.1.0")
+	g53.Assert(t, w.resp.Edns.SubnetOpt() == nil, "subnet shouldn't be sent to client without it")
+
+	upstreams.set("10.0.0.1:53", 0, true)
+	f.ServeDNS(w, req)
+	g53.Equal(t, w.resp.Header.Rcode, g53.Rcode(g53.R_SERVFAIL))
+}
+
+func TestForwarderSubnetScope(t *testing.T) {
+	upstreams := newFakeUpstreams()
+	f := newTestForwarder(upstreams, "10.0.0.1:53")
+	f.SubnetAction = SUBNET_REWRITE
+	h := cache.NewHandler(cache.NewRRsetCache(100), f)
+	name, _ := g53.NameFromString("www.example.com.")
+	w := &addrWriter{addr: &net.UDPAddr{IP: net.ParseIP("192.168.1.100"), Port: 5353}}
+
+	serve := func(subnet string) {
+		req := g53.MakeQuery(name, g53.RR_TXT, 4096, false)
+		req.Edns.AddSubnetV4(subnet)
+		h.ServeDNS(w, req)
+	}
+
+	//answer to the rewritten subnet is only used for the claimed subnet,
+	//not the others in the upstream scope
+	serve("1.2.3.4")
+	serve("1.2.3.4")
+	g53.Equal(t, upstreams.count("10.0.0.1:53"), 1)
+	serve("1.2.200.1")
+	g53.Equal(t, upstreams.count("10.0.0.1:53"), 2)
+	serve("5.6.7.8")
+	g53.Equal(t, upstreams.count("10.0.0.1:53"), 3)
+
+	//answer to the subnet of client is shared in the upstream scope
+	f.SubnetAction = SUBNET_KEEP
+	serve("10.1.1.1")
+	serve("10.1.200.1")
+	g53.Equal(t, upstreams.count("10.0.0.1:53"), 4)
+}
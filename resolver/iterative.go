@@ -0,0 +1,452 @@
+package resolver
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+	"github.com/mistletoeChao/g53/server"
+)
+
+const (
+	DefaultMaxQueries   = 100
+	DefaultMaxDepth     = 7
+	DefaultMaxRedirects = 8
+	DefaultMaxReferrals = 32
+	DefaultQueryTimeout = 2 * time.Second
+	DefaultPort         = "53"
+)
+
+var (
+	ErrLoop        = errors.New("resolution loop is detected")
+	ErrTooMuchWork = errors.New("resolution exceeds the work limit")
+	ErrNoAnswer    = errors.New("no name server answers")
+)
+
+// Exchanger sends query to one server, client.Client is used by default
+type Exchanger interface {
+	ExchangeWith(query *g53.Message, server string) (*g53.Message, time.Duration, error)
+}
+
+// Resolver resolves names iteratively from the root hints. The work of one
+// resolution is bounded by the number of queries sent, the depth of nested
+// resolutions for the addresses of name servers without glue, the number of
+// cname and dname followed and the number of referrals. Queries are
+// minimised as rfc9156 unless QNameMinimisation is off
+type Resolver struct {
+	RootHints         []*NameServer
+	Exchanger         Exchanger
+	Port              string
+	MaxQueries        int
+	MaxDepth          int
+	MaxRedirects      int
+	MaxReferrals      int
+	QNameMinimisation QNameMinMode
+	MaxMinimiseCount  int
+	MinimiseOneLabel  int
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{
+		RootHints: DefaultRootHints(),
+		Exchanger: &client.Client{
+			Timeout: DefaultQueryTimeout,
+		},
+		Port:         DefaultPort,
+		MaxQueries:   DefaultMaxQueries,
+		MaxDepth:     DefaultMaxDepth,
+		MaxRedirects: DefaultMaxRedirects,
+		MaxReferrals: DefaultMaxReferrals,
+
+		QNameMinimisation: QNAME_MIN_RELAXED,
+		MaxMinimiseCount:  DefaultMaxMinimiseCount,
+		MinimiseOneLabel:  DefaultMinimiseOneLabel,
+	}
+}
+
+// Resolve returns the response with RA set, answer section has the cname
+// and dname chain followed by the rrset of the final name, authority
+// section has the soa of negative response
+func (r *Resolver) Resolve(name *g53.Name, typ g53.RRType) (*g53.Message, error) {
+	res := &resolution{
+		r:          r,
+		inProgress: make(map[string]bool),
+	}
+	result, err := res.resolve(name, typ, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	query := g53.MakeQuery(name, typ, 0, false)
+	query.Edns = nil
+	resp := query.MakeResponse()
+	resp.Header.SetFlag(g53.FLAG_RA, true)
+	resp.Header.Rcode = result.rcode
+	resp.Sections[g53.AnswerSection] = result.answer
+	resp.Sections[g53.AuthSection] = result.authority
+	return resp, nil
+}
+
+// ServeDNS makes resolver a handler of server, SERVFAIL is returned if the
+// resolution fails
+func (r *Resolver) ServeDNS(w server.ResponseWriter, req *g53.Message) {
+	resp := req.MakeResponse()
+	resp.Header.SetFlag(g53.FLAG_RA, true)
+	if req.Edns != nil {
+		resp.Edns = &g53.EDNS{UdpSize: EdnsUDPSize}
+	}
+
+	switch {
+	case req.Header.Opcode != g53.OP_QUERY:
+		resp.Header.Rcode = g53.R_NOTIMP
+	case req.Question == nil:
+		resp.Header.Rcode = g53.R_FORMERR
+	default:
+		answer, err := r.Resolve(req.Question.Name, req.Question.Type)
+		if err != nil {
+			resp.Header.Rcode = g53.R_SERVFAIL
+		} else {
+			resp.Header.Rcode = answer.Header.Rcode
+			resp.Sections = answer.Sections
+		}
+	}
+	w.Write(resp)
+}
+
+func (r *Resolver) rootHints() []*NameServer {
+	if len(r.RootHints) == 0 {
+		return DefaultRootHints()
+	}
+	return r.RootHints
+}
+
+func (r *Resolver) port() string {
+	if r.Port == "" {
+		return DefaultPort
+	}
+	return r.Port
+}
+
+func limit(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// result of resolving one name and type
+type result struct {
+	rcode     g53.Rcode
+	answer    []*g53.RRset
+	authority []*g53.RRset
+}
+
+// resolution keeps the work done for one query, and the name and type
+// being resolved to detect loops of nested resolutions
+type resolution struct {
+	r          *Resolver
+	queries    int
+	inProgress map[string]bool
+}
+
+func nameKey(name *g53.Name) string {
+	return strings.ToLower(name.String(false))
+}
+
+func isSubdomain(name, zone *g53.Name) bool {
+	relation := name.Compare(zone, false).Relation
+	return relation == g53.SUBDOMAIN || relation == g53.EQUAL
+}
+
+func isStrictSubdomain(name, zone *g53.Name) bool {
+	return name.Compare(zone, false).Relation == g53.SUBDOMAIN
+}
+
+func findRRset(section g53.Section, name *g53.Name, typ g53.RRType) *g53.RRset {
+	for _, rrset := range section {
+		if rrset.Type == typ && rrset.Name.Equals(name) {
+			return rrset
+		}
+	}
+	return nil
+}
+
+func (res *resolution) resolve(name *g53.Name, typ g53.RRType, depth int) (*result, error) {
+	if depth > limit(res.r.MaxDepth, DefaultMaxDepth) {
+		return nil, ErrTooMuchWork
+	}
+
+	key := nameKey(name) + "/" + typ.String()
+	if res.inProgress[key] {
+		return nil, ErrLoop
+	}
+	res.inProgress[key] = true
+	defer delete(res.inProgress, key)
+
+	result := &result{rcode: g53.R_NOERROR}
+	visited := map[string]bool{nameKey(name): true}
+	redirects := 0
+	qname := name
+	for {
+		resp, zone, err := res.iterate(qname, typ, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		//the chain in one response is followed as far as possible within
+		//the zone the server is queried for, the final name is queried
+		//again from root if its data isn't in the response or is out of
+		//the zone, since server isn't authoritative for it
+		answer := resp.Sections[g53.AnswerSection]
+		redirected := false
+		for isSubdomain(qname, zone) {
+			if rrset := findRRset(answer, qname, typ); rrset != nil {
+				result.answer = append(result.answer, rrset)
+				return result, nil
+			}
+
+			next, chain := followAlias(answer, zone, qname, typ)
+			if next == nil {
+				break
+			}
+
+			if redirects >= limit(res.r.MaxRedirects, DefaultMaxRedirects) {
+				return nil, ErrTooMuchWork
+			}
+			redirects += 1
+			if visited[nameKey(next)] {
+				return nil, ErrLoop
+			}
+			visited[nameKey(next)] = true
+			result.answer = append(result.answer, chain...)
+			qname = next
+			redirected = true
+		}
+
+		if redirected == false {
+			result.rcode = resp.Header.Rcode
+			for _, rrset := range resp.Sections[g53.AuthSection] {
+				if rrset.Type == g53.RR_SOA {
+					result.authority = append(result.authority, rrset)
+				}
+			}
+			return result, nil
+		}
+	}
+}
+
+// followAlias returns the name qname is redirected to by cname or dname in
+// answer, dname is returned together with the cname synthesized from it,
+// which replaces the cname from server. Dname above zone is ignored
+func followAlias(answer g53.Section, zone, qname *g53.Name, typ g53.RRType) (*g53.Name, []*g53.RRset) {
+	for _, rrset := range answer {
+		if rrset.Type != g53.RR_DNAME || len(rrset.Rdatas) == 0 || isStrictSubdomain(qname, rrset.Name) == false ||
+			isSubdomain(rrset.Name, zone) == false {
+			continue
+		}
+
+		prefix, err := qname.StripRight(rrset.Name.LabelCount() - 1)
+		if err != nil {
+			return nil, nil
+		}
+		target, err := prefix.Concat(rrset.Rdatas[0].(*g53.DName).Target)
+		if err != nil {
+			return nil, nil
+		}
+
+		cname := &g53.RRset{
+			Name:   qname,
+			Type:   g53.RR_CNAME,
+			Class:  rrset.Class,
+			Ttl:    rrset.Ttl,
+			Rdatas: []g53.Rdata{&g53.CName{Name: target}},
+		}
+		return target, []*g53.RRset{rrset, cname}
+	}
+
+	if typ != g53.RR_CNAME {
+		if rrset := findRRset(answer, qname, g53.RR_CNAME); rrset != nil && len(rrset.Rdatas) > 0 {
+			return rrset.Rdatas[0].(*g53.CName).Name, []*g53.RRset{rrset}
+		}
+	}
+	return nil, nil
+}
+
+// iterate follows referrals from root until a server answers. With qname
+// minimisation, A query of the name with one more label than the known zone
+// is sent to find the next zone cut, the full qname is sent if the name
+// has alias, or the query gets NXDOMAIN or fails in relaxed mode. The zone
+// of the server which gives the response is returned with it
+func (res *resolution) iterate(qname *g53.Name, typ g53.RRType, depth int) (*g53.Message, *g53.Name, error) {
+	zone := g53.Root
+	servers := res.r.rootHints()
+	m := res.r.newMinimiser(qname)
+	for i := 0; ; i++ {
+		if i > limit(res.r.MaxReferrals, DefaultMaxReferrals) {
+			return nil, nil, ErrTooMuchWork
+		}
+
+		name := m.next()
+		if name == qname {
+			resp, err := res.ask(zone, servers, qname, typ, depth)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			cut, nss := referral(resp, zone, qname)
+			if cut == nil {
+				return resp, zone, nil
+			}
+			zone, servers = cut, nss
+			m.cut(cut)
+			continue
+		}
+
+		resp, err := res.ask(zone, servers, name, g53.RR_A, depth)
+		switch {
+		case err == ErrTooMuchWork:
+			return nil, nil, err
+		case err != nil || resp.Header.Rcode == g53.R_NXDOMAIN:
+			if m.mode == QNAME_MIN_STRICT {
+				return resp, zone, err
+			}
+			m.fallback()
+		case isAlias(resp):
+			m.fallback()
+		default:
+			if cut, nss := referral(resp, zone, name); cut != nil {
+				zone, servers = cut, nss
+				m.cut(cut)
+			} else {
+				m.noCut(name)
+			}
+		}
+	}
+}
+
+// ask sends query to servers of zone until one gives usable response,
+// servers with glue are tried before the ones whose addresses have to be
+// resolved
+func (res *resolution) ask(zone *g53.Name, servers []*NameServer, qname *g53.Name, typ g53.RRType, depth int) (*g53.Message, error) {
+	query := g53.MakeQuery(qname, typ, EdnsUDPSize, false)
+	query.Header.SetFlag(g53.FLAG_RD, false)
+
+	var noGlue []*NameServer
+	for _, ns := range servers {
+		if len(ns.Addrs) == 0 {
+			noGlue = append(noGlue, ns)
+			continue
+		}
+		if resp, err := res.send(query, ns.Addrs, zone); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
+	for _, ns := range noGlue {
+		addrs, err := res.lookupAddrs(ns.Name, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err := res.send(query, addrs, zone); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+	return nil, ErrNoAnswer
+}
+
+// nil is returned if no address gives usable response, error is only
+// returned when the work limit is reached
+func (res *resolution) send(query *g53.Message, addrs []net.IP, zone *g53.Name) (*g53.Message, error) {
+	for _, addr := range addrs {
+		if res.queries >= limit(res.r.MaxQueries, DefaultMaxQueries) {
+			return nil, ErrTooMuchWork
+		}
+		res.queries += 1
+
+		resp, _, err := res.r.Exchanger.ExchangeWith(query, net.JoinHostPort(addr.String(), res.r.port()))
+		if err == nil && isUsable(resp, zone, query.Question.Name) {
+			return resp, nil
+		}
+	}
+	return nil, nil
+}
+
+// addresses of name server which can't be resolved are ignored, only the
+// work limit stops the resolution
+func (res *resolution) lookupAddrs(name *g53.Name, depth int) ([]net.IP, error) {
+	result, err := res.resolve(name, g53.RR_A, depth)
+	if err == ErrTooMuchWork {
+		return nil, err
+	} else if err != nil {
+		return nil, nil
+	}
+
+	var addrs []net.IP
+	for _, rrset := range result.answer {
+		if rrset.Type == g53.RR_A {
+			for _, rdata := range rrset.Rdatas {
+				addrs = append(addrs, rdata.(*g53.A).Host)
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// response is usable if it has answer, is authoritative or is a referral to
+// a zone below the current zone, others like referral upward are lame
+func isUsable(resp *g53.Message, zone, qname *g53.Name) bool {
+	switch resp.Header.Rcode {
+	case g53.R_NXDOMAIN:
+		return true
+	case g53.R_NOERROR:
+		if len(resp.Sections[g53.AnswerSection]) > 0 || resp.Header.GetFlag(g53.FLAG_AA) {
+			return true
+		}
+		cut, _ := referral(resp, zone, qname)
+		return cut != nil
+	default:
+		return false
+	}
+}
+
+// referral returns the zone cut below zone and its name servers, glue is
+// only accepted for servers whose names are in zone
+func referral(resp *g53.Message, zone, qname *g53.Name) (*g53.Name, []*NameServer) {
+	if resp.Header.Rcode != g53.R_NOERROR || resp.Header.GetFlag(g53.FLAG_AA) ||
+		len(resp.Sections[g53.AnswerSection]) > 0 {
+		return nil, nil
+	}
+
+	for _, rrset := range resp.Sections[g53.AuthSection] {
+		if rrset.Type != g53.RR_NS || isStrictSubdomain(rrset.Name, zone) == false ||
+			isSubdomain(qname, rrset.Name) == false {
+			continue
+		}
+
+		var servers []*NameServer
+		for _, rdata := range rrset.Rdatas {
+			ns := &NameServer{Name: rdata.(*g53.NS).Name}
+			if isSubdomain(ns.Name, zone) {
+				for _, glue := range resp.Sections[g53.AdditionalSection] {
+					if glue.Name.Equals(ns.Name) == false {
+						continue
+					}
+					for _, rdata := range glue.Rdatas {
+						switch addr := rdata.(type) {
+						case *g53.A:
+							ns.Addrs = append(ns.Addrs, addr.Host)
+						case *g53.AAAA:
+							ns.Addrs = append(ns.Addrs, addr.Host)
+						}
+					}
+				}
+			}
+			servers = append(servers, ns)
+		}
+		return rrset.Name, servers
+	}
+	return nil, nil
+}
@@ -0,0 +1,388 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/server"
+	"github.com/mistletoeChao/g53/util"
+)
+
+// fakeZone answers like an authoritative server, names below zone cut get
+// referral and names below dname get synthesized cname. Broken zone answers
+// NXDOMAIN for empty non-terminals
+// answer of fakeZone has rrsets in inject appended to simulate server
+// which sends data of other zones
+type fakeZone struct {
+	origin *g53.Name
+	data   map[string][]*g53.RRset
+	broken bool
+	inject []*g53.RRset
+}
+
+func newFakeZone(origin string, rrsets ...*g53.RRset) *fakeZone {
+	name, _ := g53.NameFromString(origin)
+	z := &fakeZone{
+		origin: name,
+		data:   make(map[string][]*g53.RRset),
+	}
+	z.add(g53.BuildRRset(origin, g53.RR_SOA, 3600, "ns.invalid. root.invalid. 1 3600 900 86400"))
+	for _, rrset := range rrsets {
+		z.add(rrset)
+	}
+	return z
+}
+
+func (z *fakeZone) add(rrset *g53.RRset) {
+	key := nameKey(rrset.Name)
+	z.data[key] = append(z.data[key], rrset)
+}
+
+func (z *fakeZone) get(name *g53.Name, typ g53.RRType) *g53.RRset {
+	return findRRset(z.data[nameKey(name)], name, typ)
+}
+
+func (z *fakeZone) exists(name *g53.Name) bool {
+	if z.broken {
+		return len(z.data[nameKey(name)]) > 0
+	}
+
+	for _, rrsets := range z.data {
+		if isSubdomain(rrsets[0].Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (z *fakeZone) answer(query *g53.Message) *g53.Message {
+	resp := query.MakeResponse()
+	resp.Edns = nil
+	qname := query.Question.Name
+	typ := query.Question.Type
+	for i := qname.LabelCount() - z.origin.LabelCount(); i > 0; i-- {
+		name, _ := qname.StripLeft(i - 1)
+		if ns := z.get(name, g53.RR_NS); ns != nil {
+			resp.AddRRset(g53.AuthSection, ns)
+			for _, rdata := range ns.Rdatas {
+				if a := z.get(rdata.(*g53.NS).Name, g53.RR_A); a != nil {
+					resp.AddRRset(g53.AdditionalSection, a)
+				}
+			}
+			return resp
+		}
+
+		if dname := z.get(name, g53.RR_DNAME); dname != nil && i > 1 {
+			resp.Header.SetFlag(g53.FLAG_AA, true)
+			_, chain := followAlias([]*g53.RRset{dname}, z.origin, qname, typ)
+			resp.Sections[g53.AnswerSection] = chain
+			return resp
+		}
+	}
+
+	resp.Header.SetFlag(g53.FLAG_AA, true)
+	if rrset := z.get(qname, typ); rrset != nil {
+		resp.AddRRset(g53.AnswerSection, rrset)
+	} else if cname := z.get(qname, g53.RR_CNAME); cname != nil {
+		resp.AddRRset(g53.AnswerSection, cname)
+		for _, rrset := range z.inject {
+			resp.AddRRset(g53.AnswerSection, rrset)
+		}
+	} else {
+		if z.exists(qname) == false {
+			resp.Header.Rcode = g53.R_NXDOMAIN
+		}
+		resp.AddRRset(g53.AuthSection, z.get(z.origin, g53.RR_SOA))
+	}
+	return resp
+}
+
+// fakeNet delivers queries to the fake servers by address without network,
+// queries to unknown address time out
+type fakeNet struct {
+	lock    sync.Mutex
+	servers map[string][]*fakeZone
+	queries []string
+}
+
+func newFakeNet() *fakeNet {
+	return &fakeNet{
+		servers: make(map[string][]*fakeZone),
+	}
+}
+
+func (n *fakeNet) serve(addr string, zones ...*fakeZone) {
+	n.servers[addr] = append(n.servers[addr], zones...)
+}
+
+func (n *fakeNet) sent() []string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return append([]string{}, n.queries...)
+}
+
+// sentTo returns the names and types of queries sent to addr
+func (n *fakeNet) sentTo(addr string) []string {
+	var queries []string
+	for _, q := range n.sent() {
+		if strings.HasPrefix(q, addr+" ") {
+			queries = append(queries, strings.TrimPrefix(q, addr+" "))
+		}
+	}
+	return queries
+}
+
+func (n *fakeNet) reset() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.queries = nil
+}
+
+func (n *fakeNet) ExchangeWith(query *g53.Message, server string) (*g53.Message, time.Duration, error) {
+	host, _, _ := net.SplitHostPort(server)
+	n.lock.Lock()
+	n.queries = append(n.queries, host+" "+query.Question.Name.String(false)+" "+query.Question.Type.String())
+	zones := n.servers[host]
+	n.lock.Unlock()
+	if len(zones) == 0 {
+		return nil, 0, errors.New("query timeout")
+	}
+
+	var zone *fakeZone
+	for _, z := range zones {
+		if isSubdomain(query.Question.Name, z.origin) &&
+			(zone == nil || z.origin.LabelCount() > zone.origin.LabelCount()) {
+			zone = z
+		}
+	}
+
+	var resp *g53.Message
+	if zone == nil {
+		resp = query.MakeResponse()
+		resp.Header.Rcode = g53.R_REFUSED
+	} else {
+		resp = zone.answer(query)
+	}
+
+	render := g53.NewMsgRender()
+	resp.Rend(render)
+	resp, err := g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+	return resp, time.Millisecond, err
+}
+
+// the tree has root, com and net, example.com has glue, other.com is
+// served by name server in provider.net, lame.com has no glue for its in
+// zone name server
+func buildFakeNet() *fakeNet {
+	n := newFakeNet()
+	n.serve("10.0.0.1", newFakeZone(".",
+		g53.BuildRRset(".", g53.RR_NS, 3600, "a.root."),
+		g53.BuildRRset("a.root.", g53.RR_A, 3600, "10.0.0.1"),
+		g53.BuildRRset("com.", g53.RR_NS, 3600, "ns.com."),
+		g53.BuildRRset("ns.com.", g53.RR_A, 3600, "10.0.0.2"),
+		g53.BuildRRset("net.", g53.RR_NS, 3600, "ns.net."),
+		g53.BuildRRset("ns.net.", g53.RR_A, 3600, "10.0.0.3"),
+	))
+	n.serve("10.0.0.2", newFakeZone("com.",
+		g53.BuildRRset("com.", g53.RR_NS, 3600, "ns.com."),
+		g53.BuildRRset("example.com.", g53.RR_NS, 3600, "ns1.example.com."),
+		g53.BuildRRset("ns1.example.com.", g53.RR_A, 3600, "10.0.0.4"),
+		g53.BuildRRset("other.com.", g53.RR_NS, 3600, "ns.provider.net."),
+		g53.BuildRRset("lame.com.", g53.RR_NS, 3600, "ns.lame.com."),
+	))
+	n.serve("10.0.0.3", newFakeZone("net.",
+		g53.BuildRRset("net.", g53.RR_NS, 3600, "ns.net."),
+		g53.BuildRRset("provider.net.", g53.RR_NS, 3600, "ns.provider.net."),
+		g53.BuildRRset("ns.provider.net.", g53.RR_A, 3600, "10.0.0.5"),
+	))
+
+	example := newFakeZone("example.com.",
+		g53.BuildRRset("example.com.", g53.RR_NS, 3600, "ns1.example.com."),
+		g53.BuildRRset("ns1.example.com.", g53.RR_A, 3600, "10.0.0.4"),
+		g53.BuildRRset("www.example.com.", g53.RR_A, 3600, "1.1.1.1"),
+		g53.BuildRRset("alias.example.com.", g53.RR_CNAME, 3600, "www.other.com."),
+		g53.BuildRRset("dn.example.com.", g53.RR_DNAME, 3600, "other.com."),
+		g53.BuildRRset("loop1.example.com.", g53.RR_CNAME, 3600, "loop2.example.com."),
+		g53.BuildRRset("loop2.example.com.", g53.RR_CNAME, 3600, "loop1.example.com."),
+	)
+	for i := 0; i < 10; i++ {
+		example.add(g53.BuildRRset(fmt.Sprintf("c%d.example.com.", i), g53.RR_CNAME, 3600, fmt.Sprintf("c%d.example.com.", i+1)))
+	}
+	n.serve("10.0.0.4", example)
+
+	n.serve("10.0.0.5", newFakeZone("provider.net.",
+		g53.BuildRRset("provider.net.", g53.RR_NS, 3600, "ns.provider.net."),
+		g53.BuildRRset("ns.provider.net.", g53.RR_A, 3600, "10.0.0.5"),
+	), newFakeZone("other.com.",
+		g53.BuildRRset("other.com.", g53.RR_NS, 3600, "ns.provider.net."),
+		g53.BuildRRset("www.other.com.", g53.RR_A, 3600, "2.2.2.2"),
+	))
+	return n
+}
+
+func newTestResolver(n *fakeNet) *Resolver {
+	r := NewResolver()
+	root, _ := g53.NameFromString("a.root.")
+	down, _ := g53.NameFromString("down.root.")
+	r.RootHints = []*NameServer{
+		{Name: down, Addrs: []net.IP{net.ParseIP("10.0.0.100")}},
+		{Name: root, Addrs: []net.IP{net.ParseIP("10.0.0.1")}},
+	}
+	r.Exchanger = n
+	return r
+}
+
+func answerStrings(msg *g53.Message) []string {
+	var ss []string
+	for _, rrset := range msg.Sections[g53.AnswerSection] {
+		for _, rdata := range rrset.Rdatas {
+			ss = append(ss, rrset.Name.String(false)+" "+rrset.Type.String()+" "+rdata.String())
+		}
+	}
+	return ss
+}
+
+func TestResolve(t *testing.T) {
+	r := newTestResolver(buildFakeNet())
+
+	name, _ := g53.NameFromString("www.example.com.")
+	resp, err := r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Assert(t, resp.Header.GetFlag(g53.FLAG_RA), "ra should be set")
+	g53.Equal(t, answerStrings(resp), []string{"www.example.com. A 1.1.1.1"})
+
+	//address of name server without glue is resolved
+	name, _ = g53.NameFromString("alias.example.com.")
+	resp, err = r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, answerStrings(resp), []string{
+		"alias.example.com. CNAME www.other.com.",
+		"www.other.com. A 2.2.2.2",
+	})
+
+	name, _ = g53.NameFromString("www.dn.example.com.")
+	resp, err = r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, answerStrings(resp), []string{
+		"dn.example.com. DNAME other.com.",
+		"www.dn.example.com. CNAME www.other.com.",
+		"www.other.com. A 2.2.2.2",
+	})
+
+	name, _ = g53.NameFromString("nonexist.example.com.")
+	resp, err = r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NXDOMAIN))
+	g53.Equal(t, resp.Sections[g53.AuthSection][0].Type, g53.RRType(g53.RR_SOA))
+
+	name, _ = g53.NameFromString("www.example.com.")
+	resp, err = r.Resolve(name, g53.RRType(g53.RR_AAAA))
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, len(resp.Sections[g53.AnswerSection]), 0)
+	g53.Equal(t, resp.Sections[g53.AuthSection][0].Name.String(false), "example.com.")
+}
+
+func TestResolveLimits(t *testing.T) {
+	n := buildFakeNet()
+	r := newTestResolver(n)
+
+	name, _ := g53.NameFromString("loop1.example.com.")
+	_, err := r.Resolve(name, g53.RR_A)
+	g53.Equal(t, err, ErrLoop)
+
+	name, _ = g53.NameFromString("c0.example.com.")
+	_, err = r.Resolve(name, g53.RR_A)
+	g53.Equal(t, err, ErrTooMuchWork)
+
+	//glue of ns.lame.com. can't be resolved without itself
+	name, _ = g53.NameFromString("www.lame.com.")
+	_, err = r.Resolve(name, g53.RR_A)
+	g53.Equal(t, err, ErrNoAnswer)
+
+	r.MaxQueries = 5
+	name, _ = g53.NameFromString("alias.example.com.")
+	_, err = r.Resolve(name, g53.RR_A)
+	g53.Equal(t, err, ErrTooMuchWork)
+	g53.Assert(t, len(n.sent()) > 0, "queries should be sent")
+
+	r.MaxQueries = 0
+	r.MaxDepth = 1
+	name, _ = g53.NameFromString("www.other.com.")
+	_, err = r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err == nil, "one level nested resolution is allowed:%v", err)
+	r.MaxDepth = 0
+	r.RootHints[1].Addrs = nil
+	r.RootHints[1].Name, _ = g53.NameFromString("a.root.")
+	_, err = r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err != nil, "root hints without address can't be used")
+}
+
+func TestResolveOutOfBailiwick(t *testing.T) {
+	n := buildFakeNet()
+	n.servers["10.0.0.2"][0].add(g53.BuildRRset("bank.com.", g53.RR_NS, 3600, "ns.bank.com."))
+	n.servers["10.0.0.2"][0].add(g53.BuildRRset("ns.bank.com.", g53.RR_A, 3600, "10.0.0.6"))
+	n.serve("10.0.0.6", newFakeZone("bank.com.",
+		g53.BuildRRset("bank.com.", g53.RR_NS, 3600, "ns.bank.com."),
+		g53.BuildRRset("www.bank.com.", g53.RR_A, 3600, "3.3.3.3"),
+	))
+	example := n.servers["10.0.0.4"][0]
+	example.add(g53.BuildRRset("bank.example.com.", g53.RR_CNAME, 3600, "www.bank.com."))
+	example.inject = []*g53.RRset{
+		g53.BuildRRset("www.bank.com.", g53.RR_A, 3600, "6.6.6.6"),
+		g53.BuildRRset("com.", g53.RR_DNAME, 3600, "attacker.net."),
+	}
+	r := newTestResolver(n)
+
+	//cname target out of example.com is resolved from root
+	name, _ := g53.NameFromString("bank.example.com.")
+	resp, err := r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, answerStrings(resp), []string{
+		"bank.example.com. CNAME www.bank.com.",
+		"www.bank.com. A 3.3.3.3",
+	})
+	g53.Equal(t, n.sentTo("10.0.0.6"), []string{"www.bank.com. A"})
+}
+
+func TestResolveEmptyRdata(t *testing.T) {
+	n := buildFakeNet()
+	name, _ := g53.NameFromString("empty.example.com.")
+	n.servers["10.0.0.4"][0].add(&g53.RRset{Name: name, Type: g53.RR_CNAME, Class: g53.CLASS_ANY})
+	r := newTestResolver(n)
+
+	_, err := r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err != nil, "answer with empty cname should be dropped")
+}
+
+type recordWriter struct {
+	resp *g53.Message
+}
+
+func (w *recordWriter) LocalAddr() net.Addr         { return nil }
+func (w *recordWriter) RemoteAddr() net.Addr        { return nil }
+func (w *recordWriter) Transport() server.Transport { return server.TRANSPORT_UDP }
+func (w *recordWriter) WriteData(data []byte) error { return nil }
+func (w *recordWriter) Write(resp *g53.Message) error {
+	w.resp = resp
+	return nil
+}
+
+func TestResolverServeDNS(t *testing.T) {
+	r := newTestResolver(buildFakeNet())
+	name, _ := g53.NameFromString("www.example.com.")
+	w := &recordWriter{}
+	r.ServeDNS(w, g53.MakeQuery(name, g53.RR_A, 4096, false))
+	g53.Equal(t, answerStrings(w.resp), []string{"www.example.com. A 1.1.1.1"})
+	g53.Assert(t, w.resp.Edns != nil, "response should have edns")
+
+	name, _ = g53.NameFromString("loop1.example.com.")
+	r.ServeDNS(w, g53.MakeQuery(name, g53.RR_A, 4096, false))
+	g53.Equal(t, w.resp.Header.Rcode, g53.Rcode(g53.R_SERVFAIL))
+}
@@ -0,0 +1,104 @@
+package resolver
+
+import (
+	"github.com/mistletoeChao/g53"
+)
+
+type QNameMinMode uint8
+
+// in relaxed mode, full qname is sent when minimised query gets NXDOMAIN or
+// fails, which works around servers broken on empty non-terminals. In
+// strict mode NXDOMAIN of minimised query is the final answer as rfc8020
+const (
+	QNAME_MIN_OFF     QNameMinMode = 0
+	QNAME_MIN_RELAXED              = 1
+	QNAME_MIN_STRICT               = 2
+)
+
+var QNameMinModeStr = map[QNameMinMode]string{
+	QNAME_MIN_OFF:     "off",
+	QNAME_MIN_RELAXED: "relaxed",
+	QNAME_MIN_STRICT:  "strict",
+}
+
+func (m QNameMinMode) String() string {
+	return QNameMinModeStr[m]
+}
+
+// limits of rfc9156, the first MinimiseOneLabel iterations reveal one
+// label each, the following ones reveal more labels so one name is resolved
+// in at most MaxMinimiseCount iterations
+const (
+	DefaultMaxMinimiseCount = 10
+	DefaultMinimiseOneLabel = 4
+)
+
+// minimiser decides the name of each minimised query for qname
+type minimiser struct {
+	qname      *g53.Name
+	mode       QNameMinMode
+	maxCount   int
+	oneLabel   int
+	iterations int
+	revealed   uint
+}
+
+func (r *Resolver) newMinimiser(qname *g53.Name) *minimiser {
+	return &minimiser{
+		qname:    qname,
+		mode:     r.QNameMinimisation,
+		maxCount: limit(r.MaxMinimiseCount, DefaultMaxMinimiseCount),
+		oneLabel: limit(r.MinimiseOneLabel, DefaultMinimiseOneLabel),
+		revealed: g53.Root.LabelCount(),
+	}
+}
+
+// next returns the name to query, which is qname if minimisation is off,
+// stopped or there is only one label left
+func (m *minimiser) next() *g53.Name {
+	remaining := int(m.qname.LabelCount() - m.revealed)
+	if m.mode == QNAME_MIN_OFF || remaining <= 1 {
+		return m.qname
+	}
+
+	m.iterations += 1
+	step := 1
+	if m.iterations > m.oneLabel {
+		left := m.maxCount - m.iterations
+		if left <= 0 {
+			return m.qname
+		}
+		step = (remaining + left - 1) / left
+	}
+
+	if step >= remaining {
+		return m.qname
+	}
+	name, _ := m.qname.StripLeft(uint(remaining - step))
+	return name
+}
+
+// cut is called when referral to zone is followed
+func (m *minimiser) cut(zone *g53.Name) {
+	m.revealed = zone.LabelCount()
+}
+
+// noCut is called when name isn't a zone cut
+func (m *minimiser) noCut(name *g53.Name) {
+	m.revealed = name.LabelCount()
+}
+
+// fallback stops minimisation, full qname is sent to the servers of the
+// current zone
+func (m *minimiser) fallback() {
+	m.mode = QNAME_MIN_OFF
+}
+
+func isAlias(resp *g53.Message) bool {
+	for _, rrset := range resp.Sections[g53.AnswerSection] {
+		if rrset.Type == g53.RR_CNAME || rrset.Type == g53.RR_DNAME {
+			return true
+		}
+	}
+	return false
+}
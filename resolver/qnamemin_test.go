@@ -0,0 +1,79 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/mistletoeChao/g53"
+)
+
+func TestQNameMinimisation(t *testing.T) {
+	n := buildFakeNet()
+	r := newTestResolver(n)
+
+	name, _ := g53.NameFromString("www.example.com.")
+	resp, err := r.Resolve(name, g53.RRType(g53.RR_AAAA))
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, n.sentTo("10.0.0.1"), []string{"com. A"})
+	g53.Equal(t, n.sentTo("10.0.0.2"), []string{"example.com. A"})
+	g53.Equal(t, n.sentTo("10.0.0.4"), []string{"www.example.com. AAAA"})
+
+	n.reset()
+	r.QNameMinimisation = QNAME_MIN_OFF
+	r.Resolve(name, g53.RRType(g53.RR_AAAA))
+	g53.Equal(t, n.sentTo("10.0.0.1"), []string{"www.example.com. AAAA"})
+	g53.Equal(t, n.sentTo("10.0.0.2"), []string{"www.example.com. AAAA"})
+}
+
+func TestQNameMinimisationDeepName(t *testing.T) {
+	n := buildFakeNet()
+	deep := "a.b.c.d.e.f.g.h.i.j.k.l.m.n.deep.example.com."
+	n.servers["10.0.0.4"][0].add(g53.BuildRRset(deep, g53.RR_A, 3600, "3.3.3.3"))
+	r := newTestResolver(n)
+
+	name, _ := g53.NameFromString(deep)
+	resp, err := r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, answerStrings(resp), []string{deep + " A 3.3.3.3"})
+
+	//com and example.com take the first 2 iterations
+	queries := n.sentTo("10.0.0.4")
+	g53.Assert(t, len(queries) <= DefaultMaxMinimiseCount-2, "too many minimised queries %v", queries)
+	g53.Equal(t, queries[0], "deep.example.com. A")
+	g53.Equal(t, queries[1], "n.deep.example.com. A")
+	g53.Equal(t, queries[len(queries)-1], deep+" A")
+}
+
+func TestQNameMinimisationBrokenServer(t *testing.T) {
+	n := buildFakeNet()
+	example := n.servers["10.0.0.4"][0]
+	example.add(g53.BuildRRset("www.ent.example.com.", g53.RR_A, 3600, "4.4.4.4"))
+	r := newTestResolver(n)
+	name, _ := g53.NameFromString("www.ent.example.com.")
+
+	//empty non-terminal exists
+	resp, err := r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, answerStrings(resp), []string{"www.ent.example.com. A 4.4.4.4"})
+
+	//full qname is sent after NXDOMAIN of empty non-terminal
+	example.broken = true
+	n.reset()
+	resp, err = r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, answerStrings(resp), []string{"www.ent.example.com. A 4.4.4.4"})
+	g53.Equal(t, n.sentTo("10.0.0.4"), []string{"ent.example.com. A", "www.ent.example.com. A"})
+
+	r.QNameMinimisation = QNAME_MIN_STRICT
+	resp, err = r.Resolve(name, g53.RR_A)
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NXDOMAIN))
+
+	//server which doesn't answer minimised query
+	delete(n.servers, "10.0.0.4")
+	r.QNameMinimisation = QNAME_MIN_RELAXED
+	n.reset()
+	_, err = r.Resolve(name, g53.RR_A)
+	g53.Equal(t, err, ErrNoAnswer)
+	g53.Equal(t, n.sentTo("10.0.0.4"), []string{"ent.example.com. A", "www.ent.example.com. A"})
+}
@@ -0,0 +1,156 @@
+package resolver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultResolvConf = "/etc/resolv.conf"
+	DefaultNdots      = 1
+	DefaultTimeout    = 5 * time.Second
+	DefaultAttempts   = 2
+
+	maxNdots    = 15
+	maxTimeout  = 30 * time.Second
+	maxAttempts = 5
+	dnsPort     = "53"
+)
+
+// Config is the resolver settings of resolv.conf, limits and defaults of
+// the options are the same as glibc
+type Config struct {
+	Servers  []string
+	Search   []string
+	Ndots    int
+	Timeout  time.Duration
+	Attempts int
+	Rotate   bool
+	Edns0    bool
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Ndots:    DefaultNdots,
+		Timeout:  DefaultTimeout,
+		Attempts: DefaultAttempts,
+	}
+}
+
+// ParseResolvConf reads resolver settings from file at path, local server
+// is used if there is no nameserver
+func ParseResolvConf(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseResolvConf(f)
+}
+
+func parseResolvConf(r io.Reader) (*Config, error) {
+	conf := DefaultConfig()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i != -1 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			if len(fields) < 2 {
+				continue
+			}
+			//ipv6 address may have zone like fe80::1%eth0
+			ip := fields[1]
+			if i := strings.IndexByte(ip, '%'); i != -1 {
+				ip = ip[:i]
+			}
+			if net.ParseIP(ip) != nil {
+				conf.Servers = append(conf.Servers, net.JoinHostPort(fields[1], dnsPort))
+			}
+		case "domain":
+			if len(fields) > 1 {
+				conf.Search = []string{fields[1]}
+			}
+		case "search":
+			conf.Search = append([]string{}, fields[1:]...)
+		case "options":
+			for _, opt := range fields[1:] {
+				conf.parseOption(opt)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(conf.Servers) == 0 {
+		conf.Servers = []string{net.JoinHostPort("127.0.0.1", dnsPort)}
+	}
+	return conf, nil
+}
+
+// unknown options and invalid values are ignored, values beyond the limit
+// are capped
+func (conf *Config) parseOption(opt string) {
+	value := func(prefix string, max int) (int, bool) {
+		n, err := strconv.Atoi(opt[len(prefix):])
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		if n > max {
+			n = max
+		}
+		return n, true
+	}
+
+	switch {
+	case strings.HasPrefix(opt, "ndots:"):
+		if n, ok := value("ndots:", maxNdots); ok {
+			conf.Ndots = n
+		}
+	case strings.HasPrefix(opt, "timeout:"):
+		if n, ok := value("timeout:", int(maxTimeout/time.Second)); ok && n > 0 {
+			conf.Timeout = time.Duration(n) * time.Second
+		}
+	case strings.HasPrefix(opt, "attempts:"):
+		if n, ok := value("attempts:", maxAttempts); ok && n > 0 {
+			conf.Attempts = n
+		}
+	case opt == "rotate":
+		conf.Rotate = true
+	case opt == "edns0":
+		conf.Edns0 = true
+	}
+}
+
+// NameList returns the names to query for name in order as glibc does, name
+// with enough dots is tried as it is before appending search domains
+func (conf *Config) NameList(name string) []string {
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+
+	var names []string
+	for _, domain := range conf.Search {
+		names = append(names, name+"."+strings.TrimSuffix(domain, ".")+".")
+	}
+
+	if strings.Count(name, ".") >= conf.Ndots {
+		return append([]string{name + "."}, names...)
+	}
+	return append(names, name+".")
+}
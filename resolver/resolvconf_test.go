@@ -0,0 +1,59 @@
+package resolver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	conf, err := parseResolvConf(strings.NewReader(`
+# comment
+nameserver 10.0.0.1
+nameserver fe80::1%eth0 ; link local
+nameserver not-an-ip
+domain corp.example.com
+search example.com. example.net
+options ndots:2 timeout:3 attempts:9 rotate edns0 unknown ndots:x
+`))
+	g53.Assert(t, err == nil, "parse resolv.conf failed:%v", err)
+	g53.Equal(t, conf.Servers, []string{"10.0.0.1:53", "[fe80::1%eth0]:53"})
+	g53.Equal(t, conf.Search, []string{"example.com.", "example.net"})
+	g53.Equal(t, conf.Ndots, 2)
+	g53.Equal(t, conf.Timeout, 3*time.Second)
+	g53.Equal(t, conf.Attempts, 5)
+	g53.Assert(t, conf.Rotate && conf.Edns0, "rotate and edns0 should be set")
+
+	conf, _ = parseResolvConf(strings.NewReader("search a.com\ndomain b.com\noptions timeout:0\n"))
+	g53.Equal(t, conf.Servers, []string{"127.0.0.1:53"})
+	g53.Equal(t, conf.Search, []string{"b.com"})
+	g53.Equal(t, conf.Ndots, DefaultNdots)
+	g53.Equal(t, conf.Timeout, DefaultTimeout)
+	g53.Equal(t, conf.Attempts, DefaultAttempts)
+
+	dir, _ := ioutil.TempDir("", "resolvconf")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "resolv.conf")
+	ioutil.WriteFile(path, []byte("nameserver 192.0.2.1\n"), 0644)
+	conf, err = ParseResolvConf(path)
+	g53.Assert(t, err == nil, "parse resolv.conf failed:%v", err)
+	g53.Equal(t, conf.Servers, []string{"192.0.2.1:53"})
+	_, err = ParseResolvConf(filepath.Join(dir, "missing"))
+	g53.Assert(t, err != nil, "missing file should fail")
+}
+
+func TestNameList(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Search = []string{"a.com", "b.com."}
+	g53.Equal(t, conf.NameList("www"), []string{"www.a.com.", "www.b.com.", "www."})
+	g53.Equal(t, conf.NameList("www.c"), []string{"www.c.", "www.c.a.com.", "www.c.b.com."})
+	g53.Equal(t, conf.NameList("www.c."), []string{"www.c."})
+
+	conf.Ndots = 2
+	g53.Equal(t, conf.NameList("www.c"), []string{"www.c.a.com.", "www.c.b.com.", "www.c."})
+}
@@ -0,0 +1,47 @@
+package resolver
+
+import (
+	"net"
+
+	"github.com/mistletoeChao/g53"
+)
+
+// NameServer is the name and addresses of an authoritative server, addresses
+// are empty if glue isn't known
+type NameServer struct {
+	Name  *g53.Name
+	Addrs []net.IP
+}
+
+var rootServers = []struct {
+	name string
+	ipv4 string
+	ipv6 string
+}{
+	{"a.root-servers.net.", "198.41.0.4", "2001:503:ba3e::2:30"},
+	{"b.root-servers.net.", "170.247.170.2", "2801:1b8:10::b"},
+	{"c.root-servers.net.", "192.33.4.12", "2001:500:2::c"},
+	{"d.root-servers.net.", "199.7.91.13", "2001:500:2d::d"},
+	{"e.root-servers.net.", "192.203.230.10", "2001:500:a8::e"},
+	{"f.root-servers.net.", "192.5.5.241", "2001:500:2f::f"},
+	{"g.root-servers.net.", "192.112.36.4", "2001:500:12::d0d"},
+	{"h.root-servers.net.", "198.97.190.53", "2001:500:1::53"},
+	{"i.root-servers.net.", "192.36.148.17", "2001:7fe::53"},
+	{"j.root-servers.net.", "192.58.128.30", "2001:503:c27::2:30"},
+	{"k.root-servers.net.", "193.0.14.129", "2001:7fd::1"},
+	{"l.root-servers.net.", "199.7.83.42", "2001:500:9f::42"},
+	{"m.root-servers.net.", "202.12.27.33", "2001:dc3::35"},
+}
+
+// DefaultRootHints returns the root servers of iana
+func DefaultRootHints() []*NameServer {
+	hints := make([]*NameServer, 0, len(rootServers))
+	for _, s := range rootServers {
+		name, _ := g53.NameFromString(s.name)
+		hints = append(hints, &NameServer{
+			Name:  name,
+			Addrs: []net.IP{net.ParseIP(s.ipv4), net.ParseIP(s.ipv6)},
+		})
+	}
+	return hints
+}
@@ -0,0 +1,124 @@
+package resolver
+
+import (
+	"sync/atomic"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+)
+
+const EdnsUDPSize = 1232
+
+// StubResolver sends recursive queries to the servers of resolv.conf, each
+// attempt tries all the servers in order, which starts from different
+// server for each query with rotate
+type StubResolver struct {
+	conf   *Config
+	client *client.Client
+	next   uint32
+}
+
+func NewStubResolver(path string) (*StubResolver, error) {
+	conf, err := ParseResolvConf(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewStubResolverWithConfig(conf), nil
+}
+
+func NewStubResolverWithConfig(conf *Config) *StubResolver {
+	return &StubResolver{
+		conf: conf,
+		client: &client.Client{
+			Timeout: conf.Timeout,
+		},
+	}
+}
+
+func (r *StubResolver) Config() *Config {
+	return r.conf
+}
+
+func (r *StubResolver) servers() []string {
+	servers := r.conf.Servers
+	if r.conf.Rotate == false || len(servers) < 2 {
+		return servers
+	}
+
+	start := int(atomic.AddUint32(&r.next, 1)-1) % len(servers)
+	return append(append([]string{}, servers[start:]...), servers[:start]...)
+}
+
+// Resolve looks up name which is expanded by the search list if it's
+// relative, the next name is tried if the current one doesn't exist or has
+// no record of typ. Response without answer is returned if no name has data
+func (r *StubResolver) Resolve(name string, typ g53.RRType) (*g53.Message, error) {
+	var nodata, last *g53.Message
+	for _, n := range r.conf.NameList(name) {
+		qname, err := g53.NameFromString(n)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := r.Query(qname, typ)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case response.Header.Rcode == g53.R_NXDOMAIN:
+			last = response
+		case response.Header.Rcode == g53.R_NOERROR && len(response.Sections[g53.AnswerSection]) == 0:
+			if nodata == nil {
+				nodata = response
+			}
+			last = response
+		default:
+			return response, nil
+		}
+	}
+
+	if nodata != nil {
+		return nodata, nil
+	}
+	return last, nil
+}
+
+// Query sends query of name and typ without search list, server which
+// answers SERVFAIL, REFUSED or NOTIMP is skipped, and the response is
+// returned if no other server answers
+func (r *StubResolver) Query(name *g53.Name, typ g53.RRType) (*g53.Message, error) {
+	query := g53.MakeQuery(name, typ, EdnsUDPSize, false)
+	if r.conf.Edns0 == false {
+		query.Edns = nil
+	}
+
+	servers := r.servers()
+	if len(servers) == 0 {
+		return nil, client.ErrNoServer
+	}
+
+	var failed *g53.Message
+	var lastErr error
+	for i := 0; i < r.conf.Attempts || i == 0; i++ {
+		for _, server := range servers {
+			response, _, err := r.client.ExchangeWith(query, server)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			switch response.Header.Rcode {
+			case g53.R_SERVFAIL, g53.R_REFUSED, g53.R_NOTIMP:
+				failed = response
+			default:
+				return response, nil
+			}
+		}
+
+		if failed != nil {
+			return failed, nil
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,170 @@
+package resolver
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/server"
+)
+
+// fakeServer answers A of names in data, NXDOMAIN for other names, and
+// records the names it's asked
+type fakeServer struct {
+	rcode g53.Rcode
+	data  map[string]string
+
+	lock    sync.Mutex
+	queries []*g53.Message
+}
+
+func (s *fakeServer) ServeDNS(w server.ResponseWriter, req *g53.Message) {
+	s.lock.Lock()
+	s.queries = append(s.queries, req)
+	s.lock.Unlock()
+
+	resp := req.MakeResponse()
+	resp.Header.Rcode = s.rcode
+	if s.rcode == g53.R_NOERROR {
+		if ip, ok := s.data[req.Question.Name.String(false)]; ok {
+			if req.Question.Type == g53.RR_A {
+				a, _ := g53.AFromString(ip)
+				resp.AddRr(g53.AnswerSection, req.Question.Name, g53.RR_A, g53.CLASS_IN, a, true)
+			}
+		} else {
+			resp.Header.Rcode = g53.R_NXDOMAIN
+		}
+	}
+	w.Write(resp)
+}
+
+func (s *fakeServer) names() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var names []string
+	for _, q := range s.queries {
+		names = append(names, q.Question.Name.String(false))
+	}
+	return names
+}
+
+func (s *fakeServer) query(i int) *g53.Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.queries[i]
+}
+
+func (s *fakeServer) reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.queries = nil
+}
+
+func startFake(t *testing.T, h *fakeServer) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed:%v", err)
+	}
+	s := &server.Server{Handler: h}
+	go s.ServeUDP(conn)
+	return conn.LocalAddr().String(), func() { s.Shutdown(context.Background()) }
+}
+
+func TestStubResolve(t *testing.T) {
+	fake := &fakeServer{
+		data: map[string]string{
+			"www.b.com.": "10.0.0.2",
+			"www.c.com.": "10.0.0.3",
+		},
+	}
+	addr, stop := startFake(t, fake)
+	defer stop()
+
+	conf := DefaultConfig()
+	conf.Servers = []string{addr}
+	conf.Search = []string{"a.com", "b.com"}
+	conf.Timeout = time.Second
+	r := NewStubResolverWithConfig(conf)
+
+	resp, err := r.Resolve("www", g53.RR_A)
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, resp.Question.Name.String(false), "www.b.com.")
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "10.0.0.2")
+	g53.Equal(t, fake.names(), []string{"www.a.com.", "www.b.com."})
+	g53.Assert(t, fake.query(0).Edns == nil, "edns0 isn't enabled")
+
+	//nodata is preferred to nxdomain of the last name
+	resp, err = r.Resolve("www", g53.RRType(g53.RR_AAAA))
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, resp.Question.Name.String(false), "www.b.com.")
+
+	resp, err = r.Resolve("nonexist", g53.RR_A)
+	g53.Assert(t, err == nil, "resolve failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NXDOMAIN))
+	g53.Equal(t, resp.Question.Name.String(false), "nonexist.")
+
+	conf.Edns0 = true
+	fake.reset()
+	resp, _ = r.Resolve("www.c.com", g53.RR_A)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "10.0.0.3")
+	g53.Equal(t, fake.names(), []string{"www.c.com."})
+	g53.Equal(t, fake.query(0).Edns.UdpSize, uint16(EdnsUDPSize))
+}
+
+func TestStubServers(t *testing.T) {
+	failed := &fakeServer{rcode: g53.R_SERVFAIL}
+	failedAddr, stop := startFake(t, failed)
+	defer stop()
+	good := &fakeServer{data: map[string]string{"www.a.com.": "10.0.0.1"}}
+	goodAddr, stop := startFake(t, good)
+	defer stop()
+
+	//unreachable server is skipped
+	ln, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	silent := ln.LocalAddr().String()
+	defer ln.Close()
+
+	dir, _ := ioutil.TempDir("", "resolvconf")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "resolv.conf")
+	ioutil.WriteFile(path, []byte("nameserver 127.0.0.1\n"), 0644)
+	r, err := NewStubResolver(path)
+	g53.Assert(t, err == nil, "create resolver failed:%v", err)
+	r.Config().Servers = []string{silent, failedAddr, goodAddr}
+	r.client.Timeout = 100 * time.Millisecond
+
+	name, _ := g53.NameFromString("www.a.com.")
+	resp, err := r.Query(name, g53.RR_A)
+	g53.Assert(t, err == nil, "query failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+
+	//servfail is returned if no server answers
+	r.Config().Servers = []string{silent, failedAddr}
+	resp, err = r.Query(name, g53.RR_A)
+	g53.Assert(t, err == nil, "query failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_SERVFAIL))
+
+	r.Config().Servers = []string{silent}
+	r.Config().Attempts = 1
+	_, err = r.Query(name, g53.RR_A)
+	g53.Assert(t, err != nil, "query should timeout")
+
+	//rotate
+	r.Config().Servers = []string{failedAddr, goodAddr}
+	r.Config().Rotate = true
+	failed.reset()
+	good.reset()
+	for i := 0; i < 4; i++ {
+		resp, _ = r.Query(name, g53.RR_A)
+		g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+	}
+	g53.Equal(t, len(failed.names()), 2)
+	g53.Equal(t, len(good.names()), 4)
+}
@@ -0,0 +1,143 @@
+package rpz
+
+import (
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/server"
+)
+
+// Handler rewrites the responses of Next by response policy. The query is
+// passed to Next only if no qname trigger matches before the zones with
+// triggers of response, then the response of Next is checked against the
+// zones in order again, where the names in the cname chain of the answer
+// are checked by qname triggers too. The rule matching a name in the chain
+// rewrites the answer from that name, the cnames leading to it are kept.
+// Local data of cname isn't chased
+type Handler struct {
+	Evaluator *Evaluator
+	Next      server.Handler
+}
+
+func NewHandler(evaluator *Evaluator, next server.Handler) *Handler {
+	return &Handler{
+		Evaluator: evaluator,
+		Next:      next,
+	}
+}
+
+func (h *Handler) ServeDNS(w server.ResponseWriter, req *g53.Message) {
+	if req.Header.Opcode != g53.OP_QUERY || req.Question == nil {
+		h.Next.ServeDNS(w, req)
+		return
+	}
+
+	qname := req.Question.Name
+	rule, _, _ := h.Evaluator.Check(qname, nil)
+	if rule != nil {
+		h.apply(w, req, nil, rule, qname)
+		return
+	}
+
+	cw := server.NewCaptureWriter(w)
+	h.Next.ServeDNS(cw, req)
+	if cw.Response == nil {
+		return
+	}
+
+	if rule, name, _ := h.Evaluator.Check(qname, cw.Response); rule != nil {
+		h.apply(w, req, cw.Response, rule, name)
+		return
+	}
+	cw.Flush()
+}
+
+// resp is nil if the rule is applied before resolution, name is the name in
+// the cname chain matching the rule, or nil if the rule is triggered by resp
+func (h *Handler) apply(w server.ResponseWriter, req *g53.Message, resp *g53.Message, rule *Rule, name *g53.Name) {
+	switch rule.Action {
+	case ACTION_DROP:
+		return
+	case ACTION_TCP_ONLY:
+		if w.Transport() == server.TRANSPORT_UDP {
+			tc := makeResponse(req)
+			tc.Header.SetFlag(g53.FLAG_TC, true)
+			w.Write(tc)
+			return
+		}
+		fallthrough
+	case ACTION_PASSTHRU:
+		if resp == nil {
+			h.Next.ServeDNS(w, req)
+		} else {
+			w.Write(resp)
+		}
+		return
+	}
+
+	policy := makeResponse(req)
+	if name == nil {
+		name = req.Question.Name
+	} else if resp != nil {
+		for _, cname := range aliasChain(req.Question.Name, resp.Sections[g53.AnswerSection]) {
+			if cname.Name.Equals(name) {
+				break
+			}
+			policy.AddRRset(g53.AnswerSection, cname)
+		}
+	}
+
+	var local g53.Section
+	switch rule.Action {
+	case ACTION_NXDOMAIN:
+		policy.Header.Rcode = g53.R_NXDOMAIN
+	case ACTION_LOCAL_DATA:
+		local = localData(rule, name, req.Question.Type)
+		policy.Sections[g53.AnswerSection] = append(policy.Sections[g53.AnswerSection], local...)
+	}
+	if len(local) == 0 && rule.soa != nil {
+		policy.AddRRset(g53.AuthSection, rule.soa)
+	}
+	w.Write(policy)
+}
+
+func makeResponse(req *g53.Message) *g53.Message {
+	resp := req.MakeResponse()
+	resp.Header.SetFlag(g53.FLAG_RA, true)
+	if req.Edns != nil {
+		resp.Edns = &g53.EDNS{UdpSize: req.Edns.UdpSize}
+	}
+	return resp
+}
+
+// rrset of typ is answered, or cname if there is no such rrset, all the
+// rrsets are answered for RR_ANY
+func localData(rule *Rule, name *g53.Name, typ g53.RRType) g53.Section {
+	var answer g53.Section
+	var cname *g53.RRset
+	for _, rrset := range rule.Data {
+		if typ == g53.RR_ANY {
+			answer = append(answer, rename(rrset, name))
+			continue
+		}
+		if rrset.Type == typ {
+			return g53.Section{rename(rrset, name)}
+		}
+		if rrset.Type == g53.RR_CNAME {
+			cname = rrset
+		}
+	}
+
+	if cname != nil {
+		return g53.Section{rename(cname, name)}
+	}
+	return answer
+}
+
+func rename(rrset *g53.RRset, name *g53.Name) *g53.RRset {
+	return &g53.RRset{
+		Name:   name,
+		Type:   rrset.Type,
+		Class:  rrset.Class,
+		Ttl:    rrset.Ttl,
+		Rdatas: rrset.Rdatas,
+	}
+}
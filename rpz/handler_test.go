@@ -0,0 +1,156 @@
+package rpz
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/server"
+)
+
+type recordWriter struct {
+	transport server.Transport
+	resp      *g53.Message
+}
+
+func (w *recordWriter) LocalAddr() net.Addr           { return nil }
+func (w *recordWriter) RemoteAddr() net.Addr          { return nil }
+func (w *recordWriter) Transport() server.Transport   { return w.transport }
+func (w *recordWriter) WriteData(data []byte) error   { return nil }
+func (w *recordWriter) Write(resp *g53.Message) error { w.resp = resp; return nil }
+
+// upstream answers www.victim.com. with an address blocked by response ip
+// trigger, and other names with 1.1.1.1, names in aliases are answered with
+// cname to the target and the address of target
+type upstream struct {
+	queries int
+	aliases map[string]string
+}
+
+func (u *upstream) ServeDNS(w server.ResponseWriter, req *g53.Message) {
+	u.queries += 1
+	resp := req.MakeResponse()
+	name := req.Question.Name.String(false)
+	if target, ok := u.aliases[name]; ok {
+		resp.AddRRset(g53.AnswerSection, g53.BuildRRset(name, g53.RR_CNAME, 300, target))
+		name = target
+	}
+	addr := "1.1.1.1"
+	if name == "www.victim.com." {
+		addr = "192.0.2.5"
+	}
+	resp.AddRRset(g53.AnswerSection, g53.BuildRRset(name, g53.RR_A, 300, addr))
+	w.Write(resp)
+}
+
+func TestHandler(t *testing.T) {
+	u := &upstream{}
+	h := NewHandler(NewEvaluator(testPolicyZone()), u)
+	serve := func(name string, typ g53.RRType, transport server.Transport) *g53.Message {
+		n, _ := g53.NameFromString(name)
+		w := &recordWriter{transport: transport}
+		h.ServeDNS(w, g53.MakeQuery(n, typ, 4096, false))
+		return w.resp
+	}
+
+	resp := serve("bad.com.", g53.RR_A, server.TRANSPORT_UDP)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NXDOMAIN))
+	g53.Equal(t, resp.Sections[g53.AuthSection][0].Type, g53.RRType(g53.RR_SOA))
+	g53.Equal(t, u.queries, 0)
+
+	resp = serve("www.bad.com.", g53.RR_A, server.TRANSPORT_UDP)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, len(resp.Sections[g53.AnswerSection]), 0)
+
+	resp = serve("ok.bad.com.", g53.RR_A, server.TRANSPORT_UDP)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "1.1.1.1")
+	g53.Equal(t, u.queries, 1)
+
+	g53.Assert(t, serve("drop.com.", g53.RR_A, server.TRANSPORT_UDP) == nil, "query should be dropped")
+	resp = serve("tcp.com.", g53.RR_A, server.TRANSPORT_UDP)
+	g53.Assert(t, resp.Header.GetFlag(g53.FLAG_TC), "udp response should be truncated")
+	resp = serve("tcp.com.", g53.RR_A, server.TRANSPORT_TCP)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "1.1.1.1")
+
+	//local data is renamed to qname
+	resp = serve("local.com.", g53.RR_A, server.TRANSPORT_UDP)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].String(), "local.com.\t300\tIN\tA\t10.0.0.1\n")
+	resp = serve("local.com.", g53.RR_TXT, server.TRANSPORT_UDP)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Type, g53.RRType(g53.RR_TXT))
+	resp = serve("local.com.", g53.RRType(g53.RR_AAAA), server.TRANSPORT_UDP)
+	g53.Equal(t, len(resp.Sections[g53.AnswerSection]), 0)
+	g53.Equal(t, resp.Sections[g53.AuthSection][0].Type, g53.RRType(g53.RR_SOA))
+	resp = serve("www.local.com.", g53.RR_A, server.TRANSPORT_UDP)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].String(), "www.local.com.\t300\tIN\tCNAME\twalled.example.net.\n")
+	resp = serve("local.com.", g53.RR_ANY, server.TRANSPORT_UDP)
+	g53.Equal(t, len(resp.Sections[g53.AnswerSection]), 2)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][1].String(), "local.com.\t300\tIN\tTXT\t\"blocked\"\n")
+
+	//response ip trigger
+	resp = serve("www.victim.com.", g53.RR_A, server.TRANSPORT_UDP)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NXDOMAIN))
+	resp = serve("www.fine.com.", g53.RR_A, server.TRANSPORT_UDP)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "1.1.1.1")
+}
+
+func TestHandlerAliasChain(t *testing.T) {
+	u := &upstream{aliases: map[string]string{
+		"nx.example.com.":    "bad.com.",
+		"local.example.com.": "local.com.",
+		"ok.example.com.":    "ok.bad.com.",
+	}}
+	h := NewHandler(NewEvaluator(testPolicyZone()), u)
+	serve := func(name string) *g53.Message {
+		n, _ := g53.NameFromString(name)
+		w := &recordWriter{transport: server.TRANSPORT_UDP}
+		h.ServeDNS(w, g53.MakeQuery(n, g53.RR_A, 4096, false))
+		return w.resp
+	}
+
+	//cname to the name of qname trigger is kept, the rest is rewritten
+	resp := serve("nx.example.com.")
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NXDOMAIN))
+	g53.Equal(t, len(resp.Sections[g53.AnswerSection]), 1)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].String(), "nx.example.com.\t300\tIN\tCNAME\tbad.com.\n")
+	g53.Equal(t, resp.Sections[g53.AuthSection][0].Type, g53.RRType(g53.RR_SOA))
+
+	resp = serve("local.example.com.")
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, len(resp.Sections[g53.AnswerSection]), 2)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][1].String(), "local.com.\t300\tIN\tA\t10.0.0.1\n")
+
+	resp = serve("ok.example.com.")
+	g53.Equal(t, resp.Sections[g53.AnswerSection][1].Rdatas[0].String(), "1.1.1.1")
+	g53.Equal(t, u.queries, 3)
+}
+
+func TestHandlerZoneOrder(t *testing.T) {
+	second := buildPolicyZone("rpz2.example.",
+		g53.BuildRRset("rpz2.example.", g53.RR_SOA, 300, "ns.rpz2.example. root.rpz2.example. 1 3600 900 60"),
+		g53.BuildRRset("www.victim.com.rpz2.example.", g53.RR_A, 300, "10.9.9.9"),
+		g53.BuildRRset("www.fine.com.rpz2.example.", g53.RR_A, 300, "10.9.9.9"),
+	)
+	serve := func(h *Handler, name string) *g53.Message {
+		n, _ := g53.NameFromString(name)
+		w := &recordWriter{transport: server.TRANSPORT_UDP}
+		h.ServeDNS(w, g53.MakeQuery(n, g53.RR_A, 4096, false))
+		return w.resp
+	}
+
+	//response ip trigger of the first zone wins over qname trigger of the
+	//second zone
+	u := &upstream{}
+	h := NewHandler(NewEvaluator(testPolicyZone(), second), u)
+	resp := serve(h, "www.victim.com.")
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NXDOMAIN))
+	resp = serve(h, "www.fine.com.")
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "10.9.9.9")
+	g53.Equal(t, u.queries, 2)
+
+	//qname trigger of the first zone is applied without resolution
+	u = &upstream{}
+	h = NewHandler(NewEvaluator(second, testPolicyZone()), u)
+	resp = serve(h, "www.victim.com.")
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].Rdatas[0].String(), "10.9.9.9")
+	g53.Equal(t, u.queries, 0)
+}
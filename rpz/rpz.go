@@ -0,0 +1,503 @@
+package rpz
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/mistletoeChao/g53"
+)
+
+type Trigger uint8
+
+const (
+	TRIGGER_QNAME       Trigger = 0
+	TRIGGER_RESPONSE_IP         = 1
+	TRIGGER_NSDNAME             = 2
+	TRIGGER_NSIP                = 3
+)
+
+var TriggerStr = map[Trigger]string{
+	TRIGGER_QNAME:       "QNAME",
+	TRIGGER_RESPONSE_IP: "Response-IP",
+	TRIGGER_NSDNAME:     "NSDNAME",
+	TRIGGER_NSIP:        "NSIP",
+}
+
+func (t Trigger) String() string {
+	return TriggerStr[t]
+}
+
+type Action uint8
+
+const (
+	ACTION_NXDOMAIN   Action = 0
+	ACTION_NODATA            = 1
+	ACTION_PASSTHRU          = 2
+	ACTION_DROP              = 3
+	ACTION_TCP_ONLY          = 4
+	ACTION_LOCAL_DATA        = 5
+)
+
+var ActionStr = map[Action]string{
+	ACTION_NXDOMAIN:   "NXDOMAIN",
+	ACTION_NODATA:     "NODATA",
+	ACTION_PASSTHRU:   "PASSTHRU",
+	ACTION_DROP:       "DROP",
+	ACTION_TCP_ONLY:   "TCP-only",
+	ACTION_LOCAL_DATA: "Local-Data",
+}
+
+func (a Action) String() string {
+	return ActionStr[a]
+}
+
+// labels of policy zone which mark the triggers other than qname
+const (
+	ipLabel      = "rpz-ip"
+	nsipLabel    = "rpz-nsip"
+	nsdnameLabel = "rpz-nsdname"
+	clientLabel  = "rpz-client-ip"
+)
+
+// cname targets which encode actions other than local data
+var actionTargets = map[string]Action{
+	".":             ACTION_NXDOMAIN,
+	"*.":            ACTION_NODATA,
+	"rpz-passthru.": ACTION_PASSTHRU,
+	"rpz-drop.":     ACTION_DROP,
+	"rpz-tcp-only.": ACTION_TCP_ONLY,
+}
+
+// Rule is the policy of one owner name in policy zone, Data is the local
+// data whose owner is replaced by qname when it's applied
+type Rule struct {
+	Zone    *g53.Name
+	Owner   *g53.Name
+	Trigger Trigger
+	Action  Action
+	Data    []*g53.RRset
+
+	soa *g53.RRset
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s %s %s", r.Owner.String(false), r.Trigger, r.Action)
+}
+
+type prefixRule struct {
+	prefix *net.IPNet
+	rule   *Rule
+}
+
+// names are keyed by lower case name, wildcard rules are keyed by the name
+// without leading "*."
+type nameRules struct {
+	exact     map[string]*Rule
+	wildcards map[string]*Rule
+}
+
+func newNameRules() *nameRules {
+	return &nameRules{
+		exact:     make(map[string]*Rule),
+		wildcards: make(map[string]*Rule),
+	}
+}
+
+func nameKey(name *g53.Name) string {
+	return strings.ToLower(name.String(false))
+}
+
+// exact match wins over wildcard, and the longest wildcard wins
+func (rules *nameRules) match(name *g53.Name) *Rule {
+	if rule, ok := rules.exact[nameKey(name)]; ok {
+		return rule
+	}
+
+	for i := uint(1); i < name.LabelCount(); i++ {
+		parent, err := name.StripLeft(i)
+		if err != nil {
+			return nil
+		}
+		if rule, ok := rules.wildcards[nameKey(parent)]; ok {
+			return rule
+		}
+	}
+	return nil
+}
+
+// the longest prefix wins
+func matchPrefix(rules []*prefixRule, ip net.IP) *Rule {
+	var best *prefixRule
+	for _, r := range rules {
+		if r.prefix.Contains(ip) {
+			if best == nil || prefixLen(r.prefix) > prefixLen(best.prefix) {
+				best = r
+			}
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.rule
+}
+
+func prefixLen(prefix *net.IPNet) int {
+	ones, _ := prefix.Mask.Size()
+	return ones
+}
+
+// PolicyZone is a response policy zone, owner names encode the triggers and
+// the cname targets or records of them are the actions
+type PolicyZone struct {
+	origin   *g53.Name
+	soa      *g53.RRset
+	qnames   *nameRules
+	nsdnames *nameRules
+	ips      []*prefixRule
+	nsips    []*prefixRule
+}
+
+// NewPolicyZone loads policy from rrsets of zone origin, rrsets at origin
+// and the ones of unsupported triggers are ignored
+func NewPolicyZone(origin *g53.Name, rrsets []*g53.RRset) (*PolicyZone, error) {
+	z := &PolicyZone{
+		origin:   origin,
+		qnames:   newNameRules(),
+		nsdnames: newNameRules(),
+	}
+
+	var owners []*g53.Name
+	grouped := make(map[string][]*g53.RRset)
+	for _, rrset := range rrsets {
+		if rrset.Name.Equals(origin) {
+			if rrset.Type == g53.RR_SOA {
+				z.soa = rrset
+			}
+			continue
+		}
+
+		if rrset.Name.Compare(origin, false).Relation != g53.SUBDOMAIN {
+			return nil, fmt.Errorf("%s is out of policy zone %s", rrset.Name.String(false), origin.String(false))
+		}
+
+		if rrset.Type == g53.RR_RRSIG || rrset.Type == g53.RR_NSEC {
+			continue
+		}
+
+		key := nameKey(rrset.Name)
+		if _, ok := grouped[key]; ok == false {
+			owners = append(owners, rrset.Name)
+		}
+		grouped[key] = append(grouped[key], rrset)
+	}
+
+	for _, owner := range owners {
+		if err := z.addRule(owner, grouped[nameKey(owner)]); err != nil {
+			return nil, err
+		}
+	}
+	return z, nil
+}
+
+func (z *PolicyZone) Origin() *g53.Name {
+	return z.origin
+}
+
+func makeRule(zone *g53.Name, owner *g53.Name, rrsets []*g53.RRset) *Rule {
+	rule := &Rule{
+		Zone:   zone,
+		Owner:  owner,
+		Action: ACTION_LOCAL_DATA,
+		Data:   rrsets,
+	}
+	for _, rrset := range rrsets {
+		if rrset.Type == g53.RR_CNAME && len(rrset.Rdatas) > 0 {
+			target := strings.ToLower(rrset.Rdatas[0].(*g53.CName).Name.String(false))
+			if action, ok := actionTargets[target]; ok {
+				rule.Action = action
+				rule.Data = nil
+			}
+		}
+	}
+	return rule
+}
+
+func (z *PolicyZone) addRule(owner *g53.Name, rrsets []*g53.RRset) error {
+	rule := makeRule(z.origin, owner, rrsets)
+	rule.soa = z.soa
+	labels := strings.Split(strings.TrimSuffix(nameKey(owner), "."+nameKey(z.origin)), ".")
+	switch labels[len(labels)-1] {
+	case ipLabel, nsipLabel:
+		prefix, err := parseIPName(labels[:len(labels)-1])
+		if err != nil {
+			return fmt.Errorf("invalid ip trigger %s: %v", owner.String(false), err)
+		}
+
+		if labels[len(labels)-1] == ipLabel {
+			rule.Trigger = TRIGGER_RESPONSE_IP
+			z.ips = append(z.ips, &prefixRule{prefix: prefix, rule: rule})
+		} else {
+			rule.Trigger = TRIGGER_NSIP
+			z.nsips = append(z.nsips, &prefixRule{prefix: prefix, rule: rule})
+		}
+	case nsdnameLabel:
+		rule.Trigger = TRIGGER_NSDNAME
+		return addNameRule(z.nsdnames, rule, labels[:len(labels)-1])
+	case clientLabel:
+	default:
+		rule.Trigger = TRIGGER_QNAME
+		return addNameRule(z.qnames, rule, labels)
+	}
+	return nil
+}
+
+func addNameRule(rules *nameRules, rule *Rule, labels []string) error {
+	if len(labels) == 0 {
+		return fmt.Errorf("empty %s trigger %s", rule.Trigger, rule.Owner.String(false))
+	}
+
+	wildcard := labels[0] == "*"
+	if wildcard {
+		labels = labels[1:]
+	}
+
+	name := g53.Root
+	if len(labels) > 0 {
+		n, err := g53.NameFromString(strings.Join(labels, ".") + ".")
+		if err != nil {
+			return err
+		}
+		name = n
+	}
+
+	if wildcard {
+		rules.wildcards[nameKey(name)] = rule
+	} else {
+		rules.exact[nameKey(name)] = rule
+	}
+	return nil
+}
+
+// parseIPName decodes the prefix encoded as prefix length followed by the
+// address in reverse order, ipv6 address uses "zz" for the "::". The family
+// is decided by the address labels rather than the prefix length
+func parseIPName(labels []string) (*net.IPNet, error) {
+	if len(labels) < 2 {
+		return nil, fmt.Errorf("too few labels")
+	}
+
+	prefix, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return nil, err
+	}
+
+	addr := make([]string, 0, len(labels)-1)
+	for i := len(labels) - 1; i > 0; i-- {
+		addr = append(addr, labels[i])
+	}
+
+	bits := 128
+	var ip net.IP
+	if isIPv4Labels(addr) {
+		bits = 32
+		ip = net.ParseIP(strings.Join(addr, ".")).To4()
+	} else {
+		s := strings.Join(addr, ":")
+		s = strings.Replace(s, "zz", "", 1)
+		if strings.HasPrefix(s, ":") {
+			s = ":" + s
+		}
+		if strings.HasSuffix(s, ":") {
+			s = s + ":"
+		}
+		ip = net.ParseIP(s)
+	}
+
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %s", strings.Join(addr, "."))
+	}
+	if prefix < 1 || prefix > bits {
+		return nil, fmt.Errorf("invalid prefix length %d", prefix)
+	}
+
+	mask := net.CIDRMask(prefix, bits)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
+}
+
+// address is ipv4 if it has 4 decimal labels no bigger than 255, other
+// labels like "zz" or hex ones mean ipv6
+func isIPv4Labels(addr []string) bool {
+	if len(addr) != 4 {
+		return false
+	}
+	for _, label := range addr {
+		v, err := strconv.Atoi(label)
+		if err != nil || v < 0 || v > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluator applies policy zones in order, rule of the first zone which
+// has a match wins. In one zone QNAME triggers take precedence over
+// Response-IP, NSDNAME and NSIP in that order
+type Evaluator struct {
+	zones []*PolicyZone
+}
+
+func NewEvaluator(zones ...*PolicyZone) *Evaluator {
+	return &Evaluator{zones: zones}
+}
+
+// Check returns the rule for the query of qname and resp which is its
+// response. resp is nil if the query hasn't been resolved, then true is
+// returned if a zone before the first qname match has triggers of response,
+// which means the query should be resolved and checked again with resp.
+// With resp, qname triggers apply to each name in the cname chain of qname
+// as well. The name matching the qname trigger is returned with the rule,
+// it's nil if the rule is triggered by resp
+func (e *Evaluator) Check(qname *g53.Name, resp *g53.Message) (*Rule, *g53.Name, bool) {
+	names := []*g53.Name{qname}
+	var r *response
+	if resp != nil {
+		r = newResponse(resp)
+		for _, cname := range aliasChain(qname, resp.Sections[g53.AnswerSection]) {
+			names = append(names, cname.Rdatas[0].(*g53.CName).Name)
+		}
+	}
+
+	for _, z := range e.zones {
+		for _, name := range names {
+			if rule := z.qnames.match(name); rule != nil {
+				return rule, name, false
+			}
+		}
+
+		if z.hasResponseTriggers() {
+			if r == nil {
+				return nil, nil, true
+			}
+			if rule := z.checkResponse(r); rule != nil {
+				return rule, nil, false
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// aliasChain returns the cnames in answer starting from qname in order,
+// loop in the chain is cut
+func aliasChain(qname *g53.Name, answer g53.Section) []*g53.RRset {
+	var chain []*g53.RRset
+	name := qname
+	for len(chain) < len(answer) {
+		var cname *g53.RRset
+		for _, rrset := range answer {
+			if rrset.Type == g53.RR_CNAME && len(rrset.Rdatas) > 0 && rrset.Name.Equals(name) {
+				cname = rrset
+				break
+			}
+		}
+		if cname == nil {
+			break
+		}
+		chain = append(chain, cname)
+		name = cname.Rdatas[0].(*g53.CName).Name
+	}
+	return chain
+}
+
+// CheckQName returns the rule of qname trigger for qname, nil if there is
+// no match
+func (e *Evaluator) CheckQName(qname *g53.Name) *Rule {
+	for _, z := range e.zones {
+		if rule := z.qnames.match(qname); rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+// CheckResponse returns the rule for the addresses in answer section of
+// resp and the name servers in authority section, the addresses of name
+// servers are their glue in additional section
+func (e *Evaluator) CheckResponse(resp *g53.Message) *Rule {
+	r := newResponse(resp)
+	for _, z := range e.zones {
+		if rule := z.checkResponse(r); rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+// response holds the data of response which triggers are checked against
+type response struct {
+	ips     []net.IP
+	nsNames []*g53.Name
+	nsIPs   []net.IP
+}
+
+func newResponse(resp *g53.Message) *response {
+	r := &response{}
+	for _, rrset := range resp.Sections[g53.AnswerSection] {
+		r.ips = append(r.ips, addresses(rrset)...)
+	}
+
+	for _, rrset := range resp.Sections[g53.AuthSection] {
+		if rrset.Type == g53.RR_NS {
+			for _, rdata := range rrset.Rdatas {
+				r.nsNames = append(r.nsNames, rdata.(*g53.NS).Name)
+			}
+		}
+	}
+
+	for _, rrset := range resp.Sections[g53.AdditionalSection] {
+		for _, ns := range r.nsNames {
+			if rrset.Name.Equals(ns) {
+				r.nsIPs = append(r.nsIPs, addresses(rrset)...)
+			}
+		}
+	}
+	return r
+}
+
+func (z *PolicyZone) hasResponseTriggers() bool {
+	return len(z.ips) > 0 || len(z.nsips) > 0 ||
+		len(z.nsdnames.exact) > 0 || len(z.nsdnames.wildcards) > 0
+}
+
+func (z *PolicyZone) checkResponse(r *response) *Rule {
+	for _, ip := range r.ips {
+		if rule := matchPrefix(z.ips, ip); rule != nil {
+			return rule
+		}
+	}
+	for _, ns := range r.nsNames {
+		if rule := z.nsdnames.match(ns); rule != nil {
+			return rule
+		}
+	}
+	for _, ip := range r.nsIPs {
+		if rule := matchPrefix(z.nsips, ip); rule != nil {
+			return rule
+		}
+	}
+	return nil
+}
+
+func addresses(rrset *g53.RRset) []net.IP {
+	var ips []net.IP
+	for _, rdata := range rrset.Rdatas {
+		switch r := rdata.(type) {
+		case *g53.A:
+			ips = append(ips, r.Host)
+		case *g53.AAAA:
+			ips = append(ips, r.Host)
+		}
+	}
+	return ips
+}
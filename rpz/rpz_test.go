@@ -0,0 +1,162 @@
+package rpz
+
+import (
+	"testing"
+
+	"github.com/mistletoeChao/g53"
+)
+
+func buildPolicyZone(origin string, rrsets ...*g53.RRset) *PolicyZone {
+	n, _ := g53.NameFromString(origin)
+	z, err := NewPolicyZone(n, rrsets)
+	if err != nil {
+		panic(err.Error())
+	}
+	return z
+}
+
+func testPolicyZone() *PolicyZone {
+	return buildPolicyZone("rpz.example.",
+		g53.BuildRRset("rpz.example.", g53.RR_SOA, 300, "ns.rpz.example. root.rpz.example. 1 3600 900 60"),
+		g53.BuildRRset("rpz.example.", g53.RR_NS, 300, "ns.rpz.example."),
+		g53.BuildRRset("bad.com.rpz.example.", g53.RR_CNAME, 300, "."),
+		g53.BuildRRset("*.bad.com.rpz.example.", g53.RR_CNAME, 300, "*."),
+		g53.BuildRRset("ok.bad.com.rpz.example.", g53.RR_CNAME, 300, "rpz-passthru."),
+		g53.BuildRRset("drop.com.rpz.example.", g53.RR_CNAME, 300, "rpz-drop."),
+		g53.BuildRRset("tcp.com.rpz.example.", g53.RR_CNAME, 300, "rpz-tcp-only."),
+		g53.BuildRRset("local.com.rpz.example.", g53.RR_A, 300, "10.0.0.1"),
+		g53.BuildRRset("local.com.rpz.example.", g53.RR_TXT, 300, "blocked"),
+		g53.BuildRRset("*.local.com.rpz.example.", g53.RR_CNAME, 300, "walled.example.net."),
+		g53.BuildRRset("24.0.2.0.192.rpz-ip.rpz.example.", g53.RR_CNAME, 300, "."),
+		g53.BuildRRset("32.1.2.0.192.rpz-ip.rpz.example.", g53.RR_CNAME, 300, "rpz-passthru."),
+		g53.BuildRRset("48.zz.db8.2001.rpz-ip.rpz.example.", g53.RR_CNAME, 300, "."),
+		g53.BuildRRset("ns.evil.net.rpz-nsdname.rpz.example.", g53.RR_CNAME, 300, "."),
+		g53.BuildRRset("*.evil.org.rpz-nsdname.rpz.example.", g53.RR_CNAME, 300, "*."),
+		g53.BuildRRset("32.53.53.0.10.rpz-nsip.rpz.example.", g53.RR_CNAME, 300, "rpz-drop."),
+	)
+}
+
+func checkQName(e *Evaluator, name string) string {
+	n, _ := g53.NameFromString(name)
+	if rule := e.CheckQName(n); rule != nil {
+		return rule.Action.String()
+	}
+	return ""
+}
+
+func TestCheckQName(t *testing.T) {
+	e := NewEvaluator(testPolicyZone())
+	g53.Equal(t, checkQName(e, "bad.com."), "NXDOMAIN")
+	g53.Equal(t, checkQName(e, "www.BAD.com."), "NODATA")
+	g53.Equal(t, checkQName(e, "a.b.bad.com."), "NODATA")
+	g53.Equal(t, checkQName(e, "ok.bad.com."), "PASSTHRU")
+	g53.Equal(t, checkQName(e, "drop.com."), "DROP")
+	g53.Equal(t, checkQName(e, "tcp.com."), "TCP-only")
+	g53.Equal(t, checkQName(e, "local.com."), "Local-Data")
+	g53.Equal(t, checkQName(e, "www.local.com."), "Local-Data")
+	g53.Equal(t, checkQName(e, "www.drop.com."), "")
+	g53.Equal(t, checkQName(e, "other.com."), "")
+
+	n, _ := g53.NameFromString("local.com.")
+	rule := e.CheckQName(n)
+	g53.Equal(t, rule.Trigger, TRIGGER_QNAME)
+	g53.Equal(t, len(rule.Data), 2)
+	g53.Equal(t, rule.String(), "local.com.rpz.example. QNAME Local-Data")
+
+	//first zone wins
+	allow := buildPolicyZone("allow.example.",
+		g53.BuildRRset("bad.com.allow.example.", g53.RR_CNAME, 300, "rpz-passthru."))
+	e = NewEvaluator(allow, testPolicyZone())
+	g53.Equal(t, checkQName(e, "bad.com."), "PASSTHRU")
+	g53.Equal(t, checkQName(e, "www.bad.com."), "NODATA")
+}
+
+func checkResponse(e *Evaluator, answer []string, ns string, glue string) string {
+	n, _ := g53.NameFromString("www.example.com.")
+	resp := g53.MakeQuery(n, g53.RR_A, 512, false).MakeResponse()
+	for _, addr := range answer {
+		typ := g53.RR_A
+		if len(addr) > 15 || addr[0] == '2' && addr[4] == ':' {
+			typ = g53.RRType(g53.RR_AAAA)
+		}
+		resp.AddRRset(g53.AnswerSection, g53.BuildRRset("www.example.com.", typ, 300, addr))
+	}
+	if ns != "" {
+		resp.AddRRset(g53.AuthSection, g53.BuildRRset("example.com.", g53.RR_NS, 300, ns))
+		if glue != "" {
+			resp.AddRRset(g53.AdditionalSection, g53.BuildRRset(ns, g53.RR_A, 300, glue))
+		}
+	}
+
+	if rule := e.CheckResponse(resp); rule != nil {
+		return rule.Trigger.String() + " " + rule.Action.String()
+	}
+	return ""
+}
+
+func TestCheckResponse(t *testing.T) {
+	e := NewEvaluator(testPolicyZone())
+	g53.Equal(t, checkResponse(e, []string{"192.0.2.5"}, "", ""), "Response-IP NXDOMAIN")
+	//longest prefix wins
+	g53.Equal(t, checkResponse(e, []string{"192.0.2.1"}, "", ""), "Response-IP PASSTHRU")
+	g53.Equal(t, checkResponse(e, []string{"2001:db8:0:1::5"}, "", ""), "Response-IP NXDOMAIN")
+	g53.Equal(t, checkResponse(e, []string{"2001:db9::5"}, "", ""), "")
+	g53.Equal(t, checkResponse(e, []string{"1.1.1.1"}, "ns.evil.net.", ""), "NSDNAME NXDOMAIN")
+	g53.Equal(t, checkResponse(e, []string{"1.1.1.1"}, "ns1.evil.org.", ""), "NSDNAME NODATA")
+	g53.Equal(t, checkResponse(e, []string{"1.1.1.1"}, "ns.good.net.", "10.0.53.53"), "NSIP DROP")
+	g53.Equal(t, checkResponse(e, []string{"1.1.1.1"}, "ns.good.net.", "10.0.53.54"), "")
+	//response ip takes precedence over name servers
+	g53.Equal(t, checkResponse(e, []string{"192.0.2.5"}, "ns.good.net.", "10.0.53.53"), "Response-IP NXDOMAIN")
+}
+
+func TestParseIPName(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		prefix string
+	}{
+		{"24.0.2.0.192", "192.0.2.0/24"},
+		{"32.1.2.0.192", "192.0.2.1/32"},
+		{"8.0.0.0.10", "10.0.0.0/8"},
+		{"128.1.zz.db8.2001", "2001:db8::1/128"},
+		{"128.1.zz", "::1/128"},
+		{"64.zz.1.db8.2001", "2001:db8:1::/64"},
+		{"48.1.2.3.4.5.6.7.8", "8:7:6::/48"},
+		{"32.zz.1.db8.2001", "2001:db8::/32"},
+		{"16.zz.1.db8.2001", "2001::/16"},
+	} {
+		prefix, err := parseIPName(splitLabels(c.name))
+		g53.Assert(t, err == nil, "parse %s failed:%v", c.name, err)
+		g53.Equal(t, prefix.String(), c.prefix)
+	}
+
+	for _, name := range []string{"24", "33.1.2.3.4", "0.1.2.3.4", "24.1.2.3.256", "x.1.2.3.4"} {
+		_, err := parseIPName(splitLabels(name))
+		g53.Assert(t, err != nil, "%s should be invalid", name)
+	}
+
+	origin, _ := g53.NameFromString("rpz.example.")
+	_, err := NewPolicyZone(origin, []*g53.RRset{
+		g53.BuildRRset("33.1.2.3.4.rpz-ip.rpz.example.", g53.RR_CNAME, 300, "."),
+	})
+	g53.Assert(t, err != nil, "invalid ip trigger should fail")
+	_, err = NewPolicyZone(origin, []*g53.RRset{
+		g53.BuildRRset("32.zz.1.db8.2001.rpz-ip.rpz.example.", g53.RR_CNAME, 300, "."),
+	})
+	g53.Assert(t, err == nil, "ipv6 trigger with short prefix should load:%v", err)
+	_, err = NewPolicyZone(origin, []*g53.RRset{
+		g53.BuildRRset("bad.com.", g53.RR_CNAME, 300, "."),
+	})
+	g53.Assert(t, err != nil, "out of zone name should fail")
+}
+
+func splitLabels(name string) []string {
+	var labels []string
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i == len(name) || name[i] == '.' {
+			labels = append(labels, name[start:i])
+			start = i + 1
+		}
+	}
+	return labels
+}
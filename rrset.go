@@ -366,6 +366,8 @@ func (t RRType) String() string {
 	}
 }
 
+var ErrEmptyRdata = errors.New("rr has no rdata outside update message")
+
 type RRset struct {
 	Name   *Name
 	Type   RRType
@@ -375,6 +377,12 @@ type RRset struct {
 }
 
 func RRsetFromWire(buffer *util.InputBuffer) (*RRset, error) {
+	return rrsetFromWire(buffer, false)
+}
+
+// allowEmpty is only set for prerequisite and update section of update
+// message, everywhere else an rr without rdata is malformed
+func rrsetFromWire(buffer *util.InputBuffer, allowEmpty bool) (*RRset, error) {
 	n, err := NameFromWire(buffer, true)
 	if err != nil {
 		return nil, err
@@ -395,6 +403,27 @@ func RRsetFromWire(buffer *util.InputBuffer) (*RRset, error) {
 		return nil, err
 	}
 
+	if t != RR_OPT && isEmptyRdataClass(cls) {
+		pos := buffer.Position()
+		rdlen, err := buffer.ReadUint16()
+		if err != nil {
+			return nil, err
+		}
+
+		if rdlen == 0 {
+			if allowEmpty == false {
+				return nil, ErrEmptyRdata
+			}
+			return &RRset{
+				Name:  n,
+				Type:  t,
+				Class: cls,
+				Ttl:   ttl,
+			}, nil
+		}
+		buffer.SetPosition(pos)
+	}
+
 	rdata, err := RdataFromWire(t, buffer)
 	if err != nil {
 		return nil, err
@@ -409,7 +438,25 @@ func RRsetFromWire(buffer *util.InputBuffer) (*RRset, error) {
 	}, nil
 }
 
+// rr in update message with class ANY or NONE may have no rdata
+func isEmptyRdataClass(cls RRClass) bool {
+	return cls == CLASS_ANY || cls == CLASS_NONE
+}
+
+func (rrset *RRset) IsEmptyRdata() bool {
+	return len(rrset.Rdatas) == 0 && isEmptyRdataClass(rrset.Class)
+}
+
 func (rrset *RRset) Rend(r *MsgRender) {
+	if rrset.IsEmptyRdata() {
+		rrset.Name.Rend(r)
+		rrset.Type.Rend(r)
+		rrset.Class.Rend(r)
+		rrset.Ttl.Rend(r)
+		r.WriteUint16(0)
+		return
+	}
+
 	for _, rdata := range rrset.Rdatas {
 		rrset.Name.Rend(r)
 		rrset.Type.Rend(r)
@@ -423,6 +470,15 @@ func (rrset *RRset) Rend(r *MsgRender) {
 }
 
 func (rrset *RRset) ToWire(buffer *util.OutputBuffer) {
+	if rrset.IsEmptyRdata() {
+		rrset.Name.ToWire(buffer)
+		rrset.Type.ToWire(buffer)
+		rrset.Class.ToWire(buffer)
+		rrset.Ttl.ToWire(buffer)
+		buffer.WriteUint16(0)
+		return
+	}
+
 	for _, rdata := range rrset.Rdatas {
 		rrset.Name.ToWire(buffer)
 		rrset.Type.ToWire(buffer)
@@ -438,6 +494,10 @@ func (rrset *RRset) ToWire(buffer *util.OutputBuffer) {
 
 func (rrset *RRset) String() string {
 	header := strings.Join([]string{rrset.Name.String(false), rrset.Ttl.String(), rrset.Class.String(), rrset.Type.String()}, "\t")
+	if rrset.IsEmptyRdata() {
+		return header + "\n"
+	}
+
 	var buf bytes.Buffer
 	for _, rdata := range rrset.Rdatas {
 		buf.WriteString(header)
@@ -449,6 +509,9 @@ func (rrset *RRset) String() string {
 }
 
 func (rrset *RRset) RrCount() int {
+	if rrset.IsEmptyRdata() {
+		return 1
+	}
 	return len(rrset.Rdatas)
 }
 
@@ -2,7 +2,7 @@ package g53
 
 import (
 	"fmt"
-	"g53/util"
+	"github.com/mistletoeChao/g53/util"
 	"testing"
 )
 
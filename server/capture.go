@@ -0,0 +1,63 @@
+package server
+
+import (
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+// CaptureWriter holds the response written by handler instead of sending it
+// to client, data written by WriteData is parsed into Response as well. Flush
+// sends the response in the way it was written
+type CaptureWriter struct {
+	ResponseWriter
+	Response *g53.Message
+	Data     []byte
+}
+
+func NewCaptureWriter(w ResponseWriter) *CaptureWriter {
+	return &CaptureWriter{ResponseWriter: w}
+}
+
+func (w *CaptureWriter) Write(resp *g53.Message) error {
+	w.Response = resp
+	w.Data = nil
+	return nil
+}
+
+func (w *CaptureWriter) WriteData(data []byte) error {
+	resp, err := g53.MessageFromWire(util.NewInputBuffer(data))
+	if err != nil {
+		return err
+	}
+	w.Response = resp
+	w.Data = data
+	return nil
+}
+
+func (w *CaptureWriter) Flush() error {
+	switch {
+	case w.Data != nil:
+		return w.ResponseWriter.WriteData(w.Data)
+	case w.Response != nil:
+		return w.ResponseWriter.Write(w.Response)
+	default:
+		return nil
+	}
+}
+
+// CopyQuery returns a copy of query whose header and edns options can be
+// changed without affecting query
+func CopyQuery(query *g53.Message) *g53.Message {
+	header := *query.Header
+	q := &g53.Message{
+		Header:   &header,
+		Question: query.Question,
+		Sections: query.Sections,
+	}
+	if query.Edns != nil {
+		edns := *query.Edns
+		edns.Options = append([]g53.Option{}, query.Edns.Options...)
+		q.Edns = &edns
+	}
+	return q
+}
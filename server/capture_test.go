@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mistletoeChao/g53"
+)
+
+func TestCaptureWriter(t *testing.T) {
+	req := makeRequest("www.example.com.", g53.OP_QUERY)
+	resp := req.MakeResponse()
+	w := &recordWriter{}
+	cw := NewCaptureWriter(w)
+	cw.Write(resp)
+	g53.Assert(t, w.resp == nil, "response shouldn't be sent before flush")
+	cw.Flush()
+	g53.Assert(t, w.resp == resp, "captured response should be sent")
+
+	render := g53.NewMsgRender()
+	resp.Rend(render)
+	cw = NewCaptureWriter(w)
+	g53.Assert(t, cw.WriteData(render.Data()) == nil, "valid data should be parsed")
+	g53.Equal(t, cw.Response.Header.Id, resp.Header.Id)
+	g53.Assert(t, cw.WriteData([]byte{1, 2}) != nil, "invalid data should fail")
+}
+
+func TestCopyQuery(t *testing.T) {
+	req := makeRequest("www.example.com.", g53.OP_QUERY)
+	req.Edns = &g53.EDNS{UdpSize: 4096}
+	q := CopyQuery(req)
+	q.Header.Id = req.Header.Id + 1
+	q.Edns.Options = append(q.Edns.Options, g53.NewSubnetOpt(net.ParseIP("1.2.3.4"), 24))
+	g53.Nequal(t, q.Header.Id, req.Header.Id)
+	g53.Equal(t, len(req.Edns.Options), 0)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+	"github.com/mistletoeChao/g53/util"
+)
+
+const DoHPath = "/dns-query"
+
+var errNoResponse = errors.New("handler doesn't write response")
+
+// DoHHandler serves dns over https as rfc8484, query is either the base64url
+// encoded dns parameter of GET or the body of POST, and it's handed to
+// Handler. The max-age of Cache-Control is the min ttl of the response
+type DoHHandler struct {
+	Handler Handler
+}
+
+func (h *DoHHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var data []byte
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			http.Error(w, "missing dns parameter", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if data, err = base64.RawURLEncoding.DecodeString(param); err != nil {
+			http.Error(w, "invalid dns parameter", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != client.DoHMediaType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		var err error
+		if data, err = ioutil.ReadAll(io.LimitReader(r.Body, client.MaxTCPMessageLen+1)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(data) > client.MaxTCPMessageLen {
+			http.Error(w, "query is too long", http.StatusRequestEntityTooLarge)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := g53.MessageFromWire(util.NewInputBuffer(data))
+	if err != nil || req.Header.GetFlag(g53.FLAG_QR) {
+		http.Error(w, "invalid dns message", http.StatusBadRequest)
+		return
+	}
+
+	hw := &httpWriter{r: r, req: req, reqData: data}
+	h.Handler.ServeDNS(hw, req)
+	if hw.data == nil {
+		http.Error(w, errNoResponse.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", client.DoHMediaType)
+	resp := hw.resp
+	if resp == nil {
+		resp, _ = g53.MessageFromWire(util.NewInputBuffer(hw.data))
+	}
+	if resp != nil {
+		if ttl, ok := MinTTL(resp); ok {
+			w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", ttl))
+		}
+	}
+	w.Write(hw.data)
+}
+
+// MinTTL is the min ttl of all the rrsets in msg, for negative response it's
+// bounded by the minimum field of soa as rfc2308, false is returned if msg
+// has no rrset
+func MinTTL(msg *g53.Message) (uint32, bool) {
+	var min uint32
+	found := false
+	for _, section := range msg.Sections {
+		for _, rrset := range section {
+			ttl := uint32(rrset.Ttl)
+			if rrset.Type == g53.RR_SOA && len(rrset.Rdatas) > 0 {
+				if soa, ok := rrset.Rdatas[0].(*g53.SOA); ok && soa.Minimum < ttl {
+					ttl = soa.Minimum
+				}
+			}
+			if found == false || ttl < min {
+				min = ttl
+				found = true
+			}
+		}
+	}
+	return min, found
+}
+
+// httpWriter keeps the response which is sent back after handler returns
+type httpWriter struct {
+	r       *http.Request
+	req     *g53.Message
+	reqData []byte
+	resp    *g53.Message
+	data    []byte
+}
+
+func (w *httpWriter) LocalAddr() net.Addr {
+	addr, _ := w.r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return addr
+}
+
+func (w *httpWriter) RemoteAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", w.r.RemoteAddr)
+	return addr
+}
+
+func (w *httpWriter) Transport() Transport {
+	return TRANSPORT_HTTPS
+}
+
+func (w *httpWriter) requestData() []byte {
+	return w.reqData
+}
+
+func (w *httpWriter) Write(resp *g53.Message) error {
+	if w.req.Edns != nil && w.req.Edns.PaddingOpt() != nil && resp.Edns != nil {
+		g53.PadMessage(resp, g53.RESPONSE_PADDING_BLOCK)
+	}
+	w.resp = resp
+	w.data = Truncate(resp, client.MaxTCPMessageLen)
+	return nil
+}
+
+func (w *httpWriter) WriteData(data []byte) error {
+	w.resp = nil
+	w.data = data
+	return nil
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+)
+
+func TestDoH(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	soa := &g53.SOA{MName: zone, RName: zone, Serial: 2020, Refresh: 3600, Retry: 900, Expire: 86400, Minimum: 60}
+	var transport Transport
+	handler := &DoHHandler{
+		Handler: HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+			transport = w.Transport()
+			resp := answer(req, 2)
+			if req.Question.Type == g53.RR_A {
+				resp.Sections[g53.AnswerSection][0].Ttl = 300
+			} else {
+				resp = req.MakeResponse()
+				resp.Header.Rcode = g53.R_NXDOMAIN
+				resp.AddRRset(g53.AuthSection, &g53.RRset{
+					Name:   zone,
+					Type:   g53.RR_SOA,
+					Class:  g53.CLASS_IN,
+					Ttl:    3600,
+					Rdatas: []g53.Rdata{soa},
+				})
+			}
+			w.Write(resp)
+		}),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(DoHPath, handler)
+	s := httptest.NewTLSServer(mux)
+	defer s.Close()
+	url := s.URL + DoHPath
+
+	name, _ := g53.NameFromString("www.example.com.")
+	for _, get := range []bool{false, true} {
+		c := client.NewDoHClient(s.Client())
+		c.UseGET = get
+		resp, _, err := c.Exchange(g53.MakeQuery(name, g53.RR_A, 4096, false), url)
+		g53.Assert(t, err == nil, "doh query failed:%v", err)
+		g53.Equal(t, resp.Header.Id, uint16(0))
+		g53.Equal(t, resp.Sections[g53.AnswerSection][0].RrCount(), 2)
+		g53.Equal(t, transport, Transport(TRANSPORT_HTTPS))
+	}
+
+	query := func(typ g53.RRType) *http.Response {
+		r := g53.NewMsgRender()
+		g53.MakeQuery(name, typ, 4096, false).Rend(r)
+		resp, err := s.Client().Post(url, client.DoHMediaType, bytes.NewReader(r.Data()))
+		g53.Assert(t, err == nil, "post failed:%v", err)
+		resp.Body.Close()
+		return resp
+	}
+	resp := query(g53.RR_A)
+	g53.Equal(t, resp.Header.Get("Cache-Control"), "max-age=300")
+	g53.Equal(t, resp.Header.Get("Content-Type"), client.DoHMediaType)
+	//negative response is cached by the soa minimum
+	resp = query(g53.RRType(g53.RR_AAAA))
+	g53.Equal(t, resp.Header.Get("Cache-Control"), "max-age=60")
+}
+
+func TestDoHBadRequest(t *testing.T) {
+	handler := &DoHHandler{
+		Handler: HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+			w.Write(answer(req, 1))
+		}),
+	}
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	resp, _ := s.Client().Get(s.URL)
+	g53.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	resp, _ = s.Client().Get(s.URL + "?dns=!!!")
+	g53.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	resp, _ = s.Client().Get(s.URL + "?dns=AAAA")
+	g53.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	resp, _ = s.Client().Post(s.URL, "text/plain", bytes.NewReader([]byte("hello")))
+	g53.Equal(t, resp.StatusCode, http.StatusUnsupportedMediaType)
+	req, _ := http.NewRequest(http.MethodPut, s.URL, nil)
+	resp, _ = s.Client().Do(req)
+	g53.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed)
+
+	//url with query string
+	c := client.NewDoHClient(s.Client())
+	c.UseGET = true
+	name, _ := g53.NameFromString("www.example.com.")
+	_, _, err := c.Exchange(g53.MakeQuery(name, g53.RR_A, 4096, false), s.URL+"/dns-query?ct=1")
+	g53.Assert(t, err == nil, "doh query failed:%v", err)
+}
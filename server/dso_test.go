@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+)
+
+func TestDSOSession(t *testing.T) {
+	pushed := make(chan ResponseWriter, 1)
+	s := &Server{
+		TCPIdleTimeout:       time.Minute,
+		DSOKeepaliveInterval: 20 * time.Second,
+		DSOTypes:             []g53.DSOType{0x40},
+		Handler: HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+			if req.Header.Opcode == g53.OP_DSO {
+				//subscription is acknowledged, notification is pushed later
+				w.Write(req.MakeResponse())
+				pushed <- w
+				return
+			}
+			resp := answer(req, 1)
+			resp.Edns = &g53.EDNS{UdpSize: 4096}
+			w.Write(resp)
+		}),
+	}
+	addr, _ := start(t, s)
+	defer s.Shutdown(context.Background())
+
+	conn, err := client.Dial(addr, time.Second)
+	g53.Assert(t, err == nil, "dial failed:%v", err)
+	defer conn.Close()
+
+	ka, err := conn.EstablishDSO(0, 0, time.Second)
+	g53.Assert(t, err == nil, "establish dso failed:%v", err)
+	g53.Equal(t, ka.Inactivity(), time.Minute)
+	g53.Equal(t, ka.Interval(), 20*time.Second)
+	g53.Assert(t, conn.DSOEstablished(), "dso session should be established")
+	_, err = conn.EstablishDSO(0, 0, time.Second)
+	g53.Equal(t, err, client.ErrDSOEstablish)
+
+	//edns-tcp-keepalive isn't used in dso session
+	name, _ := g53.NameFromString("www.example.com.")
+	query := g53.MakeQuery(name, g53.RR_A, 4096, false)
+	resp, _, err := conn.Exchange(query, time.Second)
+	g53.Assert(t, err == nil, "query failed:%v", err)
+	g53.Assert(t, query.Edns.KeepaliveOpt() == nil, "query shouldn't have keepalive option")
+	g53.Assert(t, resp.Edns.KeepaliveOpt() == nil, "response shouldn't have keepalive option")
+
+	notifications := make(chan *g53.Message, 1)
+	conn.HandleDSO(func(msg *g53.Message) { notifications <- msg })
+	subscribe := &g53.UnknownTLV{Typ: 0x40, Data: []byte{1}}
+	resp, _, err = conn.Exchange(g53.MakeDSO(subscribe), time.Second)
+	g53.Assert(t, err == nil, "subscribe failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_NOERROR))
+
+	//type not implemented isn't passed to handler
+	resp, _, err = conn.Exchange(g53.MakeDSO(&g53.UnknownTLV{Typ: 0x42, Data: []byte{1}}), time.Second)
+	g53.Assert(t, err == nil, "dso request failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_DSOTYPENI))
+
+	w := <-pushed
+	push := g53.MakeDSO(&g53.UnknownTLV{Typ: 0x41, Data: []byte{2}})
+	w.Write(push)
+	select {
+	case msg := <-notifications:
+		g53.Equal(t, msg.DSOPrimary().Type(), g53.DSOType(0x41))
+	case <-time.After(time.Second):
+		t.Fatalf("push notification isn't received")
+	}
+
+	w.Write(g53.MakeDSO(&g53.RetryDelayTLV{Delay: 5000}))
+	for i := 0; i < 100 && conn.Err() == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	g53.Equal(t, conn.Err(), client.ErrRetryDelay)
+	g53.Equal(t, conn.RetryDelay(), 5*time.Second)
+}
+
+func TestDSOInvalid(t *testing.T) {
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+			w.Write(req.MakeResponse())
+		}),
+	}
+	addr, _ := start(t, s)
+	defer s.Shutdown(context.Background())
+
+	conn, err := client.Dial(addr, time.Second)
+	g53.Assert(t, err == nil, "dial failed:%v", err)
+	defer conn.Close()
+	resp, _, err := conn.Exchange(g53.MakeDSO(&g53.PaddingTLV{Length: 4}), time.Second)
+	g53.Assert(t, err == nil, "query failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_FORMERR))
+
+	//unknown primary tlv doesn't establish the session
+	resp, _, err = conn.Exchange(g53.MakeDSO(&g53.UnknownTLV{Typ: 0x40, Data: []byte{1}}), time.Second)
+	g53.Assert(t, err == nil, "dso request failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_DSOTYPENI))
+	g53.Assert(t, conn.DSOEstablished() == false, "dso session shouldn't be established")
+
+	//dso over udp is dropped
+	udp, _ := net.Dial("udp", addr)
+	defer udp.Close()
+	r := g53.NewMsgRender()
+	msg := g53.MakeDSO(g53.NewKeepaliveTLV(0, 0))
+	msg.Header.Id = 1
+	msg.Rend(r)
+	udp.Write(r.Data())
+	udp.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err = udp.Read(make([]byte, 512))
+	g53.Assert(t, err != nil, "dso over udp shouldn't be answered")
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mistletoeChao/g53"
+)
+
+// opcode of handlers which serve all the opcodes
+const anyOpcode = -1
+
+// ServeMux routes request to the handler of the longest zone which the
+// question name belongs to, handler registered for the opcode of request
+// wins over the one for all opcodes of the same zone. Request without
+// matched handler goes to the default handler, or gets REFUSED if there is
+// no default handler
+type ServeMux struct {
+	lock           sync.RWMutex
+	handlers       map[int]map[string]Handler
+	defaultHandler Handler
+}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{
+		handlers: make(map[int]map[string]Handler),
+	}
+}
+
+func zoneKey(zone *g53.Name) string {
+	return strings.ToLower(zone.String(false))
+}
+
+func (m *ServeMux) handle(opcode int, zone *g53.Name, h Handler) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	handlers, ok := m.handlers[opcode]
+	if ok == false {
+		handlers = make(map[string]Handler)
+		m.handlers[opcode] = handlers
+	}
+	handlers[zoneKey(zone)] = h
+}
+
+// Handle registers h for all the opcodes of zone
+func (m *ServeMux) Handle(zone *g53.Name, h Handler) {
+	m.handle(anyOpcode, zone, h)
+}
+
+func (m *ServeMux) HandleOpcode(opcode g53.Opcode, zone *g53.Name, h Handler) {
+	m.handle(int(opcode), zone, h)
+}
+
+func (m *ServeMux) HandleDefault(h Handler) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.defaultHandler = h
+}
+
+// Remove unregisters the handler of zone for all the opcodes
+func (m *ServeMux) Remove(zone *g53.Name) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := zoneKey(zone)
+	for _, handlers := range m.handlers {
+		delete(handlers, key)
+	}
+}
+
+// Handler returns the handler for req, nil if nothing matches
+func (m *ServeMux) Handler(req *g53.Message) Handler {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if req.Question == nil {
+		return m.defaultHandler
+	}
+
+	name := req.Question.Name
+	for i := uint(0); i < name.LabelCount(); i++ {
+		zone, _ := name.StripLeft(i)
+		key := zoneKey(zone)
+		if h, ok := m.handlers[int(req.Header.Opcode)][key]; ok {
+			return h
+		}
+		if h, ok := m.handlers[anyOpcode][key]; ok {
+			return h
+		}
+	}
+	return m.defaultHandler
+}
+
+func (m *ServeMux) ServeDNS(w ResponseWriter, req *g53.Message) {
+	if h := m.Handler(req); h != nil {
+		h.ServeDNS(w, req)
+		return
+	}
+
+	resp := req.MakeResponse()
+	resp.Header.Rcode = g53.R_REFUSED
+	w.Write(resp)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+type recordWriter struct {
+	resp *g53.Message
+}
+
+func (w *recordWriter) LocalAddr() net.Addr           { return nil }
+func (w *recordWriter) RemoteAddr() net.Addr          { return nil }
+func (w *recordWriter) Transport() Transport          { return TRANSPORT_UDP }
+func (w *recordWriter) Write(resp *g53.Message) error { w.resp = resp; return nil }
+
+func (w *recordWriter) WriteData(data []byte) error {
+	resp, err := g53.MessageFromWire(util.NewInputBuffer(data))
+	w.resp = resp
+	return err
+}
+
+func tagHandler(tag string, tags *[]string) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+		*tags = append(*tags, tag)
+	})
+}
+
+func makeRequest(name string, opcode g53.Opcode) *g53.Message {
+	n, _ := g53.NameFromString(name)
+	req := g53.MakeQuery(n, g53.RR_A, 512, false)
+	req.Header.Opcode = opcode
+	return req
+}
+
+func TestServeMux(t *testing.T) {
+	var tags []string
+	mux := NewServeMux()
+	com, _ := g53.NameFromString("com.")
+	example, _ := g53.NameFromString("Example.COM.")
+	mux.Handle(com, tagHandler("com", &tags))
+	mux.Handle(example, tagHandler("example", &tags))
+	mux.HandleOpcode(g53.OP_UPDATE, example, tagHandler("example-update", &tags))
+	mux.HandleOpcode(g53.OP_NOTIFY, com, tagHandler("com-notify", &tags))
+
+	requests := []*g53.Message{
+		makeRequest("www.example.com.", g53.OP_QUERY),
+		makeRequest("example.com.", g53.OP_UPDATE),
+		makeRequest("www.other.com.", g53.OP_QUERY),
+		makeRequest("www.example.com.", g53.OP_NOTIFY),
+		makeRequest("other.com.", g53.OP_NOTIFY),
+	}
+	for _, req := range requests {
+		mux.ServeDNS(&recordWriter{}, req)
+	}
+	g53.Equal(t, len(tags), 5)
+	for i, tag := range []string{"example", "example-update", "com", "example", "com-notify"} {
+		g53.Equal(t, tags[i], tag)
+	}
+
+	w := &recordWriter{}
+	mux.ServeDNS(w, makeRequest("www.example.org.", g53.OP_QUERY))
+	g53.Assert(t, w.resp != nil, "unmatched name should be refused")
+	g53.Equal(t, w.resp.Header.Rcode, g53.Rcode(g53.R_REFUSED))
+
+	mux.HandleDefault(tagHandler("default", &tags))
+	mux.ServeDNS(&recordWriter{}, makeRequest("www.example.org.", g53.OP_QUERY))
+	g53.Equal(t, tags[len(tags)-1], "default")
+
+	mux.Remove(example)
+	mux.ServeDNS(&recordWriter{}, makeRequest("example.com.", g53.OP_UPDATE))
+	g53.Equal(t, tags[len(tags)-1], "com")
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"container/list"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+const (
+	DefaultRRLWindow        = 15
+	DefaultRRLSlip          = 2
+	DefaultRRLIPv4PrefixLen = 24
+	DefaultRRLIPv6PrefixLen = 56
+	DefaultRRLMaxEntries    = 100000
+)
+
+// category of response token, responses of same token to same client
+// network share one rate limit
+type RRLCategory uint8
+
+const (
+	RRL_RESPONSE RRLCategory = 0
+	RRL_NODATA               = 1
+	RRL_NXDOMAIN             = 2
+	RRL_REFERRAL             = 3
+	RRL_ERROR                = 4
+)
+
+var RRLCategoryStr = map[RRLCategory]string{
+	RRL_RESPONSE: "response",
+	RRL_NODATA:   "nodata",
+	RRL_NXDOMAIN: "nxdomain",
+	RRL_REFERRAL: "referral",
+	RRL_ERROR:    "error",
+}
+
+func (c RRLCategory) String() string {
+	return RRLCategoryStr[c]
+}
+
+type rrlAction uint8
+
+const (
+	rrlPass rrlAction = 0
+	rrlDrop           = 1
+	rrlSlip           = 2
+)
+
+type rrlKey struct {
+	prefix   string
+	category RRLCategory
+	name     string
+	typ      g53.RRType
+}
+
+type rrlBucket struct {
+	key     rrlKey
+	balance int
+	last    time.Time
+	limited int
+}
+
+// RRL limits the udp responses of Next as the response rate limiting of
+// bind. Responses are counted per client network of IPv4PrefixLen or
+// IPv6PrefixLen and per token, which is qname and qtype for answers, the
+// wildcard name for answers synthesized from signed wildcard, the zone for
+// NXDOMAIN, the delegation for referrals and only the category for errors.
+//
+// Each token earns its per-second limit of credits every second and spends
+// one for each response, the debt is bounded by Window seconds of credits.
+// Responses are limited while there is debt, every Slip-th of the limited
+// ones is sent as an empty truncated response so that real clients retry
+// over tcp, and the others are dropped, 0 Slip drops all of them. Limit of a
+// category falls back to ResponsesPerSecond if it's 0, 0 ResponsesPerSecond
+// disables the limit. With LogOnly, limits are only logged. At most
+// MaxEntries tokens are tracked, the least recently used one is evicted for
+// a new token
+type RRL struct {
+	Next               Handler
+	ResponsesPerSecond int
+	NoDataPerSecond    int
+	NXDomainsPerSecond int
+	ReferralsPerSecond int
+	ErrorsPerSecond    int
+	Window             int
+	Slip               int
+	IPv4PrefixLen      int
+	IPv6PrefixLen      int
+	MaxEntries         int
+	LogOnly            bool
+	Logger             *log.Logger
+
+	lock    sync.Mutex
+	buckets map[rrlKey]*list.Element
+	lru     *list.List
+	now     func() time.Time
+}
+
+func NewRRL(next Handler, responsesPerSecond int) *RRL {
+	return &RRL{
+		Next:               next,
+		ResponsesPerSecond: responsesPerSecond,
+		Window:             DefaultRRLWindow,
+		Slip:               DefaultRRLSlip,
+		IPv4PrefixLen:      DefaultRRLIPv4PrefixLen,
+		IPv6PrefixLen:      DefaultRRLIPv6PrefixLen,
+		MaxEntries:         DefaultRRLMaxEntries,
+		buckets:            make(map[rrlKey]*list.Element),
+		lru:                list.New(),
+		now:                time.Now,
+	}
+}
+
+func (r *RRL) rate(category RRLCategory) int {
+	rate := 0
+	switch category {
+	case RRL_NODATA:
+		rate = r.NoDataPerSecond
+	case RRL_NXDOMAIN:
+		rate = r.NXDomainsPerSecond
+	case RRL_REFERRAL:
+		rate = r.ReferralsPerSecond
+	case RRL_ERROR:
+		rate = r.ErrorsPerSecond
+	}
+	if rate <= 0 {
+		rate = r.ResponsesPerSecond
+	}
+	return rate
+}
+
+func (r *RRL) window() int {
+	if r.Window <= 0 {
+		return DefaultRRLWindow
+	}
+	return r.Window
+}
+
+func (r *RRL) logf(format string, args ...interface{}) {
+	if r.Logger != nil {
+		r.Logger.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// responses over tcp aren't limited since the source can't be spoofed
+func (r *RRL) ServeDNS(w ResponseWriter, req *g53.Message) {
+	if w.Transport() != TRANSPORT_UDP || r.ResponsesPerSecond <= 0 {
+		r.Next.ServeDNS(w, req)
+		return
+	}
+	r.Next.ServeDNS(&rrlWriter{ResponseWriter: w, rrl: r}, req)
+}
+
+func (r *RRL) clientPrefix(w ResponseWriter) string {
+	ip := sourceIP(w)
+	if ip == nil {
+		return ""
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(r.IPv4PrefixLen, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(r.IPv6PrefixLen, 128)).String()
+}
+
+// wildcardName returns the wildcard which answer is synthesized from, the
+// rrsig of such answer has less labels than its owner
+func wildcardName(answer g53.Section) *g53.Name {
+	for _, rrset := range answer {
+		if rrset.Type != g53.RR_RRSIG || len(rrset.Rdatas) == 0 {
+			continue
+		}
+
+		labels := uint(rrset.Rdatas[0].(*g53.RRSig).Labels)
+		if count := rrset.Name.LabelCount() - 1; labels < count {
+			closest, err := rrset.Name.StripLeft(count - labels)
+			if err != nil {
+				return nil
+			}
+			wildcard, err := g53.NameFromString("*." + closest.String(false))
+			if err != nil {
+				return nil
+			}
+			return wildcard
+		}
+	}
+	return nil
+}
+
+func findOwner(section g53.Section, typ g53.RRType) *g53.Name {
+	for _, rrset := range section {
+		if rrset.Type == typ {
+			return rrset.Name
+		}
+	}
+	return nil
+}
+
+func responseToken(resp *g53.Message) (RRLCategory, *g53.Name, g53.RRType) {
+	q := resp.Question
+	if q == nil {
+		return RRL_ERROR, nil, 0
+	}
+
+	switch resp.Header.Rcode {
+	case g53.R_NOERROR:
+		if len(resp.Sections[g53.AnswerSection]) > 0 {
+			if wildcard := wildcardName(resp.Sections[g53.AnswerSection]); wildcard != nil {
+				return RRL_RESPONSE, wildcard, q.Type
+			}
+			return RRL_RESPONSE, q.Name, q.Type
+		}
+
+		auth := resp.Sections[g53.AuthSection]
+		if findOwner(auth, g53.RR_SOA) == nil {
+			if ns := findOwner(auth, g53.RR_NS); ns != nil {
+				return RRL_REFERRAL, ns, 0
+			}
+		}
+		return RRL_NODATA, q.Name, q.Type
+	case g53.R_NXDOMAIN:
+		if zone := findOwner(resp.Sections[g53.AuthSection], g53.RR_SOA); zone != nil {
+			return RRL_NXDOMAIN, zone, 0
+		}
+		return RRL_NXDOMAIN, q.Name, 0
+	default:
+		return RRL_ERROR, nil, 0
+	}
+}
+
+func (r *RRL) check(w ResponseWriter, resp *g53.Message) rrlAction {
+	category, name, typ := responseToken(resp)
+	rate := r.rate(category)
+	if rate <= 0 {
+		return rrlPass
+	}
+
+	k := rrlKey{
+		prefix:   r.clientPrefix(w),
+		category: category,
+		typ:      typ,
+	}
+	if name != nil {
+		k.name = strings.ToLower(name.String(false))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := r.now()
+	b := r.getBucket(k, rate, now)
+
+	if elapsed := int(now.Sub(b.last) / time.Second); elapsed > 0 {
+		b.balance += elapsed * rate
+		if b.balance > rate {
+			b.balance = rate
+		}
+		b.last = b.last.Add(time.Duration(elapsed) * time.Second)
+	}
+	b.balance -= 1
+	if floor := -r.window() * rate; b.balance < floor {
+		b.balance = floor
+	}
+
+	if b.balance >= 0 {
+		if b.limited > 0 {
+			r.logf("rrl: stop limiting %s responses of %s %s to %s", category, k.name, typ, k.prefix)
+			b.limited = 0
+		}
+		return rrlPass
+	}
+
+	b.limited += 1
+	if b.limited == 1 {
+		if r.LogOnly {
+			r.logf("rrl: would limit %s responses of %s %s to %s", category, k.name, typ, k.prefix)
+		} else {
+			r.logf("rrl: limit %s responses of %s %s to %s", category, k.name, typ, k.prefix)
+		}
+	}
+
+	switch {
+	case r.LogOnly:
+		return rrlPass
+	case r.Slip > 0 && b.limited%r.Slip == 0:
+		return rrlSlip
+	default:
+		return rrlDrop
+	}
+}
+
+// called with lock held, the bucket of k is created with full credits if
+// it doesn't exist, the least recently used bucket is evicted if there are
+// MaxEntries buckets already
+func (r *RRL) getBucket(k rrlKey, rate int, now time.Time) *rrlBucket {
+	if elem, ok := r.buckets[k]; ok {
+		r.lru.MoveToFront(elem)
+		return elem.Value.(*rrlBucket)
+	}
+
+	maxEntries := r.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultRRLMaxEntries
+	}
+	for r.lru.Len() >= maxEntries {
+		elem := r.lru.Back()
+		r.lru.Remove(elem)
+		delete(r.buckets, elem.Value.(*rrlBucket).key)
+	}
+
+	b := &rrlBucket{key: k, balance: rate, last: now}
+	r.buckets[k] = r.lru.PushFront(b)
+	return b
+}
+
+type rrlWriter struct {
+	ResponseWriter
+	rrl *RRL
+}
+
+func (w *rrlWriter) Write(resp *g53.Message) error {
+	switch w.rrl.check(w.ResponseWriter, resp) {
+	case rrlDrop:
+		return nil
+	case rrlSlip:
+		return w.ResponseWriter.Write(slipResponse(resp))
+	default:
+		return w.ResponseWriter.Write(resp)
+	}
+}
+
+// data is parsed to get its token, data which can't be parsed is counted as
+// error
+func (w *rrlWriter) WriteData(data []byte) error {
+	resp, err := g53.MessageFromWire(util.NewInputBuffer(data))
+	if err != nil {
+		resp = &g53.Message{Header: &g53.Header{Rcode: g53.R_SERVFAIL}}
+	}
+
+	switch w.rrl.check(w.ResponseWriter, resp) {
+	case rrlDrop:
+		return nil
+	case rrlSlip:
+		if err != nil {
+			return nil
+		}
+		return w.ResponseWriter.Write(slipResponse(resp))
+	default:
+		return w.ResponseWriter.WriteData(data)
+	}
+}
+
+func slipResponse(resp *g53.Message) *g53.Message {
+	header := *resp.Header
+	header.SetFlag(g53.FLAG_TC, true)
+	return &g53.Message{
+		Header:   &header,
+		Question: resp.Question,
+		Edns:     resp.Edns,
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+)
+
+// answerHandler answers with A record, names starting with nx don't exist
+// and names under wild.example.com. are synthesized from signed wildcard,
+// names starting with raw are answered by WriteData
+func answerHandler() Handler {
+	return HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+		resp := req.MakeResponse()
+		name := req.Question.Name
+		zone, _ := g53.NameFromString("example.com.")
+		if strings.HasPrefix(name.String(false), "nx") {
+			resp.Header.Rcode = g53.R_NXDOMAIN
+			resp.AddRRset(g53.AuthSection, g53.BuildRRset("example.com.", g53.RR_SOA, 300, "ns.example.com. root.example.com. 1 3600 900 300"))
+			w.Write(resp)
+			return
+		}
+
+		resp.AddRRset(g53.AnswerSection, g53.BuildRRset(name.String(false), g53.RR_A, 300, "1.1.1.1"))
+		if strings.HasPrefix(name.String(false), "raw") {
+			render := g53.NewMsgRender()
+			resp.Rend(render)
+			w.WriteData(render.Data())
+			return
+		}
+		if strings.HasSuffix(name.String(false), "wild.example.com.") {
+			sig := &g53.RRSig{
+				Covered:   g53.RR_A,
+				Algorithm: 8,
+				Labels:    3,
+				Signer:    zone,
+				Signature: []byte{1, 2, 3},
+			}
+			resp.AddRRset(g53.AnswerSection, &g53.RRset{Name: name, Type: g53.RR_RRSIG, Class: g53.CLASS_IN, Ttl: 300, Rdatas: []g53.Rdata{sig}})
+		}
+		w.Write(resp)
+	})
+}
+
+type transportWriter struct {
+	sourceWriter
+	transport Transport
+}
+
+func (w *transportWriter) Transport() Transport { return w.transport }
+
+func newTestRRL(rate int) (*RRL, *time.Time, *bytes.Buffer) {
+	var logs bytes.Buffer
+	now := time.Unix(1600000000, 0)
+	rrl := NewRRL(answerHandler(), rate)
+	rrl.Window = 3
+	rrl.Logger = log.New(&logs, "", 0)
+	rrl.now = func() time.Time { return now }
+	return rrl, &now, &logs
+}
+
+// query returns "pass", "slip" or "drop"
+func rrlQuery(t *testing.T, rrl *RRL, source, name string, transport Transport) string {
+	n, _ := g53.NameFromString(name)
+	w := &transportWriter{sourceWriter{source: net.ParseIP(source)}, transport}
+	rrl.ServeDNS(w, g53.MakeQuery(n, g53.RR_A, 512, false))
+	switch {
+	case w.resp == nil:
+		return "drop"
+	case w.resp.Header.GetFlag(g53.FLAG_TC):
+		g53.Equal(t, len(w.resp.Sections[g53.AnswerSection]), 0)
+		return "slip"
+	default:
+		return "pass"
+	}
+}
+
+func rrlQueries(t *testing.T, rrl *RRL, source, name string, n int) []string {
+	var results []string
+	for i := 0; i < n; i++ {
+		results = append(results, rrlQuery(t, rrl, source, name, TRANSPORT_UDP))
+	}
+	return results
+}
+
+func TestRRL(t *testing.T) {
+	rrl, now, logs := newTestRRL(2)
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "www.example.com.", 5), []string{"pass", "pass", "drop", "slip", "drop"})
+	g53.Assert(t, strings.Contains(logs.String(), "rrl: limit response responses of www.example.com. A to 10.0.0.0"), "limit should be logged")
+
+	//client network and token are counted separately
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.200", "www.example.com.", 1), []string{"slip"})
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.1.1", "www.example.com.", 2), []string{"pass", "pass"})
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "ftp.example.com.", 2), []string{"pass", "pass"})
+	g53.Equal(t, rrlQuery(t, rrl, "10.0.0.1", "www.example.com.", TRANSPORT_TCP), "pass")
+
+	//debt is bounded by window
+	rrlQueries(t, rrl, "10.0.0.1", "www.example.com.", 100)
+	*now = now.Add(time.Second)
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "www.example.com.", 1), []string{"drop"})
+	*now = now.Add(4 * time.Second)
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "www.example.com.", 3), []string{"pass", "pass", "drop"})
+	g53.Assert(t, strings.Contains(logs.String(), "rrl: stop limiting"), "stop of limit should be logged")
+}
+
+func TestRRLToken(t *testing.T) {
+	rrl, _, _ := newTestRRL(10)
+	rrl.NXDomainsPerSecond = 2
+	rrl.Slip = 0
+
+	//nxdomain of same zone share the limit
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "nx1.example.com.", 2), []string{"pass", "pass"})
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "nx2.example.com.", 2), []string{"drop", "drop"})
+
+	//answers from the same wildcard share the limit
+	for i := 0; i < 10; i++ {
+		rrlQuery(t, rrl, "10.0.0.1", string(rune('a'+i))+".wild.example.com.", TRANSPORT_UDP)
+	}
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "z.wild.example.com.", 1), []string{"drop"})
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "z.example.com.", 1), []string{"pass"})
+
+	resp := &g53.Message{Header: &g53.Header{Rcode: g53.R_REFUSED}}
+	category, name, _ := responseToken(resp)
+	g53.Equal(t, category, RRLCategory(RRL_ERROR))
+	g53.Assert(t, name == nil, "error has no name")
+}
+
+func TestRRLFullTable(t *testing.T) {
+	rrl, _, _ := newTestRRL(2)
+	rrl.MaxEntries = 2
+	rrl.Slip = 0
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "a.example.com.", 3), []string{"pass", "pass", "drop"})
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "b.example.com.", 3), []string{"pass", "pass", "drop"})
+
+	//new token is still limited when the table is full
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "c.example.com.", 3), []string{"pass", "pass", "drop"})
+	g53.Equal(t, len(rrl.buckets), 2)
+
+	//the least recently used token is evicted
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "b.example.com.", 1), []string{"drop"})
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "a.example.com.", 1), []string{"pass"})
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "b.example.com.", 1), []string{"drop"})
+	g53.Equal(t, len(rrl.buckets), 2)
+}
+
+func TestRRLWriteData(t *testing.T) {
+	rrl, _, _ := newTestRRL(2)
+	g53.Equal(t, rrlQueries(t, rrl, "10.0.0.1", "raw.example.com.", 5), []string{"pass", "pass", "drop", "slip", "drop"})
+}
+
+func TestRRLLogOnly(t *testing.T) {
+	rrl, _, logs := newTestRRL(2)
+	rrl.LogOnly = true
+	g53.Equal(t, rrlQueries(t, rrl, "2001:db8::1", "www.example.com.", 4), []string{"pass", "pass", "pass", "pass"})
+	g53.Assert(t, strings.Contains(logs.String(), "rrl: would limit response responses of www.example.com. A to 2001:db8::"), "limit should be logged")
+}
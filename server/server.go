@@ -0,0 +1,410 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+	"github.com/mistletoeChao/g53/util"
+)
+
+const (
+	DefaultTCPIdleTimeout       = 10 * time.Second
+	DefaultDSOKeepaliveInterval = 15 * time.Second
+	MinUDPSize                  = 512
+)
+
+var ErrServerClosed = errors.New("server is closed")
+
+type Handler interface {
+	ServeDNS(w ResponseWriter, req *g53.Message)
+}
+
+type HandlerFunc func(w ResponseWriter, req *g53.Message)
+
+func (f HandlerFunc) ServeDNS(w ResponseWriter, req *g53.Message) {
+	f(w, req)
+}
+
+// Server serves dns over udp and tcp, at most Workers requests are handled
+// at the same time. DSOKeepaliveInterval is sent to client with
+// TCPIdleTimeout as the inactivity timeout of dso session, dso request of
+// primary tlv in DSOTypes is passed to Handler, and answered with
+// DSOTYPENI if its type isn't there. Panic of Handler
+// is logged to Logger, or the standard logger if it's nil, and the request
+// is answered with SERVFAIL
+type Server struct {
+	Addr                 string
+	Handler              Handler
+	Workers              int
+	TCPIdleTimeout       time.Duration
+	DSOKeepaliveInterval time.Duration
+	DSOTypes             []g53.DSOType
+	Logger               *log.Logger
+
+	lock      sync.Mutex
+	initOnce  sync.Once
+	sem       chan struct{}
+	closing   bool
+	udpConns  []net.PacketConn
+	listeners []net.Listener
+	tcpConns  map[net.Conn]struct{}
+	loops     sync.WaitGroup
+	requests  sync.WaitGroup
+}
+
+func (s *Server) init() {
+	s.initOnce.Do(func() {
+		workers := s.Workers
+		if workers <= 0 {
+			workers = runtime.NumCPU() * 16
+		}
+		s.sem = make(chan struct{}, workers)
+		s.tcpConns = make(map[net.Conn]struct{})
+	})
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.TCPIdleTimeout <= 0 {
+		return DefaultTCPIdleTimeout
+	}
+	return s.TCPIdleTimeout
+}
+
+func (s *Server) keepaliveInterval() time.Duration {
+	if s.DSOKeepaliveInterval <= 0 {
+		return DefaultDSOKeepaliveInterval
+	}
+	return s.DSOKeepaliveInterval
+}
+
+// client of dso session sends keepalive traffic in keepalive interval and
+// isn't idle while it has long-lived operations, so the connection is kept
+// twice of the longer timeout
+func (s *Server) readTimeout(session *dsoSession) time.Duration {
+	if session.isEstablished() == false {
+		return s.idleTimeout()
+	}
+	if interval := s.keepaliveInterval(); interval > s.idleTimeout() {
+		return 2 * interval
+	}
+	return 2 * s.idleTimeout()
+}
+
+func (s *Server) isClosing() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closing
+}
+
+// ListenAndServe listens on Addr with both udp and tcp, it returns when
+// either of them stops
+func (s *Server) ListenAndServe() error {
+	udp, err := net.ListenPacket("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+
+	tcp, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		udp.Close()
+		return err
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.ServeUDP(udp) }()
+	go func() { errs <- s.ServeTCP(tcp) }()
+	return <-errs
+}
+
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	s.init()
+	s.lock.Lock()
+	if s.closing {
+		s.lock.Unlock()
+		return ErrServerClosed
+	}
+	s.udpConns = append(s.udpConns, conn)
+	s.loops.Add(1)
+	s.lock.Unlock()
+	defer s.loops.Done()
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+
+		data := make([]byte, n)
+		copy(data, buf[0:n])
+		w := &udpWriter{
+			conn:       conn,
+			remoteAddr: addr,
+		}
+		s.sem <- struct{}{}
+		s.requests.Add(1)
+		go func() {
+			defer func() {
+				<-s.sem
+				s.requests.Done()
+			}()
+			s.serve(w, data)
+		}()
+	}
+}
+
+func (s *Server) ServeTCP(ln net.Listener) error {
+	return s.serveStream(ln, TRANSPORT_TCP)
+}
+
+// ServeTLS serves dns over tls as rfc7858 on ln, responses are padded if
+// the query is padded
+func (s *Server) ServeTLS(ln net.Listener, config *tls.Config) error {
+	return s.serveStream(tls.NewListener(ln, config), TRANSPORT_TLS)
+}
+
+func (s *Server) serveStream(ln net.Listener, transport Transport) error {
+	s.init()
+	s.lock.Lock()
+	if s.closing {
+		s.lock.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners = append(s.listeners, ln)
+	s.loops.Add(1)
+	s.lock.Unlock()
+	defer s.loops.Done()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+
+		s.lock.Lock()
+		if s.closing {
+			s.lock.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.tcpConns[conn] = struct{}{}
+		s.loops.Add(1)
+		s.lock.Unlock()
+		go s.serveConn(conn, transport)
+	}
+}
+
+// queries on one connection are handled concurrently, and the connection is
+// closed after all of them are answered
+func (s *Server) serveConn(conn net.Conn, transport Transport) {
+	var inflight sync.WaitGroup
+	var writeLock sync.Mutex
+	session := &dsoSession{}
+	defer func() {
+		inflight.Wait()
+		conn.Close()
+		s.lock.Lock()
+		delete(s.tcpConns, conn)
+		s.lock.Unlock()
+		s.loops.Done()
+	}()
+
+	for {
+		//deadline set by shutdown mustn't be overridden
+		s.lock.Lock()
+		if s.closing {
+			s.lock.Unlock()
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(s.readTimeout(session)))
+		s.lock.Unlock()
+
+		data, err := client.ReadTCPMessage(conn)
+		if err != nil || s.isClosing() {
+			return
+		}
+
+		w := &tcpWriter{
+			conn:        conn,
+			writeLock:   &writeLock,
+			transport:   transport,
+			idleTimeout: s.idleTimeout(),
+			session:     session,
+		}
+		s.sem <- struct{}{}
+		s.requests.Add(1)
+		inflight.Add(1)
+		go func() {
+			defer func() {
+				<-s.sem
+				s.requests.Done()
+				inflight.Done()
+			}()
+			s.serve(w, data)
+		}()
+	}
+}
+
+// serve answers FORMERR if the request can't be parsed, responses and
+// messages too short to have a header are dropped
+func (s *Server) serve(w responseWriter, data []byte) {
+	req, err := g53.MessageFromWire(util.NewInputBuffer(data))
+	if err != nil {
+		header, err := g53.HeaderFromWire(util.NewInputBuffer(data))
+		if err == nil && header.GetFlag(g53.FLAG_QR) == false {
+			w.Write(MakeFormErr(header))
+		}
+		return
+	}
+
+	if req.Header.GetFlag(g53.FLAG_QR) {
+		return
+	}
+
+	w.setRequest(req, data)
+	if req.Header.Opcode == g53.OP_DSO && s.serveDSO(w, req) {
+		return
+	}
+	s.serveRequest(w, req)
+}
+
+// serveRequest recovers the panic of Handler as net/http does, so one bad
+// request neither kills the server nor leaves its client waiting
+func (s *Server) serveRequest(w responseWriter, req *g53.Message) {
+	defer func() {
+		if err := recover(); err != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			s.logf("panic serving %s: %v\n%s", w.RemoteAddr(), err, buf)
+			resp := req.MakeResponse()
+			resp.Header.Rcode = g53.R_SERVFAIL
+			w.Write(resp)
+		}
+	}()
+	s.Handler.ServeDNS(w, req)
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Logger != nil {
+		s.Logger.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// dso message is only valid on stream transports and the first one
+// establishes the session, keepalive request is answered with the timeouts
+// of server, true is returned if req has been handled
+func (s *Server) serveDSO(w responseWriter, req *g53.Message) bool {
+	tw, ok := w.(*tcpWriter)
+	if ok == false {
+		return true
+	}
+
+	switch req.DSOPrimary().(type) {
+	case nil, *g53.PaddingTLV:
+		w.Write(MakeFormErr(req.Header))
+		return true
+	case *g53.KeepaliveTLV:
+		if req.Header.Id != 0 {
+			tw.session.establish()
+			resp := req.MakeResponse()
+			resp.DSO = []g53.DSOTLV{g53.NewKeepaliveTLV(s.idleTimeout(), s.keepaliveInterval())}
+			w.Write(resp)
+		}
+		return true
+	default:
+		if s.isDSOTypeImplemented(req.DSOPrimary().Type()) == false {
+			if req.Header.Id != 0 {
+				resp := req.MakeResponse()
+				resp.Header.Rcode = g53.R_DSOTYPENI
+				w.Write(resp)
+			}
+			return true
+		}
+		tw.session.establish()
+		return false
+	}
+}
+
+func (s *Server) isDSOTypeImplemented(typ g53.DSOType) bool {
+	for _, t := range s.DSOTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func MakeFormErr(header *g53.Header) *g53.Message {
+	h := &g53.Header{
+		Id:     header.Id,
+		Opcode: header.Opcode,
+		Rcode:  g53.R_FORMERR,
+	}
+	h.SetFlag(g53.FLAG_QR, true)
+	return &g53.Message{Header: h}
+}
+
+// Shutdown stops reading new requests and waits for the ones being handled,
+// connections are closed when ctx is done before that
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.init()
+	s.lock.Lock()
+	s.closing = true
+	now := time.Now()
+	for _, conn := range s.udpConns {
+		conn.SetReadDeadline(now)
+	}
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.tcpConns {
+		conn.SetReadDeadline(now)
+	}
+	s.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.loops.Wait()
+		s.requests.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.lock.Lock()
+	for _, conn := range s.udpConns {
+		conn.Close()
+	}
+	for conn := range s.tcpConns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+	return err
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+	"github.com/mistletoeChao/g53/util"
+)
+
+func answer(req *g53.Message, count int) *g53.Message {
+	resp := req.MakeResponse()
+	for i := 0; i < count; i++ {
+		a, _ := g53.AFromString(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		resp.AddRr(g53.AnswerSection, req.Question.Name, g53.RR_A, g53.CLASS_IN, a, true)
+	}
+	return resp
+}
+
+// start serves both udp and tcp on the same port
+func start(t *testing.T, s *Server) (string, chan error) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp failed:%v", err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("listen tcp failed:%v", err)
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- s.ServeUDP(udp) }()
+	go func() { errs <- s.ServeTCP(tcp) }()
+	return udp.LocalAddr().String(), errs
+}
+
+func TestServe(t *testing.T) {
+	var transports [2]int32
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+			atomic.AddInt32(&transports[w.Transport()], 1)
+			g53.Assert(t, w.RemoteAddr() != nil, "remote address should be set")
+			w.Write(answer(req, 100))
+		}),
+	}
+	addr, _ := start(t, s)
+	defer s.Shutdown(context.Background())
+
+	name, _ := g53.NameFromString("www.example.com.")
+	query := g53.MakeQuery(name, g53.RR_A, 4096, false)
+	resp, _, err := client.NewClient().Exchange(query, []string{addr})
+	g53.Assert(t, err == nil, "query failed:%v", err)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].RrCount(), 100)
+	g53.Equal(t, atomic.LoadInt32(&transports[TRANSPORT_UDP]), int32(1))
+
+	//response is truncated to 512 bytes and client retries over tcp
+	query = g53.MakeQuery(name, g53.RR_A, 512, false)
+	resp, _, err = client.NewClient().Exchange(query, []string{addr})
+	g53.Assert(t, err == nil, "query failed:%v", err)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].RrCount(), 100)
+	g53.Equal(t, atomic.LoadInt32(&transports[TRANSPORT_UDP]), int32(2))
+	g53.Equal(t, atomic.LoadInt32(&transports[TRANSPORT_TCP]), int32(1))
+}
+
+func TestFormErr(t *testing.T) {
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+			w.Write(answer(req, 1))
+		}),
+	}
+	addr, _ := start(t, s)
+	defer s.Shutdown(context.Background())
+
+	conn, _ := net.Dial("udp", addr)
+	defer conn.Close()
+	//header says there is one question but no question follows
+	conn.Write([]byte{0x12, 0x34, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 3, 'w'})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	g53.Assert(t, err == nil, "formerr should be returned:%v", err)
+	resp, err := g53.MessageFromWire(util.NewInputBuffer(buf[0:n]))
+	g53.Assert(t, err == nil, "formerr should be valid:%v", err)
+	g53.Equal(t, resp.Header.Id, uint16(0x1234))
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_FORMERR))
+	g53.Assert(t, resp.Header.GetFlag(g53.FLAG_QR), "formerr should be a response")
+}
+
+func TestWorkers(t *testing.T) {
+	var running, maxRunning int32
+	s := &Server{
+		Workers: 2,
+		Handler: HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			w.Write(answer(req, 1))
+		}),
+	}
+	addr, _ := start(t, s)
+	defer s.Shutdown(context.Background())
+
+	errs := make(chan error, 6)
+	for i := 0; i < 6; i++ {
+		go func(i int) {
+			name, _ := g53.NameFromString(fmt.Sprintf("%d.example.com.", i))
+			_, _, err := client.NewClient().Exchange(g53.MakeQuery(name, g53.RR_A, 512, false), []string{addr})
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < 6; i++ {
+		err := <-errs
+		g53.Assert(t, err == nil, "query failed:%v", err)
+	}
+	g53.Equal(t, atomic.LoadInt32(&maxRunning), int32(2))
+}
+
+func TestShutdown(t *testing.T) {
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+			time.Sleep(200 * time.Millisecond)
+			w.Write(answer(req, 1))
+		}),
+	}
+	addr, serveErrs := start(t, s)
+
+	name, _ := g53.NameFromString("www.example.com.")
+	errs := make(chan error, 2)
+	for _, tcpOnly := range []bool{false, true} {
+		go func(tcpOnly bool) {
+			c := &client.Client{Timeout: time.Second, TCPOnly: tcpOnly}
+			_, _, err := c.ExchangeWith(g53.MakeQuery(name, g53.RR_A, 512, false), addr)
+			errs <- err
+		}(tcpOnly)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	err := s.Shutdown(context.Background())
+	g53.Assert(t, err == nil, "shutdown failed:%v", err)
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		g53.Assert(t, err == nil, "request being handled should be answered:%v", err)
+	}
+	g53.Equal(t, <-serveErrs, ErrServerClosed)
+	g53.Equal(t, <-serveErrs, ErrServerClosed)
+
+	c := &client.Client{Timeout: 100 * time.Millisecond}
+	_, _, err = c.ExchangeWith(g53.MakeQuery(name, g53.RR_A, 512, false), addr)
+	g53.Assert(t, err != nil, "closed server shouldn't answer")
+}
+
+func TestTruncate(t *testing.T) {
+	name, _ := g53.NameFromString("www.example.com.")
+	req := g53.MakeQuery(name, g53.RR_A, 512, false)
+	resp := answer(req, 10)
+	for i := 0; i < 20; i++ {
+		a, _ := g53.AFromString(fmt.Sprintf("10.1.0.%d", i))
+		ns, _ := g53.NameFromString(fmt.Sprintf("ns%d.example.com.", i))
+		resp.AddRr(g53.AdditionalSection, ns, g53.RR_A, g53.CLASS_IN, a, false)
+	}
+
+	data := Truncate(resp, 200)
+	g53.Assert(t, len(data) <= 200, "truncated response should fit")
+	parsed, _ := g53.MessageFromWire(util.NewInputBuffer(data))
+	g53.Equal(t, parsed.Header.GetFlag(g53.FLAG_TC), false)
+	g53.Equal(t, parsed.Sections[g53.AnswerSection][0].RrCount(), 10)
+	g53.Assert(t, len(parsed.Sections[g53.AdditionalSection]) < 20, "additional should be dropped")
+
+	g53.Equal(t, len(resp.Sections[g53.AdditionalSection]), 20)
+
+	resp = answer(req, 100)
+	data = Truncate(resp, 512)
+	parsed, _ = g53.MessageFromWire(util.NewInputBuffer(data))
+	g53.Assert(t, parsed.Header.GetFlag(g53.FLAG_TC), "tc should be set")
+	g53.Equal(t, len(parsed.Sections[g53.AnswerSection]), 0)
+	//the message of caller is kept
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].RrCount(), 100)
+	g53.Equal(t, resp.Header.GetFlag(g53.FLAG_TC), false)
+}
+
+func TestRequestData(t *testing.T) {
+	req := makeRequest("www.example.com.", g53.OP_QUERY)
+	render := g53.NewMsgRender()
+	req.Rend(render)
+	g53.Equal(t, RequestData(&recordWriter{}, req), render.Data())
+
+	w := &udpWriter{}
+	w.setRequest(req, []byte{1, 2, 3})
+	g53.Equal(t, RequestData(w, req), []byte{1, 2, 3})
+}
+
+func TestRecoverPanic(t *testing.T) {
+	var logs bytes.Buffer
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+			if req.Question.Name.String(false) == "panic.example.com." {
+				panic("bad handler")
+			}
+			w.Write(answer(req, 1))
+		}),
+		Logger: log.New(&logs, "", 0),
+	}
+	addr, _ := start(t, s)
+
+	name, _ := g53.NameFromString("panic.example.com.")
+	resp, _, err := client.NewClient().Exchange(g53.MakeQuery(name, g53.RR_A, 512, false), []string{addr})
+	g53.Assert(t, err == nil, "query failed:%v", err)
+	g53.Equal(t, resp.Header.Rcode, g53.Rcode(g53.R_SERVFAIL))
+
+	//server keeps serving
+	name, _ = g53.NameFromString("www.example.com.")
+	resp, _, err = client.NewClient().Exchange(g53.MakeQuery(name, g53.RR_A, 512, false), []string{addr})
+	g53.Assert(t, err == nil, "query failed:%v", err)
+	g53.Equal(t, resp.Sections[g53.AnswerSection][0].RrCount(), 1)
+
+	s.Shutdown(context.Background())
+	g53.Assert(t, strings.Contains(logs.String(), "bad handler"), "panic should be logged")
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+)
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key failed:%v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dns.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"dns.example.com"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate failed:%v", err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+func startTLS(t *testing.T, s *Server, cert tls.Certificate) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed:%v", err)
+	}
+	go s.ServeTLS(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+	return ln.Addr().String()
+}
+
+func TestDoT(t *testing.T) {
+	tlsCert, cert := selfSignedCert(t)
+	var lock sync.Mutex
+	remotes := make(map[string]bool)
+	padded := true
+	s := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+			lock.Lock()
+			remotes[w.RemoteAddr().String()] = true
+			padded = padded && req.Edns.PaddingOpt() != nil
+			lock.Unlock()
+			g53.Assert(t, w.Transport() == TRANSPORT_TLS, "transport should be tls")
+			resp := answer(req, 3)
+			resp.Edns = &g53.EDNS{UdpSize: 4096}
+			w.Write(resp)
+		}),
+	}
+	addr := startTLS(t, s, tlsCert)
+	defer s.Shutdown(context.Background())
+
+	c := client.NewTLSClient(nil, []string{client.SPKIPin(cert)})
+	defer c.Close()
+	name, _ := g53.NameFromString("www.example.com.")
+	for i := 0; i < 3; i++ {
+		resp, _, err := c.Exchange(g53.MakeQuery(name, g53.RR_A, 4096, false), addr)
+		g53.Assert(t, err == nil, "dot query failed:%v", err)
+		g53.Equal(t, resp.Sections[g53.AnswerSection][0].RrCount(), 3)
+		g53.Assert(t, resp.Edns.PaddingOpt() != nil, "response should be padded")
+	}
+	g53.Equal(t, len(remotes), 1)
+	g53.Assert(t, padded, "query should be padded")
+
+	//certificate chain is verified without pins
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	c2 := client.NewTLSClient(&tls.Config{RootCAs: pool, ServerName: "dns.example.com"}, nil)
+	defer c2.Close()
+	_, _, err := c2.Exchange(g53.MakeQuery(name, g53.RR_A, 4096, false), addr)
+	g53.Assert(t, err == nil, "dot query with ca failed:%v", err)
+
+	_, other := selfSignedCert(t)
+	c3 := client.NewTLSClient(nil, []string{client.SPKIPin(other)})
+	_, _, err = c3.Exchange(g53.MakeQuery(name, g53.RR_A, 4096, false), addr)
+	g53.Assert(t, err != nil, "server with unpinned key should be rejected")
+
+	c4 := client.NewTLSClient(nil, nil)
+	_, _, err = c4.Exchange(g53.MakeQuery(name, g53.RR_A, 4096, false), addr)
+	g53.Assert(t, err != nil, "self signed certificate shouldn't be trusted")
+
+	//pinned certificate sent as intermediate behind unpinned leaf
+	forged, _ := selfSignedCert(t)
+	forged.Certificate = append(forged.Certificate, tlsCert.Certificate[0])
+	s2 := &Server{Handler: s.Handler}
+	addr2 := startTLS(t, s2, forged)
+	defer s2.Shutdown(context.Background())
+	c5 := client.NewTLSClient(nil, []string{client.SPKIPin(cert)})
+	_, _, err = c5.Exchange(g53.MakeQuery(name, g53.RR_A, 4096, false), addr2)
+	g53.Assert(t, err != nil, "only the leaf certificate should match the pin")
+}
+
+func TestDoTReconnect(t *testing.T) {
+	tlsCert, cert := selfSignedCert(t)
+	s := &Server{
+		TCPIdleTimeout: 100 * time.Millisecond,
+		Handler: HandlerFunc(func(w ResponseWriter, req *g53.Message) {
+			w.Write(answer(req, 1))
+		}),
+	}
+	addr := startTLS(t, s, tlsCert)
+	defer s.Shutdown(context.Background())
+
+	c := client.NewTLSClient(nil, []string{client.SPKIPin(cert)})
+	defer c.Close()
+	name, _ := g53.NameFromString("www.example.com.")
+	_, _, err := c.Exchange(g53.MakeQuery(name, g53.RR_A, 4096, false), addr)
+	g53.Assert(t, err == nil, "dot query failed:%v", err)
+
+	time.Sleep(300 * time.Millisecond)
+	_, _, err = c.Exchange(g53.MakeQuery(name, g53.RR_A, 4096, false), addr)
+	g53.Assert(t, err == nil, "client should reconnect after server closes idle connection:%v", err)
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+)
+
+// ViewRule selects View for the request which matches any of its conditions:
+// source address in Sources, client subnet in Subnets, tsig signed by one of
+// Keys, or view option naming one of ViewNames. Keys is checked last, once
+// a key has the name of the request tsig the rule matches only if the tsig
+// verifies with it, a bad signature makes the rule fail immediately without
+// trying the other keys, while later rules are still checked. Request with
+// tsig without rdata doesn't match Keys
+type ViewRule struct {
+	View      string
+	Sources   []*net.IPNet
+	Subnets   []*net.IPNet
+	Keys      []*g53.TSIGKey
+	ViewNames []string
+}
+
+// ParsePrefixes parses prefixes in cidr form, address without prefix length
+// is a host prefix
+func ParsePrefixes(prefixes ...string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range prefixes {
+		if ip := net.ParseIP(p); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ViewRule) match(w ResponseWriter, req *g53.Message) bool {
+	if len(r.Sources) > 0 && containsIP(r.Sources, sourceIP(w)) {
+		return true
+	}
+
+	if req.Edns != nil {
+		if subnet := req.Edns.SubnetOpt(); subnet != nil && subnet.SourcePrefix() > 0 &&
+			containsIP(r.Subnets, subnet.IP()) {
+			return true
+		}
+
+		if vo := req.Edns.ViewOpt(); vo != nil {
+			for _, view := range r.ViewNames {
+				if view == vo.View() {
+					return true
+				}
+			}
+		}
+	}
+
+	if req.Tsig != nil && len(req.Tsig.Rdatas) == 1 {
+		for _, key := range r.Keys {
+			if key.Name.Equals(req.Tsig.Name) {
+				_, err := key.Verify(RequestData(w, req), nil, time.Now())
+				return err == nil
+			}
+		}
+	}
+	return false
+}
+
+func sourceIP(w ResponseWriter) net.IP {
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+// ViewRouter serves request with the handler of the view selected by the
+// first matched rule, which is usually a ServeMux of the zones in the view.
+// Request matching no rule goes to the default view, and gets REFUSED if
+// there is no default view
+type ViewRouter struct {
+	lock        sync.RWMutex
+	views       map[string]Handler
+	rules       []*ViewRule
+	defaultView string
+}
+
+func NewViewRouter() *ViewRouter {
+	return &ViewRouter{
+		views: make(map[string]Handler),
+	}
+}
+
+func (r *ViewRouter) AddView(name string, h Handler) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.views[name] = h
+}
+
+// RemoveView removes the view together with the rules selecting it
+func (r *ViewRouter) RemoveView(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.views, name)
+	rules := r.rules[:0]
+	for _, rule := range r.rules {
+		if rule.View != name {
+			rules = append(rules, rule)
+		}
+	}
+	r.rules = rules
+	if r.defaultView == name {
+		r.defaultView = ""
+	}
+}
+
+// AddRule appends rule which is checked after the existing ones
+func (r *ViewRouter) AddRule(rule *ViewRule) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.views[rule.View]; ok == false {
+		return fmt.Errorf("unknown view %s", rule.View)
+	}
+	r.rules = append(r.rules, rule)
+	return nil
+}
+
+func (r *ViewRouter) SetDefaultView(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.views[name]; ok == false {
+		return fmt.Errorf("unknown view %s", name)
+	}
+	r.defaultView = name
+	return nil
+}
+
+// View returns the name of the view for req, empty string if there is no
+// view for it
+func (r *ViewRouter) View(w ResponseWriter, req *g53.Message) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, rule := range r.rules {
+		if rule.match(w, req) {
+			return rule.View
+		}
+	}
+	return r.defaultView
+}
+
+func (r *ViewRouter) ServeDNS(w ResponseWriter, req *g53.Message) {
+	view := r.View(w, req)
+	r.lock.RLock()
+	h := r.views[view]
+	r.lock.RUnlock()
+	if h != nil {
+		h.ServeDNS(w, req)
+		return
+	}
+
+	resp := req.MakeResponse()
+	resp.Header.Rcode = g53.R_REFUSED
+	w.Write(resp)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+type sourceWriter struct {
+	recordWriter
+	source net.IP
+}
+
+func (w *sourceWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: w.source, Port: 5353}
+}
+
+func mustPrefixes(t *testing.T, prefixes ...string) []*net.IPNet {
+	nets, err := ParsePrefixes(prefixes...)
+	g53.Assert(t, err == nil, "parse prefixes failed:%v", err)
+	return nets
+}
+
+func TestViewRouter(t *testing.T) {
+	var tags []string
+	router := NewViewRouter()
+	router.AddView("internal", tagHandler("internal", &tags))
+	router.AddView("partner", tagHandler("partner", &tags))
+	router.AddView("external", tagHandler("external", &tags))
+	key, _ := g53.NewTSIGKey("partner-key.", g53.TSIG_HMAC_SHA256, "c2VjcmV0c2VjcmV0c2VjcmV0")
+
+	g53.Assert(t, router.AddRule(&ViewRule{View: "unknown"}) != nil, "rule of unknown view should fail")
+	router.AddRule(&ViewRule{
+		View: "partner",
+		Keys: []*g53.TSIGKey{key},
+	})
+	router.AddRule(&ViewRule{
+		View:      "internal",
+		Sources:   mustPrefixes(t, "10.0.0.0/8", "192.168.1.1"),
+		Subnets:   mustPrefixes(t, "172.16.0.0/12"),
+		ViewNames: []string{"internal"},
+	})
+
+	serve := func(source string, edit func(*g53.Message)) string {
+		tags = nil
+		req := makeRequest("www.example.com.", g53.OP_QUERY)
+		if edit != nil {
+			edit(req)
+		}
+		w := &sourceWriter{source: net.ParseIP(source)}
+		router.ServeDNS(w, req)
+		if len(tags) == 0 {
+			g53.Equal(t, w.resp.Header.Rcode, g53.Rcode(g53.R_REFUSED))
+			return ""
+		}
+		return tags[0]
+	}
+
+	g53.Equal(t, serve("10.1.1.1", nil), "internal")
+	g53.Equal(t, serve("192.168.1.1", nil), "internal")
+	g53.Equal(t, serve("192.168.1.2", nil), "")
+	g53.Equal(t, serve("1.1.1.1", func(req *g53.Message) {
+		req.Edns.AddSubnetV4("172.20.1.1")
+	}), "internal")
+	g53.Equal(t, serve("1.1.1.1", func(req *g53.Message) {
+		req.Edns.AddSubnetView("internal")
+	}), "internal")
+
+	//first matched rule wins
+	signBy := func(key *g53.TSIGKey) func(*g53.Message) {
+		return func(req *g53.Message) {
+			render := g53.NewMsgRender()
+			key.Sign(req, render, nil, time.Now())
+			signed, _ := g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+			*req = *signed
+		}
+	}
+	sign := signBy(key)
+	g53.Equal(t, serve("10.1.1.1", sign), "partner")
+
+	//tsig with the key name but wrong secret doesn't match
+	forged, _ := g53.NewTSIGKey("partner-key.", g53.TSIG_HMAC_SHA256, "Zm9yZ2VkZm9yZ2VkZm9yZ2Vk")
+	g53.Equal(t, serve("10.1.1.1", signBy(forged)), "internal")
+	g53.Equal(t, serve("1.1.1.1", signBy(forged)), "")
+
+	//tsig without rdata isn't verified
+	g53.Equal(t, serve("1.1.1.1", func(req *g53.Message) {
+		req.Tsig = &g53.RRset{Name: key.Name, Type: g53.RR_TSIG, Class: g53.CLASS_ANY}
+	}), "")
+
+	router.SetDefaultView("external")
+	g53.Equal(t, serve("192.168.1.2", nil), "external")
+
+	router.RemoveView("partner")
+	g53.Equal(t, serve("10.1.1.1", sign), "internal")
+	router.RemoveView("external")
+	g53.Equal(t, serve("1.1.1.1", nil), "")
+}
+
+func TestParsePrefixes(t *testing.T) {
+	nets := mustPrefixes(t, "10.0.0.0/8", "2001:db8::/32", "1.1.1.1", "::1")
+	var strs []string
+	for _, n := range nets {
+		strs = append(strs, n.String())
+	}
+	g53.Equal(t, strs, []string{"10.0.0.0/8", "2001:db8::/32", "1.1.1.1/32", "::1/128"})
+
+	_, err := ParsePrefixes("10.0.0.0/33")
+	g53.Assert(t, err != nil, "invalid prefix should fail")
+}
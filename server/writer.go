@@ -0,0 +1,209 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+)
+
+type Transport uint8
+
+const (
+	TRANSPORT_UDP   Transport = 0
+	TRANSPORT_TCP             = 1
+	TRANSPORT_TLS             = 2
+	TRANSPORT_HTTPS           = 3
+)
+
+var TransportStr = map[Transport]string{
+	TRANSPORT_UDP:   "udp",
+	TRANSPORT_TCP:   "tcp",
+	TRANSPORT_TLS:   "tls",
+	TRANSPORT_HTTPS: "https",
+}
+
+func (t Transport) String() string {
+	return TransportStr[t]
+}
+
+// ResponseWriter sends response back to the client of the request, Write
+// truncates the response to fit into the udp size of the client, and
+// WriteData sends data as it is which is used for signed responses
+type ResponseWriter interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	Transport() Transport
+	Write(resp *g53.Message) error
+	WriteData(data []byte) error
+}
+
+type responseWriter interface {
+	ResponseWriter
+	setRequest(req *g53.Message, data []byte)
+}
+
+// RequestData returns the wire format of req which w answers, req is rendered
+// again if w doesn't keep the data it's parsed from, which matches the
+// original only if the client renders message in the same way
+func RequestData(w ResponseWriter, req *g53.Message) []byte {
+	if rw, ok := w.(interface{ requestData() []byte }); ok {
+		if data := rw.requestData(); data != nil {
+			return data
+		}
+	}
+
+	render := g53.NewMsgRender()
+	req.Rend(render)
+	return render.Data()
+}
+
+type udpWriter struct {
+	conn       net.PacketConn
+	remoteAddr net.Addr
+	req        *g53.Message
+	reqData    []byte
+}
+
+func (w *udpWriter) LocalAddr() net.Addr {
+	return w.conn.LocalAddr()
+}
+
+func (w *udpWriter) RemoteAddr() net.Addr {
+	return w.remoteAddr
+}
+
+func (w *udpWriter) Transport() Transport {
+	return TRANSPORT_UDP
+}
+
+func (w *udpWriter) setRequest(req *g53.Message, data []byte) {
+	w.req = req
+	w.reqData = data
+}
+
+func (w *udpWriter) requestData() []byte {
+	return w.reqData
+}
+
+func (w *udpWriter) Write(resp *g53.Message) error {
+	return w.WriteData(Truncate(resp, UDPSize(w.req)))
+}
+
+func (w *udpWriter) WriteData(data []byte) error {
+	_, err := w.conn.WriteTo(data, w.remoteAddr)
+	return err
+}
+
+type tcpWriter struct {
+	conn        net.Conn
+	writeLock   *sync.Mutex
+	transport   Transport
+	idleTimeout time.Duration
+	session     *dsoSession
+	req         *g53.Message
+	reqData     []byte
+}
+
+// dsoSession is shared by all the requests on one connection
+type dsoSession struct {
+	lock        sync.Mutex
+	established bool
+}
+
+func (s *dsoSession) establish() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.established = true
+}
+
+func (s *dsoSession) isEstablished() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.established
+}
+
+func (w *tcpWriter) LocalAddr() net.Addr {
+	return w.conn.LocalAddr()
+}
+
+func (w *tcpWriter) RemoteAddr() net.Addr {
+	return w.conn.RemoteAddr()
+}
+
+func (w *tcpWriter) Transport() Transport {
+	return w.transport
+}
+
+func (w *tcpWriter) setRequest(req *g53.Message, data []byte) {
+	w.req = req
+	w.reqData = data
+}
+
+func (w *tcpWriter) requestData() []byte {
+	return w.reqData
+}
+
+// the idle timeout is sent back if client asks for it by edns-tcp-keepalive
+// before dso session is established, and response over tls is padded if the
+// query is padded
+func (w *tcpWriter) Write(resp *g53.Message) error {
+	if w.req != nil && w.req.Edns != nil && resp.Edns != nil {
+		if w.req.Edns.KeepaliveOpt() != nil && resp.Edns.KeepaliveOpt() == nil &&
+			w.session.isEstablished() == false {
+			resp.Edns.Options = append(resp.Edns.Options, g53.NewKeepaliveOpt(w.idleTimeout))
+		}
+
+		if w.transport == TRANSPORT_TLS && w.req.Edns.PaddingOpt() != nil {
+			g53.PadMessage(resp, g53.RESPONSE_PADDING_BLOCK)
+		}
+	}
+	return w.WriteData(Truncate(resp, client.MaxTCPMessageLen))
+}
+
+func (w *tcpWriter) WriteData(data []byte) error {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+	return client.WriteTCPMessage(w.conn, data)
+}
+
+// UDPSize is the max udp response size the client of req accepts
+func UDPSize(req *g53.Message) int {
+	if req != nil && req.Edns != nil && int(req.Edns.UdpSize) > MinUDPSize {
+		return int(req.Edns.UdpSize)
+	}
+	return MinUDPSize
+}
+
+// Truncate renders resp within size, rrsets in additional section are
+// dropped first, if it still doesn't fit, answer and authority sections are
+// dropped as well and tc is set. resp itself isn't changed
+func Truncate(resp *g53.Message, size int) []byte {
+	render := g53.NewMsgRender()
+	resp.Rend(render)
+	if int(render.Len()) <= size {
+		return render.Data()
+	}
+
+	header := *resp.Header
+	truncated := *resp
+	truncated.Header = &header
+	for len(truncated.Sections[g53.AdditionalSection]) > 0 {
+		additional := truncated.Sections[g53.AdditionalSection]
+		truncated.Sections[g53.AdditionalSection] = additional[0 : len(additional)-1]
+		render.Clear()
+		truncated.Rend(render)
+		if int(render.Len()) <= size {
+			return render.Data()
+		}
+	}
+
+	truncated.Sections[g53.AnswerSection] = nil
+	truncated.Sections[g53.AuthSection] = nil
+	truncated.Header.SetFlag(g53.FLAG_TC, true)
+	render.Clear()
+	truncated.Rend(render)
+	return render.Data()
+}
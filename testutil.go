@@ -60,3 +60,27 @@ func Nequal(t *testing.T, act, exp interface{}) {
 		t.FailNow()
 	}
 }
+
+// BuildRRset makes rrset of class IN from rdatas in string form, it panics
+// on invalid input since it's used to build test data
+func BuildRRset(name string, typ RRType, ttl int, rdatas ...string) *RRset {
+	n, err := NameFromString(name)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	rrset := &RRset{
+		Name:  n,
+		Type:  typ,
+		Class: CLASS_IN,
+		Ttl:   RRTTL(ttl),
+	}
+	for _, s := range rdatas {
+		rdata, err := RdataFromStr(typ, s)
+		if err != nil {
+			panic(err.Error())
+		}
+		rrset.Rdatas = append(rrset.Rdatas, rdata)
+	}
+	return rrset
+}
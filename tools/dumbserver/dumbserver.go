@@ -3,10 +3,9 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net"
 
 	"github.com/mistletoeChao/g53"
-	"github.com/mistletoeChao/g53/util"
+	"github.com/mistletoeChao/g53/server"
 )
 
 var (
@@ -19,25 +18,15 @@ func init() {
 
 func main() {
 	flag.Parse()
-	addr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", port))
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		panic(fmt.Sprintf("bind port %d failed %s\n", port, err.Error()))
+	s := &server.Server{
+		Addr: fmt.Sprintf(":%d", port),
+		Handler: server.HandlerFunc(func(w server.ResponseWriter, req *g53.Message) {
+			fmt.Printf("%s %s\n%s\n", w.Transport().String(), w.RemoteAddr().String(), req.String())
+			w.Write(req)
+		}),
 	}
-	defer conn.Close()
 
-	buf := make([]byte, 512)
-	for {
-		n, addr, err := conn.ReadFromUDP(buf)
-		buffer := util.NewInputBuffer(buf[0:n])
-
-		msg, err := g53.MessageFromWire(buffer)
-		if err != nil {
-			fmt.Println("Error: ", err)
-		} else {
-			fmt.Printf("%s\n", msg.String())
-			fmt.Printf("%s\n", util.BytesToElixirStr(buf[0:n]))
-			conn.WriteTo(buf[0:n], addr)
-		}
+	if err := s.ListenAndServe(); err != nil {
+		panic(fmt.Sprintf("serve on port %d failed %s\n", port, err.Error()))
 	}
 }
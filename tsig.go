@@ -0,0 +1,311 @@
+package g53
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"time"
+
+	"github.com/mistletoeChao/g53/util"
+)
+
+const (
+	TSIG_HMAC_MD5    = "hmac-md5.sig-alg.reg.int."
+	TSIG_HMAC_SHA1   = "hmac-sha1."
+	TSIG_HMAC_SHA256 = "hmac-sha256."
+	TSIG_HMAC_SHA512 = "hmac-sha512."
+)
+
+const DEFAULT_TSIG_FUDGE = 300
+
+type TSIGError uint16
+
+const (
+	TSIG_BADSIG   TSIGError = 16
+	TSIG_BADKEY   TSIGError = 17
+	TSIG_BADTIME  TSIGError = 18
+	TSIG_BADTRUNC TSIGError = 22
+)
+
+var TSIGErrorStr = map[TSIGError]string{
+	TSIG_BADSIG:   "BADSIG",
+	TSIG_BADKEY:   "BADKEY",
+	TSIG_BADTIME:  "BADTIME",
+	TSIG_BADTRUNC: "BADTRUNC",
+}
+
+func (e TSIGError) Error() string {
+	if s, ok := TSIGErrorStr[e]; ok {
+		return "tsig verify failed: " + s
+	}
+	return fmt.Sprintf("tsig verify failed: %d", uint16(e))
+}
+
+var ErrNoTSIG = errors.New("message isn't signed with tsig")
+var ErrTSIGNotLast = errors.New("tsig isn't the last rr or appears more than once")
+
+type TSIGKey struct {
+	Name      *Name
+	Algorithm *Name
+	Secret    []byte
+	Fudge     uint16
+}
+
+// NewTSIGKey creates key from the base64 encoded secret
+func NewTSIGKey(name, algorithm, secret string) (*TSIGKey, error) {
+	n, err := NameFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	alg, err := NameFromString(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if hashByAlgorithm(alg) == nil {
+		return nil, fmt.Errorf("unsupported tsig algorithm %s", algorithm)
+	}
+
+	s, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TSIGKey{
+		Name:      n,
+		Algorithm: alg,
+		Secret:    s,
+		Fudge:     DEFAULT_TSIG_FUDGE,
+	}, nil
+}
+
+func hashByAlgorithm(alg *Name) func() hash.Hash {
+	switch alg.String(false) {
+	case TSIG_HMAC_MD5:
+		return md5.New
+	case TSIG_HMAC_SHA1:
+		return sha1.New
+	case TSIG_HMAC_SHA256:
+		return sha256.New
+	case TSIG_HMAC_SHA512:
+		return sha512.New
+	default:
+		return nil
+	}
+}
+
+func writeCanonicalName(name *Name, buffer *util.OutputBuffer) {
+	for _, c := range name.raw {
+		buffer.WriteUint8(maptolower[c])
+	}
+}
+
+// mac covers the request mac for responses, the message without tsig and
+// the tsig variables
+func (key *TSIGKey) mac(requestMAC []byte, msg []byte, tsig *TSIG) ([]byte, error) {
+	h := hashByAlgorithm(key.Algorithm)
+	if h == nil {
+		return nil, fmt.Errorf("unsupported tsig algorithm %s", key.Algorithm.String(false))
+	}
+
+	buffer := util.NewOutputBuffer(uint(len(msg)) + 128)
+	if requestMAC != nil {
+		buffer.WriteUint16(uint16(len(requestMAC)))
+		buffer.WriteData(requestMAC)
+	}
+	buffer.WriteData(msg)
+	writeCanonicalName(key.Name, buffer)
+	buffer.WriteUint16(uint16(CLASS_ANY))
+	buffer.WriteUint32(0)
+	writeCanonicalName(tsig.Algorithm, buffer)
+	buffer.WriteUint16(uint16(tsig.TimeSigned >> 32))
+	buffer.WriteUint32(uint32(tsig.TimeSigned))
+	buffer.WriteUint16(tsig.Fudge)
+	buffer.WriteUint16(tsig.Error)
+	buffer.WriteUint16(uint16(len(tsig.OtherData)))
+	buffer.WriteData(tsig.OtherData)
+
+	mac := hmac.New(h, key.Secret)
+	mac.Write(buffer.Data())
+	return mac.Sum(nil), nil
+}
+
+// names in tsig rr are never compressed
+func rendTSIG(rrset *RRset, r *MsgRender) {
+	r.WriteName(rrset.Name, false)
+	rrset.Type.Rend(r)
+	rrset.Class.Rend(r)
+	rrset.Ttl.Rend(r)
+	pos := r.Len()
+	r.Skip(2)
+	rrset.Rdatas[0].Rend(r)
+	r.WriteUint16At(uint16(r.Len()-pos-2), pos)
+}
+
+// Sign rends message into r and appends the tsig rr, requestMAC is the mac
+// of the request when signing a response and nil otherwise
+func (key *TSIGKey) Sign(m *Message, r *MsgRender, requestMAC []byte, now time.Time) error {
+	return key.sign(m, r, requestMAC, &TSIG{
+		Algorithm:  key.Algorithm,
+		TimeSigned: uint64(now.Unix()),
+		Fudge:      key.Fudge,
+	})
+}
+
+// SignBadTime signs the BADTIME error response to the request signed with
+// request as rfc8945, the mac covers the request mac, the time signed of
+// request is kept and the time of server is put into other data
+func (key *TSIGKey) SignBadTime(m *Message, r *MsgRender, request *TSIG, now time.Time) error {
+	t := uint64(now.Unix())
+	return key.sign(m, r, request.MAC, &TSIG{
+		Algorithm:  key.Algorithm,
+		TimeSigned: request.TimeSigned,
+		Fudge:      request.Fudge,
+		Error:      uint16(TSIG_BADTIME),
+		OtherData:  []uint8{uint8(t >> 40), uint8(t >> 32), uint8(t >> 24), uint8(t >> 16), uint8(t >> 8), uint8(t)},
+	})
+}
+
+func (key *TSIGKey) sign(m *Message, r *MsgRender, requestMAC []byte, tsig *TSIG) error {
+	m.Tsig = nil
+	m.Rend(r)
+
+	tsig.OriginalId = m.Header.Id
+	mac, err := key.mac(requestMAC, r.Data(), tsig)
+	if err != nil {
+		return err
+	}
+	tsig.MAC = mac
+
+	m.Tsig = &RRset{
+		Name:   key.Name,
+		Type:   RR_TSIG,
+		Class:  CLASS_ANY,
+		Ttl:    0,
+		Rdatas: []Rdata{tsig},
+	}
+	rendTSIG(m.Tsig, r)
+	m.Header.ARCount += 1
+	return r.WriteUint16At(m.Header.ARCount, 10)
+}
+
+func skipQuestion(buffer *util.InputBuffer) error {
+	if _, err := NameFromWire(buffer, false); err != nil {
+		return err
+	}
+	_, err := buffer.ReadBytes(4)
+	return err
+}
+
+func skipRR(buffer *util.InputBuffer) (RRType, error) {
+	if _, err := NameFromWire(buffer, false); err != nil {
+		return RRType(0), err
+	}
+
+	t, err := TypeFromWire(buffer)
+	if err != nil {
+		return RRType(0), err
+	}
+
+	if _, err := buffer.ReadBytes(6); err != nil {
+		return RRType(0), err
+	}
+
+	rdlen, err := buffer.ReadUint16()
+	if err != nil {
+		return RRType(0), err
+	}
+	_, err = buffer.ReadBytes(uint(rdlen))
+	return t, err
+}
+
+// find the position of the last rr which should be tsig
+func tsigPosition(wire []byte) (uint, *Header, error) {
+	buffer := util.NewInputBuffer(wire)
+	h, err := HeaderFromWire(buffer)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if h.ARCount == 0 {
+		return 0, nil, ErrNoTSIG
+	}
+
+	for i := uint16(0); i < h.QDCount; i++ {
+		if err := skipQuestion(buffer); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	rrCount := int(h.ANCount) + int(h.NSCount) + int(h.ARCount) - 1
+	for i := 0; i < rrCount; i++ {
+		t, err := skipRR(buffer)
+		if err != nil {
+			return 0, nil, err
+		}
+		if t == RR_TSIG && i >= int(h.ANCount)+int(h.NSCount) {
+			return 0, nil, ErrTSIGNotLast
+		}
+	}
+	return buffer.Position(), h, nil
+}
+
+// Verify checks the tsig rr at the end of wire, requestMAC is the mac of the
+// request when verifying a response. The tsig rdata is returned even if
+// verify failed as long as it exists
+func (key *TSIGKey) Verify(wire []byte, requestMAC []byte, now time.Time) (*TSIG, error) {
+	pos, h, err := tsigPosition(wire)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := util.NewInputBuffer(wire)
+	buffer.SetPosition(pos)
+	rrset, err := RRsetFromWire(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	if rrset.Type != RR_TSIG {
+		return nil, ErrNoTSIG
+	}
+
+	if len(rrset.Rdatas) != 1 {
+		return nil, TSIG_BADSIG
+	}
+
+	tsig := rrset.Rdatas[0].(*TSIG)
+	if rrset.Name.Equals(key.Name) == false || tsig.Algorithm.Equals(key.Algorithm) == false {
+		return tsig, TSIG_BADKEY
+	}
+
+	signed := make([]byte, pos)
+	copy(signed, wire[0:pos])
+	out := util.NewOutputBuffer(0)
+	out.WriteUint16(tsig.OriginalId)
+	out.WriteUint16(h.ARCount - 1)
+	copy(signed[0:2], out.Data()[0:2])
+	copy(signed[10:12], out.Data()[2:4])
+
+	mac, err := key.mac(requestMAC, signed, tsig)
+	if err != nil {
+		return tsig, err
+	}
+
+	if hmac.Equal(mac, tsig.MAC) == false {
+		return tsig, TSIG_BADSIG
+	}
+
+	signedAt := int64(tsig.TimeSigned)
+	if diff := now.Unix() - signedAt; diff > int64(tsig.Fudge) || -diff > int64(tsig.Fudge) {
+		return tsig, TSIG_BADTIME
+	}
+	return tsig, nil
+}
@@ -0,0 +1,168 @@
+package g53
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53/util"
+)
+
+func TestTSIGRdataFromToWire(t *testing.T) {
+	alg, _ := NameFromString(TSIG_HMAC_SHA256)
+	tsig := &TSIG{
+		Algorithm:  alg,
+		TimeSigned: 0x010203040506,
+		Fudge:      300,
+		MAC:        []uint8{1, 2, 3, 4},
+		OriginalId: 1200,
+		Error:      0,
+	}
+
+	render := NewMsgRender()
+	render.WriteUint16(0)
+	tsig.Rend(render)
+	render.WriteUint16At(uint16(render.Len()-2), 0)
+
+	rdata, err := RdataFromWire(RR_TSIG, util.NewInputBuffer(render.Data()))
+	Assert(t, err == nil, "tsig from wire failed:%v", err)
+	Equal(t, rdata.String(), tsig.String())
+
+	fromStr, err := TSIGFromString(tsig.String())
+	Assert(t, err == nil, "tsig from string failed:%v", err)
+	Equal(t, fromStr.String(), tsig.String())
+}
+
+func TestTSIGSignVerify(t *testing.T) {
+	key, err := NewTSIGKey("key.example.com.", TSIG_HMAC_SHA256, "c2VjcmV0c2VjcmV0c2VjcmV0")
+	Assert(t, err == nil, "create key failed:%v", err)
+
+	zone, _ := NameFromString("example.com.")
+	query := MakeQuery(zone, RR_SOA, 1232, false)
+	query.Header.Id = 1234
+	now := time.Unix(1500000000, 0)
+	render := NewMsgRender()
+	Assert(t, key.Sign(query, render, nil, now) == nil, "sign should succeed")
+	wire := append([]byte{}, render.Data()...)
+
+	parsed, err := MessageFromWire(util.NewInputBuffer(wire))
+	Assert(t, err == nil, "signed message should be valid:%v", err)
+	Assert(t, parsed.Tsig != nil, "tsig should be parsed")
+	Assert(t, parsed.Edns != nil, "edns before tsig should be kept")
+	Equal(t, len(parsed.Sections[AdditionalSection]), 0)
+
+	tsig, err := key.Verify(wire, nil, now.Add(10*time.Second))
+	Assert(t, err == nil, "verify failed:%v", err)
+
+	_, err = key.Verify(wire, nil, now.Add(time.Hour))
+	Equal(t, err, TSIG_BADTIME)
+
+	wire[len(wire)-20] ^= 0xff
+	_, err = key.Verify(wire, nil, now)
+	Equal(t, err, TSIG_BADSIG)
+
+	other, _ := NewTSIGKey("other.example.com.", TSIG_HMAC_SHA256, "c2VjcmV0c2VjcmV0c2VjcmV0")
+	_, err = other.Verify(render.Data(), nil, now)
+	Equal(t, err, TSIG_BADKEY)
+
+	//response mac covers request mac
+	response := parsed.MakeResponse()
+	response.Tsig = nil
+	render = NewMsgRender()
+	key.Sign(response, render, tsig.MAC, now)
+	_, err = key.Verify(render.Data(), tsig.MAC, now)
+	Assert(t, err == nil, "verify response failed:%v", err)
+	_, err = key.Verify(render.Data(), nil, now)
+	Equal(t, err, TSIG_BADSIG)
+}
+
+func TestTSIGVerifyEmptyRdata(t *testing.T) {
+	key, _ := NewTSIGKey("key.example.com.", TSIG_HMAC_SHA256, "c2VjcmV0c2VjcmV0c2VjcmV0")
+	zone, _ := NameFromString("example.com.")
+	query := MakeQuery(zone, RR_SOA, 512, false)
+	query.Edns = nil
+	query.AddRRset(AdditionalSection, &RRset{Name: key.Name, Type: RR_TSIG, Class: CLASS_ANY})
+	render := NewMsgRender()
+	query.Rend(render)
+
+	_, err := key.Verify(render.Data(), nil, time.Now())
+	Equal(t, err, ErrEmptyRdata)
+}
+
+func TestTSIGNotLast(t *testing.T) {
+	key, _ := NewTSIGKey("key.example.com.", TSIG_HMAC_SHA256, "c2VjcmV0c2VjcmV0c2VjcmV0")
+	zone, _ := NameFromString("example.com.")
+	query := MakeQuery(zone, RR_SOA, 512, false)
+	render := NewMsgRender()
+	key.Sign(query, render, nil, time.Now())
+	wire := render.Data()
+	pos, _, _ := tsigPosition(wire)
+
+	//tsig appears twice
+	twice := append(append([]byte{}, wire...), wire[pos:]...)
+	twice[11] += 1
+	_, err := MessageFromWire(util.NewInputBuffer(twice))
+	Equal(t, err, ErrTSIGNotLast)
+	_, err = key.Verify(twice, nil, time.Now())
+	Equal(t, err, ErrTSIGNotLast)
+
+	//tsig followed by opt
+	query.Tsig = nil
+	render = NewMsgRender()
+	query.Rend(render)
+	optPos, _, _ := tsigPosition(render.Data())
+	reordered := append(append([]byte{}, wire[:optPos]...), wire[pos:]...)
+	reordered = append(reordered, wire[optPos:pos]...)
+	_, err = MessageFromWire(util.NewInputBuffer(reordered))
+	Equal(t, err, ErrTSIGNotLast)
+	_, err = key.Verify(reordered, nil, time.Now())
+	Equal(t, err, ErrTSIGNotLast)
+}
+
+func TestTSIGSignBadTime(t *testing.T) {
+	key, _ := NewTSIGKey("key.example.com.", TSIG_HMAC_SHA256, "c2VjcmV0c2VjcmV0c2VjcmV0")
+	zone, _ := NameFromString("example.com.")
+	query := MakeQuery(zone, RR_SOA, 1232, false)
+	signedAt := time.Unix(1500000000, 0)
+	render := NewMsgRender()
+	key.Sign(query, render, nil, signedAt)
+
+	now := signedAt.Add(time.Hour)
+	request, err := key.Verify(render.Data(), nil, now)
+	Equal(t, err, TSIG_BADTIME)
+
+	response := query.MakeResponse()
+	render = NewMsgRender()
+	Assert(t, key.SignBadTime(response, render, request, now) == nil, "sign should succeed")
+	tsig, err := key.Verify(render.Data(), request.MAC, signedAt)
+	Assert(t, err == nil, "verify failed:%v", err)
+	Equal(t, tsig.Error, uint16(TSIG_BADTIME))
+	Equal(t, tsig.TimeSigned, request.TimeSigned)
+	Equal(t, tsig.OtherData, []uint8{0, 0, 0x59, 0x68, 0x3d, 0x10})
+}
+
+func TestNotify(t *testing.T) {
+	zone, _ := NameFromString("example.com.")
+	soa, _ := SOAFromString("ns1.example.com. root.example.com. 2020 3600 900 86400")
+	notify := MakeNotify(zone, &RRset{
+		Name:   zone,
+		Type:   RR_SOA,
+		Class:  CLASS_IN,
+		Ttl:    3600,
+		Rdatas: []Rdata{soa},
+	})
+
+	render := NewMsgRender()
+	notify.Rend(render)
+	parsed, err := MessageFromWire(util.NewInputBuffer(render.Data()))
+	Assert(t, err == nil, "notify should be valid:%v", err)
+	Assert(t, ValidateNotify(parsed) == nil, "notify should pass validate")
+	serial, ok := NotifySerial(parsed)
+	Assert(t, ok && serial == 2020, "notify serial should be 2020")
+
+	response := parsed.MakeResponse()
+	Equal(t, response.Header.Opcode, Opcode(OP_NOTIFY))
+	Assert(t, ValidateNotify(response) != nil, "response isn't a valid notify")
+
+	parsed.Question.Type = RR_A
+	Assert(t, ValidateNotify(parsed) != nil, "notify question should be soa")
+}
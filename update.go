@@ -0,0 +1,100 @@
+package g53
+
+// sections of update message are reinterpreted as zone, prerequisite,
+// update and additional data section
+const (
+	PrerequisiteSection SectionType = AnswerSection
+	UpdateSection       SectionType = AuthSection
+)
+
+type UpdateBuilder struct {
+	msg *Message
+}
+
+// NewUpdateBuilder creates an update to zone, the zone section holds the
+// zone name with type SOA
+func NewUpdateBuilder(zone *Name) *UpdateBuilder {
+	h := &Header{
+		Opcode: OP_UPDATE,
+	}
+
+	return &UpdateBuilder{
+		msg: &Message{
+			Header: h,
+			Question: &Question{
+				Name:  zone,
+				Type:  RR_SOA,
+				Class: CLASS_IN,
+			},
+		},
+	}
+}
+
+func (b *UpdateBuilder) Message() *Message {
+	return b.msg
+}
+
+func (b *UpdateBuilder) addEmpty(st SectionType, name *Name, typ RRType, cls RRClass) {
+	b.msg.AddRRset(st, &RRset{
+		Name:  name,
+		Type:  typ,
+		Class: cls,
+		Ttl:   0,
+	})
+}
+
+func (b *UpdateBuilder) addWithClass(st SectionType, rrset *RRset, cls RRClass, ttl RRTTL) {
+	b.msg.AddRRset(st, &RRset{
+		Name:   rrset.Name,
+		Type:   rrset.Type,
+		Class:  cls,
+		Ttl:    ttl,
+		Rdatas: rrset.Rdatas,
+	})
+}
+
+// RRsetExists requires the rrset exists regardless of its value
+func (b *UpdateBuilder) RRsetExists(name *Name, typ RRType) {
+	b.addEmpty(PrerequisiteSection, name, typ, CLASS_ANY)
+}
+
+// RRsetExistsValue requires the rrset exists and has exactly the rdatas
+func (b *UpdateBuilder) RRsetExistsValue(rrset *RRset) {
+	b.addWithClass(PrerequisiteSection, rrset, b.msg.Question.Class, 0)
+}
+
+func (b *UpdateBuilder) RRsetNotExists(name *Name, typ RRType) {
+	b.addEmpty(PrerequisiteSection, name, typ, CLASS_NONE)
+}
+
+// NameInUse requires the name owns at least one rr
+func (b *UpdateBuilder) NameInUse(name *Name) {
+	b.addEmpty(PrerequisiteSection, name, RR_ANY, CLASS_ANY)
+}
+
+func (b *UpdateBuilder) NameNotInUse(name *Name) {
+	b.addEmpty(PrerequisiteSection, name, RR_ANY, CLASS_NONE)
+}
+
+// Add adds rdatas to the rrset, the ttl of the rrset is replaced
+func (b *UpdateBuilder) Add(rrset *RRset) {
+	b.addWithClass(UpdateSection, rrset, b.msg.Question.Class, rrset.Ttl)
+}
+
+// Delete deletes the rdatas from the rrset
+func (b *UpdateBuilder) Delete(rrset *RRset) {
+	b.addWithClass(UpdateSection, rrset, CLASS_NONE, 0)
+}
+
+func (b *UpdateBuilder) DeleteRRset(name *Name, typ RRType) {
+	b.addEmpty(UpdateSection, name, typ, CLASS_ANY)
+}
+
+// DeleteName deletes all the rrsets owned by name
+func (b *UpdateBuilder) DeleteName(name *Name) {
+	b.addEmpty(UpdateSection, name, RR_ANY, CLASS_ANY)
+}
+
+func (b *UpdateBuilder) AddAdditional(rrset *RRset) {
+	b.msg.AddRRset(AdditionalSection, rrset)
+}
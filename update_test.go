@@ -0,0 +1,78 @@
+package g53
+
+import (
+	"testing"
+
+	"github.com/mistletoeChao/g53/util"
+)
+
+func TestUpdateBuilder(t *testing.T) {
+	zone, _ := NameFromString("example.com.")
+	www, _ := NameFromString("www.example.com.")
+	mail, _ := NameFromString("mail.example.com.")
+	a1, _ := AFromString("1.1.1.1")
+	a2, _ := AFromString("2.2.2.2")
+
+	b := NewUpdateBuilder(zone)
+	b.RRsetExists(www, RR_A)
+	b.NameNotInUse(mail)
+	b.Delete(&RRset{Name: www, Type: RR_A, Class: CLASS_IN, Ttl: 300, Rdatas: []Rdata{a1}})
+	b.Add(&RRset{Name: www, Type: RR_A, Class: CLASS_IN, Ttl: 300, Rdatas: []Rdata{a2}})
+	b.DeleteName(mail)
+	msg := b.Message()
+	msg.Header.Id = 100
+
+	render := NewMsgRender()
+	msg.Rend(render)
+	Equal(t, msg.Header.ANCount, uint16(2))
+	Equal(t, msg.Header.NSCount, uint16(3))
+
+	parsed, err := MessageFromWire(util.NewInputBuffer(render.Data()))
+	Assert(t, err == nil, "update should be parsed:%v", err)
+	Equal(t, parsed.Header.Opcode, Opcode(OP_UPDATE))
+	Equal(t, parsed.Question.Type, RRType(RR_SOA))
+
+	prereqs := parsed.Sections[PrerequisiteSection]
+	Equal(t, len(prereqs), 2)
+	Equal(t, prereqs[0].Class, RRClass(CLASS_ANY))
+	Equal(t, len(prereqs[0].Rdatas), 0)
+	Equal(t, prereqs[1].Class, RRClass(CLASS_NONE))
+	Equal(t, prereqs[1].Type, RRType(RR_ANY))
+
+	//delete and add of same rrset shouldn't be merged
+	updates := parsed.Sections[UpdateSection]
+	Equal(t, len(updates), 3)
+	Equal(t, updates[0].Class, RRClass(CLASS_NONE))
+	Equal(t, updates[0].Ttl, RRTTL(0))
+	Equal(t, updates[0].Rdatas[0].String(), "1.1.1.1")
+	Equal(t, updates[1].Class, RRClass(CLASS_IN))
+	Equal(t, updates[1].Ttl, RRTTL(300))
+	Equal(t, updates[2].Class, RRClass(CLASS_ANY))
+	Equal(t, updates[2].RrCount(), 1)
+
+	render2 := NewMsgRender()
+	parsed.Rend(render2)
+	WireMatch(t, render.Data(), render2.Data())
+}
+
+func TestEmptyRdataOnlyInUpdate(t *testing.T) {
+	zone, _ := NameFromString("example.com.")
+	empty := &RRset{Name: zone, Type: RR_A, Class: CLASS_ANY}
+
+	b := NewUpdateBuilder(zone)
+	b.DeleteRRset(zone, RR_A)
+	msg := b.Message()
+	msg.AddRRset(AdditionalSection, empty)
+	render := NewMsgRender()
+	msg.Rend(render)
+	_, err := MessageFromWire(util.NewInputBuffer(render.Data()))
+	Equal(t, err, ErrEmptyRdata)
+
+	msg = MakeQuery(zone, RR_A, 512, false)
+	msg.Header.SetFlag(FLAG_QR, true)
+	msg.AddRRset(AnswerSection, empty)
+	render = NewMsgRender()
+	msg.Rend(render)
+	_, err = MessageFromWire(util.NewInputBuffer(render.Data()))
+	Equal(t, err, ErrEmptyRdata)
+}
@@ -0,0 +1,353 @@
+package xfr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+	"github.com/mistletoeChao/g53/util"
+)
+
+const DefaultMessageSize = 16384
+
+// AXFRStream reads a zone transfer from a tcp connection, the opening soa is
+// returned first and the closing soa is consumed silently
+type AXFRStream struct {
+	conn     io.ReadWriter
+	zone     *g53.Name
+	id       uint16
+	soa      *g53.SOA
+	pending  []*g53.RRset
+	msgCount int
+	done     bool
+}
+
+func MakeAXFRQuery(zone *g53.Name) *g53.Message {
+	h := &g53.Header{
+		Id:     uint16(rand.Uint32()),
+		Opcode: g53.OP_QUERY,
+	}
+	return &g53.Message{
+		Header: h,
+		Question: &g53.Question{
+			Name:  zone,
+			Type:  g53.RR_AXFR,
+			Class: g53.CLASS_IN,
+		},
+	}
+}
+
+func NewAXFRStream(conn io.ReadWriter, zone *g53.Name) (*AXFRStream, error) {
+	query := MakeAXFRQuery(zone)
+	render := g53.NewMsgRender()
+	query.Rend(render)
+	if err := client.WriteTCPMessage(conn, render.Data()); err != nil {
+		return nil, err
+	}
+
+	return &AXFRStream{
+		conn: conn,
+		zone: zone,
+		id:   query.Header.Id,
+	}, nil
+}
+
+// SOA returns the opening soa, it's nil before the first rrset is read
+func (s *AXFRStream) SOA() *g53.SOA {
+	return s.soa
+}
+
+// Next returns io.EOF once the closing soa has been read
+func (s *AXFRStream) Next() (*g53.RRset, error) {
+	for len(s.pending) == 0 {
+		if s.done {
+			return nil, io.EOF
+		}
+
+		if err := s.readMessage(); err != nil {
+			return nil, err
+		}
+	}
+
+	rrset := s.pending[0]
+	s.pending = s.pending[1:]
+	return rrset, nil
+}
+
+func (s *AXFRStream) readMessage() error {
+	data, err := client.ReadTCPMessage(s.conn)
+	if err != nil {
+		if err == io.EOF {
+			return errors.New("zone transfer ends without closing soa")
+		}
+		return err
+	}
+
+	msg, err := g53.MessageFromWire(util.NewInputBuffer(data))
+	if err != nil {
+		return err
+	}
+
+	if err := s.validateHeader(msg); err != nil {
+		return err
+	}
+	s.msgCount += 1
+
+	for _, rrset := range msg.Sections[g53.AnswerSection] {
+		if s.done {
+			return errors.New("data after closing soa")
+		}
+
+		isApexSOA := rrset.Type == g53.RR_SOA && rrset.Name.Equals(s.zone)
+		if s.soa == nil {
+			if isApexSOA == false {
+				return fmt.Errorf("zone transfer starts with %s instead of soa", rrset.Type.String())
+			}
+			s.soa = rrset.Rdatas[0].(*g53.SOA)
+			s.pending = append(s.pending, firstRR(rrset))
+
+			//empty zone, opening and closing soa are merged into one rrset
+			if len(rrset.Rdatas) > 1 {
+				if err := s.closeWith(rrset.Rdatas[1].(*g53.SOA)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if isApexSOA {
+			if err := s.closeWith(rrset.Rdatas[0].(*g53.SOA)); err != nil {
+				return err
+			}
+		} else {
+			s.pending = append(s.pending, rrset)
+		}
+	}
+
+	if s.soa == nil {
+		return errors.New("zone transfer response has no soa")
+	}
+	return nil
+}
+
+func (s *AXFRStream) validateHeader(msg *g53.Message) error {
+	return validateResponse(msg, s.id, s.zone, g53.RR_AXFR, s.msgCount == 0)
+}
+
+func (s *AXFRStream) closeWith(soa *g53.SOA) error {
+	if soa.Serial != s.soa.Serial {
+		return fmt.Errorf("closing soa serial %d differs from opening serial %d", soa.Serial, s.soa.Serial)
+	}
+	s.done = true
+	return nil
+}
+
+// AXFR transfers the whole zone from server which is in host:port format
+func AXFR(server string, zone *g53.Name, timeout time.Duration) ([]*g53.RRset, error) {
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	stream, err := NewAXFRStream(conn, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	var rrsets []*g53.RRset
+	for {
+		rrset, err := stream.Next()
+		if err == io.EOF {
+			return rrsets, nil
+		} else if err != nil {
+			return nil, err
+		}
+		rrsets = append(rrsets, rrset)
+	}
+}
+
+// only the first message of a transfer is required to carry the question
+func validateResponse(msg *g53.Message, id uint16, zone *g53.Name, typ g53.RRType, first bool) error {
+	if msg.Header.Id != id {
+		return fmt.Errorf("response id %d doesn't match query id %d", msg.Header.Id, id)
+	}
+
+	if msg.Header.GetFlag(g53.FLAG_QR) == false {
+		return errors.New("message isn't a response")
+	}
+
+	if msg.Header.Rcode != g53.R_NOERROR {
+		return fmt.Errorf("zone transfer failed with %s", msg.Header.Rcode.String())
+	}
+
+	if first && msg.Question != nil {
+		if msg.Question.Type != typ || msg.Question.Name.Equals(zone) == false {
+			return errors.New("response question doesn't match query")
+		}
+	}
+	return nil
+}
+
+func findApexSOA(zone *g53.Name, rrsets []*g53.RRset) *g53.RRset {
+	for _, rrset := range rrsets {
+		if rrset.Type == g53.RR_SOA && rrset.Name.Equals(zone) {
+			return rrset
+		}
+	}
+	return nil
+}
+
+// the rendered rdata plus owner name and fixed fields is never less
+// than what it takes inside a message with more compression targets
+func rrUpperBound(name *g53.Name, rdata g53.Rdata) int {
+	render := g53.NewMsgRender()
+	rdata.Rend(render)
+	return int(name.Length()) + 10 + int(render.Len())
+}
+
+type messageSplitter struct {
+	query     *g53.Message
+	sizeLimit int
+	render    *g53.MsgRender
+	current   *g53.Message
+	messages  []*g53.Message
+}
+
+func (s *messageSplitter) startMessage() {
+	msg := s.query.MakeResponse()
+	msg.Header.SetFlag(g53.FLAG_AA, true)
+	if len(s.messages) > 0 {
+		msg.Question = nil
+	}
+
+	s.render.Clear()
+	msg.Rend(s.render)
+	s.current = msg
+	s.messages = append(s.messages, msg)
+}
+
+// the first message always carries at least two rrs, otherwise a lone soa
+// would be taken as an up to date ixfr response
+func (s *messageSplitter) isFull(name *g53.Name, rdata g53.Rdata) bool {
+	rrCount := 0
+	for _, rrset := range s.current.Sections[g53.AnswerSection] {
+		rrCount += rrset.RrCount()
+	}
+
+	if rrCount == 0 || (len(s.messages) == 1 && rrCount == 1) {
+		return false
+	}
+	return int(s.render.Len())+rrUpperBound(name, rdata) > s.sizeLimit
+}
+
+func (s *messageSplitter) add(rrset *g53.RRset) {
+	for _, rdata := range rrset.Rdatas {
+		if s.isFull(rrset.Name, rdata) {
+			s.startMessage()
+		}
+		section := s.current.Sections[g53.AnswerSection]
+
+		rr := &g53.RRset{
+			Name:   rrset.Name,
+			Type:   rrset.Type,
+			Class:  rrset.Class,
+			Ttl:    rrset.Ttl,
+			Rdatas: []g53.Rdata{rdata},
+		}
+		rr.Rend(s.render)
+
+		if last := len(section) - 1; last >= 0 && isSameRR(section[last], rr) {
+			section[last].AddRdata(rdata)
+		} else {
+			s.current.AddRRset(g53.AnswerSection, rr)
+		}
+	}
+}
+
+func isSameRR(rrset, other *g53.RRset) bool {
+	return rrset.IsSameRrset(other) && rrset.Class == other.Class && rrset.Ttl == other.Ttl
+}
+
+// AXFRResponses splits the zone into answer messages which are no larger
+// than sizeLimit, each message is compressed on its own
+func AXFRResponses(query *g53.Message, rrsets []*g53.RRset, sizeLimit int) ([]*g53.Message, error) {
+	if query.Question == nil {
+		return nil, errors.New("axfr query has no question")
+	}
+
+	zone := query.Question.Name
+	soa := findApexSOA(zone, rrsets)
+	if soa == nil {
+		return nil, fmt.Errorf("zone %s has no soa", zone.String(false))
+	}
+	soa = firstRR(soa)
+
+	sequence := []*g53.RRset{soa}
+	for _, rrset := range rrsets {
+		if rrset.Type != g53.RR_SOA || rrset.Name.Equals(zone) == false {
+			sequence = append(sequence, rrset)
+		}
+	}
+	sequence = append(sequence, soa)
+	return splitMessages(query, sequence, sizeLimit), nil
+}
+
+func firstRR(rrset *g53.RRset) *g53.RRset {
+	return &g53.RRset{
+		Name:   rrset.Name,
+		Type:   rrset.Type,
+		Class:  rrset.Class,
+		Ttl:    rrset.Ttl,
+		Rdatas: rrset.Rdatas[0:1],
+	}
+}
+
+func splitMessages(query *g53.Message, sequence []*g53.RRset, sizeLimit int) []*g53.Message {
+	if sizeLimit <= 0 {
+		sizeLimit = DefaultMessageSize
+	} else if sizeLimit > client.MaxTCPMessageLen {
+		sizeLimit = client.MaxTCPMessageLen
+	}
+
+	s := &messageSplitter{
+		query:     query,
+		sizeLimit: sizeLimit,
+		render:    g53.NewMsgRender(),
+	}
+	s.startMessage()
+	for _, rrset := range sequence {
+		s.add(rrset)
+	}
+	return s.messages
+}
+
+func writeMessages(w io.Writer, messages []*g53.Message) error {
+	render := g53.NewMsgRender()
+	for _, msg := range messages {
+		render.Clear()
+		msg.Rend(render)
+		if err := client.WriteTCPMessage(w, render.Data()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAXFR sends the zone as length prefixed messages
+func WriteAXFR(w io.Writer, query *g53.Message, rrsets []*g53.RRset, sizeLimit int) error {
+	messages, err := AXFRResponses(query, rrsets, sizeLimit)
+	if err != nil {
+		return err
+	}
+	return writeMessages(w, messages)
+}
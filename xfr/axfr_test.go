@@ -0,0 +1,185 @@
+package xfr
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+	"github.com/mistletoeChao/g53/util"
+)
+
+func buildZone(hostCount int) []*g53.RRset {
+	rrsets := []*g53.RRset{
+		g53.BuildRRset("example.com.", g53.RR_SOA, 3600, "ns1.example.com. root.example.com. 2019 3600 900 86400"),
+		g53.BuildRRset("example.com.", g53.RR_NS, 3600, "ns1.example.com.", "ns2.example.com."),
+	}
+	for i := 0; i < hostCount; i++ {
+		rrsets = append(rrsets, g53.BuildRRset(fmt.Sprintf("host%d.example.com.", i), g53.RR_A, 3600, fmt.Sprintf("10.0.%d.%d", i/256, i%256)))
+	}
+	return rrsets
+}
+
+func rrCount(rrsets []*g53.RRset) int {
+	count := 0
+	for _, rrset := range rrsets {
+		count += rrset.RrCount()
+	}
+	return count
+}
+
+func TestAXFRResponsesSplit(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	rrsets := buildZone(100)
+	messages, err := AXFRResponses(MakeAXFRQuery(zone), rrsets, 512)
+	g53.Assert(t, err == nil, "generate axfr failed:%v", err)
+	g53.Assert(t, len(messages) > 1, "zone should be split into several messages")
+
+	total := 0
+	for i, msg := range messages {
+		render := g53.NewMsgRender()
+		msg.Rend(render)
+		g53.Assert(t, render.Len() <= 512, "message %d is %d bytes", i, render.Len())
+
+		parsed, err := g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+		g53.Assert(t, err == nil, "rendered message should be valid:%v", err)
+		g53.Equal(t, parsed.Question != nil, i == 0)
+		g53.Assert(t, parsed.Header.GetFlag(g53.FLAG_AA), "axfr answer should be authoritative")
+		total += rrCount(parsed.Sections[g53.AnswerSection])
+	}
+	g53.Equal(t, total, rrCount(rrsets)+1)
+
+	first := messages[0].Sections[g53.AnswerSection][0]
+	last := messages[len(messages)-1].Sections[g53.AnswerSection]
+	g53.Equal(t, first.Type, g53.RRType(g53.RR_SOA))
+	g53.Equal(t, last[len(last)-1].Type, g53.RRType(g53.RR_SOA))
+}
+
+func serveAXFR(t *testing.T, rrsets []*g53.RRset) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed:%v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			data, err := client.ReadTCPMessage(conn)
+			if err == nil {
+				query, err := g53.MessageFromWire(util.NewInputBuffer(data))
+				if err == nil {
+					WriteAXFR(conn, query, rrsets, 1024)
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestAXFRTransfer(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	rrsets := buildZone(300)
+	addr, stop := serveAXFR(t, rrsets)
+	defer stop()
+
+	transfered, err := AXFR(addr, zone, 3*time.Second)
+	g53.Assert(t, err == nil, "axfr failed:%v", err)
+	g53.Equal(t, transfered[0].Type, g53.RRType(g53.RR_SOA))
+	g53.Equal(t, rrCount(transfered), rrCount(rrsets))
+	for _, rrset := range transfered[1:] {
+		g53.Nequal(t, rrset.Type, g53.RRType(g53.RR_SOA))
+	}
+}
+
+func TestAXFREmptyZone(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	addr, stop := serveAXFR(t, buildZone(0)[0:1])
+	defer stop()
+
+	transfered, err := AXFR(addr, zone, 3*time.Second)
+	g53.Assert(t, err == nil, "axfr failed:%v", err)
+	g53.Equal(t, len(transfered), 1)
+	g53.Equal(t, transfered[0].RrCount(), 1)
+}
+
+func TestAXFRMismatchedClosingSOA(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	conn, server := net.Pipe()
+	defer conn.Close()
+
+	go func() {
+		defer server.Close()
+		data, err := client.ReadTCPMessage(server)
+		if err != nil {
+			return
+		}
+		query, _ := g53.MessageFromWire(util.NewInputBuffer(data))
+		messages, _ := AXFRResponses(query, buildZone(10), 0)
+		answer := messages[len(messages)-1].Sections[g53.AnswerSection]
+		closing := answer[len(answer)-1]
+		soa := *(closing.Rdatas[0].(*g53.SOA))
+		soa.Serial += 1
+		closing.Rdatas = []g53.Rdata{&soa}
+
+		render := g53.NewMsgRender()
+		for _, msg := range messages {
+			render.Clear()
+			msg.Rend(render)
+			client.WriteTCPMessage(server, render.Data())
+		}
+	}()
+
+	stream, err := NewAXFRStream(conn, zone)
+	g53.Assert(t, err == nil, "send axfr query failed:%v", err)
+	for {
+		_, err = stream.Next()
+		if err != nil {
+			break
+		}
+	}
+	g53.Assert(t, err != io.EOF, "mismatched closing soa should be reported")
+	g53.Equal(t, stream.SOA().Serial, uint32(2019))
+}
+
+// servePipe answers the transfer query read from the returned conn with
+// messages built by respond
+func servePipe(respond func(*g53.Message) []*g53.Message) net.Conn {
+	conn, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		data, err := client.ReadTCPMessage(server)
+		if err != nil {
+			return
+		}
+		query, _ := g53.MessageFromWire(util.NewInputBuffer(data))
+		render := g53.NewMsgRender()
+		for _, msg := range respond(query) {
+			render.Clear()
+			msg.Rend(render)
+			client.WriteTCPMessage(server, render.Data())
+		}
+	}()
+	return conn
+}
+
+func TestAXFREmptyRdata(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	conn := servePipe(func(query *g53.Message) []*g53.Message {
+		messages, _ := AXFRResponses(query, buildZone(10), 0)
+		messages[0].Sections[g53.AnswerSection][0] = &g53.RRset{Name: zone, Type: g53.RR_SOA, Class: g53.CLASS_ANY}
+		return messages
+	})
+	defer conn.Close()
+
+	stream, err := NewAXFRStream(conn, zone)
+	g53.Assert(t, err == nil, "send axfr query failed:%v", err)
+	_, err = stream.Next()
+	g53.Equal(t, err, g53.ErrEmptyRdata)
+}
@@ -0,0 +1,249 @@
+package xfr
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mistletoeChao/g53"
+)
+
+// Diff is the change between two versions of a zone, old and new soa are
+// kept as rrsets so they could be sent out as they are
+type Diff struct {
+	OldSOA  *g53.RRset
+	NewSOA  *g53.RRset
+	Deleted []*g53.RRset
+	Added   []*g53.RRset
+}
+
+func soaSerial(rrset *g53.RRset) uint32 {
+	return rrset.Rdatas[0].(*g53.SOA).Serial
+}
+
+func (d *Diff) FromSerial() uint32 {
+	return soaSerial(d.OldSOA)
+}
+
+func (d *Diff) ToSerial() uint32 {
+	return soaSerial(d.NewSOA)
+}
+
+// ttl is part of the key, so a ttl only change is kept as a delete of the
+// old rr plus an add of the new one instead of cancelling out
+func rrKey(rr *g53.RRset) string {
+	return strings.Join([]string{
+		strings.ToLower(rr.Name.String(false)),
+		rr.Type.String(),
+		rr.Class.String(),
+		strconv.FormatUint(uint64(rr.Ttl), 10),
+		string(rdataWire(rr.Rdatas[0]))}, "/")
+}
+
+func rdataWire(rdata g53.Rdata) []byte {
+	render := g53.NewMsgRender()
+	rdata.Rend(render)
+	return render.Data()
+}
+
+// split rrsets into single rr rrsets
+func flatten(rrsets []*g53.RRset) []*g53.RRset {
+	var rrs []*g53.RRset
+	for _, rrset := range rrsets {
+		for _, rdata := range rrset.Rdatas {
+			rrs = append(rrs, &g53.RRset{
+				Name:   rrset.Name,
+				Type:   rrset.Type,
+				Class:  rrset.Class,
+				Ttl:    rrset.Ttl,
+				Rdatas: []g53.Rdata{rdata},
+			})
+		}
+	}
+	return rrs
+}
+
+// merge rr into the rrset with the same name, type and class
+func mergeRR(rrsets []*g53.RRset, rr *g53.RRset) []*g53.RRset {
+	for _, rrset := range rrsets {
+		if rrset.IsSameRrset(rr) && rrset.Class == rr.Class {
+			rrset.AddRdata(rr.Rdatas[0])
+			return rrsets
+		}
+	}
+	return append(rrsets, &g53.RRset{
+		Name:   rr.Name,
+		Type:   rr.Type,
+		Class:  rr.Class,
+		Ttl:    rr.Ttl,
+		Rdatas: []g53.Rdata{rr.Rdatas[0]},
+	})
+}
+
+func mergeRRs(rrs []*g53.RRset) []*g53.RRset {
+	var rrsets []*g53.RRset
+	for _, rr := range rrs {
+		rrsets = mergeRR(rrsets, rr)
+	}
+	return rrsets
+}
+
+// rrList keeps rrs in insertion order and supports removal by rr
+type rrList struct {
+	keys []string
+	rrs  map[string]*g53.RRset
+}
+
+func newRRList() *rrList {
+	return &rrList{rrs: make(map[string]*g53.RRset)}
+}
+
+func (l *rrList) add(rr *g53.RRset) {
+	key := rrKey(rr)
+	if _, ok := l.rrs[key]; ok == false {
+		l.keys = append(l.keys, key)
+	}
+	l.rrs[key] = rr
+}
+
+func (l *rrList) remove(rr *g53.RRset) bool {
+	key := rrKey(rr)
+	if _, ok := l.rrs[key]; ok == false {
+		return false
+	}
+	delete(l.rrs, key)
+	return true
+}
+
+func (l *rrList) rrsets() []*g53.RRset {
+	var rrs []*g53.RRset
+	seen := make(map[string]bool)
+	for _, key := range l.keys {
+		if rr, ok := l.rrs[key]; ok && seen[key] == false {
+			rrs = append(rrs, rr)
+			seen[key] = true
+		}
+	}
+	return mergeRRs(rrs)
+}
+
+// Condense merges consecutive diffs into one diff, an rr which is added and
+// deleted later (or the other way around) disappears from the result
+func Condense(diffs []*Diff) (*Diff, error) {
+	if len(diffs) == 0 {
+		return nil, errors.New("no diff to condense")
+	}
+
+	deleted := newRRList()
+	added := newRRList()
+	for i, diff := range diffs {
+		if i > 0 && diff.FromSerial() != diffs[i-1].ToSerial() {
+			return nil, fmt.Errorf("diff from %d doesn't follow diff to %d", diff.FromSerial(), diffs[i-1].ToSerial())
+		}
+
+		for _, rr := range flatten(diff.Deleted) {
+			if added.remove(rr) == false {
+				deleted.add(rr)
+			}
+		}
+		for _, rr := range flatten(diff.Added) {
+			if deleted.remove(rr) == false {
+				added.add(rr)
+			}
+		}
+	}
+
+	return &Diff{
+		OldSOA:  diffs[0].OldSOA,
+		NewSOA:  diffs[len(diffs)-1].NewSOA,
+		Deleted: deleted.rrsets(),
+		Added:   added.rrsets(),
+	}, nil
+}
+
+// Apply returns a new version of the zone, the old soa in the zone has to
+// match the diff and every deleted rr has to exist
+func (d *Diff) Apply(rrsets []*g53.RRset) ([]*g53.RRset, error) {
+	zone := d.NewSOA.Name
+	soa := findApexSOA(zone, rrsets)
+	if soa == nil || soaSerial(soa) != d.FromSerial() {
+		return nil, fmt.Errorf("zone isn't at serial %d", d.FromSerial())
+	}
+
+	current := newRRList()
+	for _, rr := range flatten(rrsets) {
+		if rr.Type != g53.RR_SOA || rr.Name.Equals(zone) == false {
+			current.add(rr)
+		}
+	}
+
+	for _, rr := range flatten(d.Deleted) {
+		if current.remove(rr) == false {
+			return nil, fmt.Errorf("deleted rr %s doesn't exist", strings.TrimSpace(rr.String()))
+		}
+	}
+	for _, rr := range flatten(d.Added) {
+		current.add(rr)
+	}
+
+	return append([]*g53.RRset{firstRR(d.NewSOA)}, current.rrsets()...), nil
+}
+
+// Journal keeps a continuous sequence of diffs of one zone
+type Journal struct {
+	lock     sync.RWMutex
+	diffs    []*Diff
+	maxDiffs int
+}
+
+// NewJournal creates a journal which keeps at most maxDiffs diffs, zero
+// means no limit
+func NewJournal(maxDiffs int) *Journal {
+	return &Journal{
+		maxDiffs: maxDiffs,
+	}
+}
+
+func (j *Journal) Append(diff *Diff) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if len(j.diffs) > 0 {
+		last := j.diffs[len(j.diffs)-1]
+		if last.ToSerial() != diff.FromSerial() {
+			return fmt.Errorf("diff from %d doesn't follow journal serial %d", diff.FromSerial(), last.ToSerial())
+		}
+	}
+
+	j.diffs = append(j.diffs, diff)
+	if j.maxDiffs > 0 && len(j.diffs) > j.maxDiffs {
+		j.diffs = j.diffs[len(j.diffs)-j.maxDiffs:]
+	}
+	return nil
+}
+
+func (j *Journal) Len() int {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return len(j.diffs)
+}
+
+// DiffsSince returns the diffs which bring serial to the latest version,
+// false is returned if serial is no longer or not yet in the journal
+func (j *Journal) DiffsSince(serial uint32) ([]*Diff, bool) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	for i, diff := range j.diffs {
+		if diff.FromSerial() == serial {
+			return append([]*Diff{}, j.diffs[i:]...), true
+		}
+	}
+
+	if len(j.diffs) > 0 && j.diffs[len(j.diffs)-1].ToSerial() == serial {
+		return nil, true
+	}
+	return nil, false
+}
@@ -0,0 +1,341 @@
+package xfr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+	"github.com/mistletoeChao/g53/util"
+)
+
+// IXFRResult is the outcome of an incremental transfer, Zone is set when
+// the server falls back to a full transfer, Diffs is empty when the client
+// is already up to date
+type IXFRResult struct {
+	SOA   *g53.RRset
+	Diffs []*Diff
+	Zone  []*g53.RRset
+}
+
+func (r *IXFRResult) IsFull() bool {
+	return r.Zone != nil
+}
+
+func (r *IXFRResult) IsUpToDate() bool {
+	return r.Zone == nil && len(r.Diffs) == 0
+}
+
+// MakeIXFRQuery carries the soa the client holds in authority section
+func MakeIXFRQuery(zone *g53.Name, soa *g53.RRset) *g53.Message {
+	h := &g53.Header{
+		Id:     uint16(rand.Uint32()),
+		Opcode: g53.OP_QUERY,
+	}
+	msg := &g53.Message{
+		Header: h,
+		Question: &g53.Question{
+			Name:  zone,
+			Type:  g53.RR_IXFR,
+			Class: g53.CLASS_IN,
+		},
+	}
+	msg.AddRRset(g53.AuthSection, firstRR(soa))
+	return msg
+}
+
+type ixfrState int
+
+const (
+	ixfrInit ixfrState = iota
+	ixfrFirstSOA
+	ixfrDeleting
+	ixfrAdding
+	ixfrFull
+	ixfrDone
+)
+
+// IXFRParser turns the answer section of ixfr responses into diffs, both
+// incremental and axfr style responses are accepted
+type IXFRParser struct {
+	zone    *g53.Name
+	state   ixfrState
+	soa     *g53.RRset
+	current *Diff
+	diffs   []*Diff
+	zoneRRs []*g53.RRset
+}
+
+func NewIXFRParser(zone *g53.Name) *IXFRParser {
+	return &IXFRParser{
+		zone: zone,
+	}
+}
+
+func (p *IXFRParser) Done() bool {
+	return p.state == ixfrDone
+}
+
+// Feed consumes the answer section of one response message
+func (p *IXFRParser) Feed(msg *g53.Message) error {
+	for _, rr := range flatten(msg.Sections[g53.AnswerSection]) {
+		if err := p.feedRR(rr); err != nil {
+			return err
+		}
+	}
+
+	//a response with only the server soa means the client is up to date
+	if p.state == ixfrFirstSOA {
+		p.state = ixfrDone
+	}
+	return nil
+}
+
+func (p *IXFRParser) isApexSOA(rr *g53.RRset) bool {
+	return rr.Type == g53.RR_SOA && rr.Name.Equals(p.zone)
+}
+
+func (p *IXFRParser) feedRR(rr *g53.RRset) error {
+	isSOA := p.isApexSOA(rr)
+	switch p.state {
+	case ixfrInit:
+		if isSOA == false {
+			return fmt.Errorf("ixfr response starts with %s instead of soa", rr.Type.String())
+		}
+		p.soa = rr
+		p.state = ixfrFirstSOA
+
+	case ixfrFirstSOA:
+		if isSOA {
+			if soaSerial(rr) == soaSerial(p.soa) {
+				//axfr of a zone which only has soa
+				p.state = ixfrDone
+			} else {
+				p.current = &Diff{OldSOA: rr}
+				p.state = ixfrDeleting
+			}
+		} else {
+			p.zoneRRs = []*g53.RRset{rr}
+			p.state = ixfrFull
+		}
+
+	case ixfrDeleting:
+		if isSOA {
+			p.current.NewSOA = rr
+			p.state = ixfrAdding
+		} else {
+			p.current.Deleted = mergeRR(p.current.Deleted, rr)
+		}
+
+	case ixfrAdding:
+		if isSOA == false {
+			p.current.Added = mergeRR(p.current.Added, rr)
+			break
+		}
+
+		p.diffs = append(p.diffs, p.current)
+		if soaSerial(rr) == soaSerial(p.soa) && p.current.ToSerial() == soaSerial(p.soa) {
+			p.current = nil
+			p.state = ixfrDone
+		} else if soaSerial(rr) != p.current.ToSerial() {
+			return fmt.Errorf("diff starts from %d but previous diff ends at %d", soaSerial(rr), p.current.ToSerial())
+		} else {
+			p.current = &Diff{OldSOA: rr}
+			p.state = ixfrDeleting
+		}
+
+	case ixfrFull:
+		if isSOA {
+			if soaSerial(rr) != soaSerial(p.soa) {
+				return fmt.Errorf("closing soa serial %d differs from opening serial %d", soaSerial(rr), soaSerial(p.soa))
+			}
+			p.state = ixfrDone
+		} else {
+			p.zoneRRs = append(p.zoneRRs, rr)
+		}
+
+	case ixfrDone:
+		return errors.New("data after closing soa")
+	}
+	return nil
+}
+
+func (p *IXFRParser) Result() (*IXFRResult, error) {
+	if p.state != ixfrDone {
+		return nil, errors.New("ixfr response is incomplete")
+	}
+
+	result := &IXFRResult{
+		SOA:   p.soa,
+		Diffs: p.diffs,
+	}
+	if p.zoneRRs != nil {
+		result.Zone = append([]*g53.RRset{p.soa}, mergeRRs(p.zoneRRs)...)
+	}
+	return result, nil
+}
+
+// ReadIXFR sends the ixfr query through a tcp connection and reads until
+// the transfer completes
+func ReadIXFR(conn io.ReadWriter, zone *g53.Name, soa *g53.RRset) (*IXFRResult, error) {
+	query := MakeIXFRQuery(zone, soa)
+	render := g53.NewMsgRender()
+	query.Rend(render)
+	if err := client.WriteTCPMessage(conn, render.Data()); err != nil {
+		return nil, err
+	}
+
+	parser := NewIXFRParser(zone)
+	for first := true; parser.Done() == false; first = false {
+		data, err := client.ReadTCPMessage(conn)
+		if err != nil {
+			if err == io.EOF {
+				return nil, errors.New("ixfr ends without closing soa")
+			}
+			return nil, err
+		}
+
+		msg, err := g53.MessageFromWire(util.NewInputBuffer(data))
+		if err != nil {
+			return nil, err
+		}
+
+		if err := validateResponse(msg, query.Header.Id, zone, g53.RR_IXFR, first); err != nil {
+			return nil, err
+		}
+
+		if err := parser.Feed(msg); err != nil {
+			return nil, err
+		}
+	}
+	return parser.Result()
+}
+
+// IXFR asks server for the changes since the version of soa
+func IXFR(server string, zone *g53.Name, soa *g53.RRset, timeout time.Duration) (*IXFRResult, error) {
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	return ReadIXFR(conn, zone, soa)
+}
+
+func clientSOA(query *g53.Message) (*g53.RRset, error) {
+	if query.Question == nil {
+		return nil, errors.New("ixfr query has no question")
+	}
+
+	for _, rrset := range query.Sections[g53.AuthSection] {
+		if rrset.Type == g53.RR_SOA && rrset.Name.Equals(query.Question.Name) && len(rrset.Rdatas) > 0 {
+			return rrset, nil
+		}
+	}
+	return nil, errors.New("ixfr query has no soa in authority section")
+}
+
+func diffSequence(soa *g53.RRset, diffs []*Diff) []*g53.RRset {
+	sequence := []*g53.RRset{soa}
+	for _, diff := range diffs {
+		sequence = append(sequence, firstRR(diff.OldSOA))
+		sequence = append(sequence, diff.Deleted...)
+		sequence = append(sequence, firstRR(diff.NewSOA))
+		sequence = append(sequence, diff.Added...)
+	}
+	return append(sequence, soa)
+}
+
+func soaOnlyResponse(query *g53.Message, soa *g53.RRset) *g53.Message {
+	msg := query.MakeResponse()
+	msg.Header.SetFlag(g53.FLAG_AA, true)
+	msg.AddRRset(g53.AnswerSection, soa)
+	return msg
+}
+
+// IXFRResponses generates the tcp answer for an ixfr query, the diffs come
+// from journal and the whole zone is sent when journal can't serve the
+// serial of the client
+func IXFRResponses(query *g53.Message, rrsets []*g53.RRset, journal *Journal, sizeLimit int) ([]*g53.Message, error) {
+	held, err := clientSOA(query)
+	if err != nil {
+		return nil, err
+	}
+
+	soa := findApexSOA(query.Question.Name, rrsets)
+	if soa == nil {
+		return nil, fmt.Errorf("zone %s has no soa", query.Question.Name.String(false))
+	}
+	soa = firstRR(soa)
+
+	if g53.SerialLess(soaSerial(held), soaSerial(soa)) == false {
+		return []*g53.Message{soaOnlyResponse(query, soa)}, nil
+	}
+
+	if journal != nil {
+		if diffs, ok := journal.DiffsSince(soaSerial(held)); ok && len(diffs) > 0 &&
+			diffs[len(diffs)-1].ToSerial() == soaSerial(soa) {
+			return splitMessages(query, diffSequence(soa, diffs), sizeLimit), nil
+		}
+	}
+	return AXFRResponses(query, rrsets, sizeLimit)
+}
+
+func WriteIXFR(w io.Writer, query *g53.Message, rrsets []*g53.RRset, journal *Journal, sizeLimit int) error {
+	messages, err := IXFRResponses(query, rrsets, journal, sizeLimit)
+	if err != nil {
+		return err
+	}
+	return writeMessages(w, messages)
+}
+
+// IXFRUDPResponse condenses the diffs into one message, if it doesn't fit
+// into udpSize only the current soa is returned which tells the client to
+// retry over tcp
+func IXFRUDPResponse(query *g53.Message, rrsets []*g53.RRset, journal *Journal, udpSize int) (*g53.Message, error) {
+	held, err := clientSOA(query)
+	if err != nil {
+		return nil, err
+	}
+
+	soa := findApexSOA(query.Question.Name, rrsets)
+	if soa == nil {
+		return nil, fmt.Errorf("zone %s has no soa", query.Question.Name.String(false))
+	}
+	soa = firstRR(soa)
+
+	fallback := soaOnlyResponse(query, soa)
+	if g53.SerialLess(soaSerial(held), soaSerial(soa)) == false || journal == nil {
+		return fallback, nil
+	}
+
+	diffs, ok := journal.DiffsSince(soaSerial(held))
+	if ok == false || len(diffs) == 0 || diffs[len(diffs)-1].ToSerial() != soaSerial(soa) {
+		return fallback, nil
+	}
+
+	condensed, err := Condense(diffs)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := query.MakeResponse()
+	msg.Header.SetFlag(g53.FLAG_AA, true)
+	for _, rrset := range diffSequence(soa, []*Diff{condensed}) {
+		msg.AddRRset(g53.AnswerSection, rrset)
+	}
+
+	render := g53.NewMsgRender()
+	msg.Rend(render)
+	if int(render.Len()) > udpSize {
+		return fallback, nil
+	}
+	return msg, nil
+}
@@ -0,0 +1,212 @@
+package xfr
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/client"
+	"github.com/mistletoeChao/g53/util"
+)
+
+func soaRRset(serial string) *g53.RRset {
+	return g53.BuildRRset("example.com.", g53.RR_SOA, 3600, "ns1.example.com. root.example.com. "+serial+" 3600 900 86400")
+}
+
+// version 1 has www 1.1.1.1, version 2 moves www to 2.2.2.2 and version 3
+// adds mail
+func buildVersions() ([]*g53.RRset, []*g53.RRset, *Journal) {
+	ns := g53.BuildRRset("example.com.", g53.RR_NS, 3600, "ns1.example.com.")
+	glue := g53.BuildRRset("ns1.example.com.", g53.RR_A, 3600, "10.0.0.1")
+	v1 := []*g53.RRset{soaRRset("1"), ns, glue, g53.BuildRRset("www.example.com.", g53.RR_A, 300, "1.1.1.1")}
+	v3 := []*g53.RRset{soaRRset("3"), ns, glue,
+		g53.BuildRRset("www.example.com.", g53.RR_A, 300, "2.2.2.2"),
+		g53.BuildRRset("mail.example.com.", g53.RR_A, 300, "3.3.3.3")}
+
+	journal := NewJournal(0)
+	journal.Append(&Diff{
+		OldSOA:  soaRRset("1"),
+		NewSOA:  soaRRset("2"),
+		Deleted: []*g53.RRset{g53.BuildRRset("www.example.com.", g53.RR_A, 300, "1.1.1.1")},
+		Added:   []*g53.RRset{g53.BuildRRset("www.example.com.", g53.RR_A, 300, "2.2.2.2")},
+	})
+	journal.Append(&Diff{
+		OldSOA: soaRRset("2"),
+		NewSOA: soaRRset("3"),
+		Added:  []*g53.RRset{g53.BuildRRset("mail.example.com.", g53.RR_A, 300, "3.3.3.3")},
+	})
+	return v1, v3, journal
+}
+
+func zoneText(rrsets []*g53.RRset) string {
+	var lines []string
+	for _, rr := range flatten(rrsets) {
+		lines = append(lines, rr.String())
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
+
+func TestJournal(t *testing.T) {
+	_, _, journal := buildVersions()
+	err := journal.Append(&Diff{OldSOA: soaRRset("5"), NewSOA: soaRRset("6")})
+	g53.Assert(t, err != nil, "diff which isn't continuous should be rejected")
+
+	diffs, ok := journal.DiffsSince(1)
+	g53.Assert(t, ok, "serial 1 is in journal")
+	g53.Equal(t, len(diffs), 2)
+	diffs, ok = journal.DiffsSince(3)
+	g53.Assert(t, ok && len(diffs) == 0, "serial 3 is the latest")
+	_, ok = journal.DiffsSince(0)
+	g53.Equal(t, ok, false)
+
+	journal = NewJournal(1)
+	_, _, full := buildVersions()
+	all, _ := full.DiffsSince(1)
+	for _, diff := range all {
+		journal.Append(diff)
+	}
+	g53.Equal(t, journal.Len(), 1)
+	_, ok = journal.DiffsSince(1)
+	g53.Equal(t, ok, false)
+}
+
+func TestCondenseAndApply(t *testing.T) {
+	v1, v3, journal := buildVersions()
+	journal.Append(&Diff{
+		OldSOA:  soaRRset("3"),
+		NewSOA:  soaRRset("4"),
+		Deleted: []*g53.RRset{g53.BuildRRset("mail.example.com.", g53.RR_A, 300, "3.3.3.3")},
+	})
+	diffs, _ := journal.DiffsSince(1)
+	condensed, err := Condense(diffs)
+	g53.Assert(t, err == nil, "condense failed:%v", err)
+	g53.Equal(t, condensed.FromSerial(), uint32(1))
+	g53.Equal(t, condensed.ToSerial(), uint32(4))
+	g53.Equal(t, len(condensed.Deleted), 1)
+	g53.Equal(t, len(condensed.Added), 1)
+
+	v4, err := condensed.Apply(v1)
+	g53.Assert(t, err == nil, "apply failed:%v", err)
+	g53.Equal(t, zoneText(v4), zoneText(append([]*g53.RRset{soaRRset("4")}, v3[1:4]...)))
+
+	_, err = condensed.Apply(v3)
+	g53.Assert(t, err != nil, "diff shouldn't apply to other version")
+}
+
+func TestCondenseTTLChange(t *testing.T) {
+	v1, _, _ := buildVersions()
+	diffs := []*Diff{
+		{
+			OldSOA:  soaRRset("1"),
+			NewSOA:  soaRRset("2"),
+			Deleted: []*g53.RRset{g53.BuildRRset("www.example.com.", g53.RR_A, 300, "1.1.1.1")},
+			Added:   []*g53.RRset{g53.BuildRRset("www.example.com.", g53.RR_A, 600, "1.1.1.1")},
+		},
+	}
+	condensed, err := Condense(diffs)
+	g53.Assert(t, err == nil, "condense failed:%v", err)
+	g53.Equal(t, len(condensed.Deleted), 1)
+	g53.Equal(t, len(condensed.Added), 1)
+	g53.Equal(t, condensed.Added[0].Ttl, g53.RRTTL(600))
+
+	v2, err := condensed.Apply(v1)
+	g53.Assert(t, err == nil, "apply failed:%v", err)
+	g53.Equal(t, zoneText(v2), zoneText([]*g53.RRset{soaRRset("2"), v1[1], v1[2],
+		g53.BuildRRset("www.example.com.", g53.RR_A, 600, "1.1.1.1")}))
+}
+
+func serveIXFR(t *testing.T, rrsets []*g53.RRset, journal *Journal) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed:%v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			data, err := client.ReadTCPMessage(conn)
+			if err == nil {
+				query, err := g53.MessageFromWire(util.NewInputBuffer(data))
+				if err == nil {
+					WriteIXFR(conn, query, rrsets, journal, 128)
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestIXFRTransfer(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	v1, v3, journal := buildVersions()
+	addr, stop := serveIXFR(t, v3, journal)
+	defer stop()
+
+	result, err := IXFR(addr, zone, soaRRset("1"), 3*time.Second)
+	g53.Assert(t, err == nil, "ixfr failed:%v", err)
+	g53.Equal(t, result.IsFull(), false)
+	g53.Equal(t, len(result.Diffs), 2)
+	g53.Equal(t, soaSerial(result.SOA), uint32(3))
+
+	current := v1
+	for _, diff := range result.Diffs {
+		current, err = diff.Apply(current)
+		g53.Assert(t, err == nil, "apply diff failed:%v", err)
+	}
+	g53.Equal(t, zoneText(current), zoneText(v3))
+
+	result, err = IXFR(addr, zone, soaRRset("3"), 3*time.Second)
+	g53.Assert(t, err == nil, "ixfr failed:%v", err)
+	g53.Assert(t, result.IsUpToDate(), "client with latest serial is up to date")
+
+	result, err = IXFR(addr, zone, soaRRset("0"), 3*time.Second)
+	g53.Assert(t, err == nil, "ixfr failed:%v", err)
+	g53.Assert(t, result.IsFull(), "serial not in journal falls back to axfr")
+	g53.Equal(t, zoneText(result.Zone), zoneText(v3))
+}
+
+func TestIXFRUDPResponse(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	_, v3, journal := buildVersions()
+	query := MakeIXFRQuery(zone, soaRRset("1"))
+
+	msg, err := IXFRUDPResponse(query, v3, journal, 512)
+	g53.Assert(t, err == nil, "ixfr udp response failed:%v", err)
+	render := g53.NewMsgRender()
+	msg.Rend(render)
+	parsed, _ := g53.MessageFromWire(util.NewInputBuffer(render.Data()))
+	parser := NewIXFRParser(zone)
+	g53.Assert(t, parser.Feed(parsed) == nil, "condensed response should be valid")
+	result, err := parser.Result()
+	g53.Assert(t, err == nil, "condensed response should be complete:%v", err)
+	g53.Equal(t, len(result.Diffs), 1)
+	g53.Equal(t, result.Diffs[0].FromSerial(), uint32(1))
+	g53.Equal(t, result.Diffs[0].ToSerial(), uint32(3))
+
+	msg, _ = IXFRUDPResponse(query, v3, journal, 100)
+	g53.Equal(t, len(msg.Sections[g53.AnswerSection]), 1)
+	g53.Equal(t, soaSerial(msg.Sections[g53.AnswerSection][0]), uint32(3))
+}
+
+func TestIXFREmptyRdata(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	_, v3, journal := buildVersions()
+	conn := servePipe(func(query *g53.Message) []*g53.Message {
+		messages, _ := IXFRResponses(query, v3, journal, 0)
+		//the old soa of the first diff
+		messages[0].Sections[g53.AnswerSection][1] = &g53.RRset{Name: zone, Type: g53.RR_SOA, Class: g53.CLASS_ANY}
+		return messages
+	})
+	defer conn.Close()
+
+	_, err := ReadIXFR(conn, zone, soaRRset("1"))
+	g53.Equal(t, err, g53.ErrEmptyRdata)
+}
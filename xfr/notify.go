@@ -0,0 +1,236 @@
+package xfr
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+const (
+	DefaultNotifyTimeout = 2 * time.Second
+	DefaultNotifyRetries = 5
+)
+
+// Notifier sends notify from primary to secondaries over udp, the notify is
+// retransmitted with the same id until the secondary acknowledges it
+type Notifier struct {
+	Key     *g53.TSIGKey
+	Timeout time.Duration
+	Retries int
+}
+
+func NewNotifier(key *g53.TSIGKey) *Notifier {
+	return &Notifier{
+		Key:     key,
+		Timeout: DefaultNotifyTimeout,
+		Retries: DefaultNotifyRetries,
+	}
+}
+
+func (n *Notifier) Notify(server string, zone *g53.Name, soa *g53.RRset) error {
+	if soa != nil {
+		soa = firstRR(soa)
+	}
+	msg := g53.MakeNotify(zone, soa)
+	msg.Header.Id = uint16(rand.Uint32())
+
+	render := g53.NewMsgRender()
+	var requestMAC []byte
+	if n.Key != nil {
+		if err := n.Key.Sign(msg, render, nil, time.Now()); err != nil {
+			return err
+		}
+		requestMAC = msg.Tsig.Rdatas[0].(*g53.TSIG).MAC
+	} else {
+		msg.Rend(render)
+	}
+
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	timeout := n.Timeout
+	if timeout <= 0 {
+		timeout = DefaultNotifyTimeout
+	}
+
+	buf := make([]byte, DefaultMessageSize)
+	for i := 0; i <= n.Retries; i++ {
+		if _, err := conn.Write(render.Data()); err != nil {
+			return err
+		}
+
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			size, err := conn.Read(buf)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return err
+			}
+
+			response, err := g53.MessageFromWire(util.NewInputBuffer(buf[0:size]))
+			if err != nil || response.Header.Id != msg.Header.Id {
+				continue
+			}
+			return n.checkAck(msg, response, buf[0:size], requestMAC)
+		}
+	}
+	return fmt.Errorf("notify to %s isn't acknowledged after %d tries", server, n.Retries+1)
+}
+
+func (n *Notifier) checkAck(query, response *g53.Message, wire []byte, requestMAC []byte) error {
+	if response.Header.GetFlag(g53.FLAG_QR) == false || response.Header.Opcode != g53.OP_NOTIFY {
+		return errors.New("notify response isn't a notify response")
+	}
+
+	if response.Question == nil || response.Question.Type != g53.RR_SOA ||
+		response.Question.Name.Equals(query.Question.Name) == false {
+		return errors.New("notify response question doesn't match query")
+	}
+
+	if response.Header.Rcode != g53.R_NOERROR {
+		return fmt.Errorf("notify is rejected with %s", response.Header.Rcode.String())
+	}
+
+	if n.Key != nil {
+		if _, err := n.Key.Verify(wire, requestMAC, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NotifyHandler validates notify received by secondary and generates the
+// response, Zones are the zones the secondary serves
+type NotifyHandler struct {
+	Key   *g53.TSIGKey
+	Zones []*g53.Name
+}
+
+func (h *NotifyHandler) isServed(zone *g53.Name) bool {
+	for _, z := range h.Zones {
+		if z.Equals(zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle returns the wire format response and the notify if it is accepted,
+// err explains why the notify is rejected, the response is nil only when
+// even the header can't be parsed
+func (h *NotifyHandler) Handle(wire []byte) ([]byte, *g53.Message, error) {
+	msg, err := g53.MessageFromWire(util.NewInputBuffer(wire))
+	if err != nil {
+		header, herr := g53.HeaderFromWire(util.NewInputBuffer(wire))
+		if herr != nil {
+			return nil, nil, err
+		}
+		return formErrResponse(header), nil, err
+	}
+
+	if err := g53.ValidateNotify(msg); err != nil {
+		return formErrResponse(msg.Header), nil, err
+	}
+
+	if msg.Tsig != nil && len(msg.Tsig.Rdatas) != 1 {
+		return formErrResponse(msg.Header), nil, g53.ErrTSIGNotLast
+	}
+
+	response := msg.MakeResponse()
+	response.Header.SetFlag(g53.FLAG_AA, true)
+
+	var requestMAC []byte
+	if h.Key != nil || msg.Tsig != nil {
+		var tsig *g53.TSIG
+		if h.Key == nil || msg.Tsig == nil {
+			err = g53.TSIG_BADKEY
+			if msg.Tsig == nil {
+				err = g53.ErrNoTSIG
+			}
+		} else {
+			tsig, err = h.Key.Verify(wire, nil, time.Now())
+			if err == nil {
+				requestMAC = tsig.MAC
+			}
+		}
+
+		if err != nil {
+			response.Header.Rcode = g53.R_NOTAUTH
+			if err == g53.TSIG_BADTIME {
+				render := g53.NewMsgRender()
+				if serr := h.Key.SignBadTime(response, render, tsig, time.Now()); serr != nil {
+					return nil, nil, serr
+				}
+				return render.Data(), nil, err
+			}
+			return renderTSIGError(response, msg.Tsig, err), nil, err
+		}
+	}
+
+	if h.isServed(msg.Question.Name) == false {
+		response.Header.Rcode = g53.R_NOTAUTH
+		err = fmt.Errorf("zone %s isn't served", msg.Question.Name.String(false))
+	}
+
+	render := g53.NewMsgRender()
+	if h.Key != nil {
+		if serr := h.Key.Sign(response, render, requestMAC, time.Now()); serr != nil {
+			return nil, nil, serr
+		}
+	} else {
+		response.Rend(render)
+	}
+
+	if err != nil {
+		return render.Data(), nil, err
+	}
+	return render.Data(), msg, nil
+}
+
+func formErrResponse(header *g53.Header) []byte {
+	h := &g53.Header{
+		Id:     header.Id,
+		Opcode: header.Opcode,
+		Rcode:  g53.R_FORMERR,
+	}
+	h.SetFlag(g53.FLAG_QR, true)
+	render := g53.NewMsgRender()
+	(&g53.Message{Header: h}).Rend(render)
+	return render.Data()
+}
+
+// the tsig rr of BADSIG and BADKEY error response has no mac since the
+// request can't be verified
+func renderTSIGError(response *g53.Message, requestTSIG *g53.RRset, err error) []byte {
+	if tsigErr, ok := err.(g53.TSIGError); ok && requestTSIG != nil && len(requestTSIG.Rdatas) == 1 {
+		request := requestTSIG.Rdatas[0].(*g53.TSIG)
+		tsig := &g53.TSIG{
+			Algorithm:  request.Algorithm,
+			TimeSigned: request.TimeSigned,
+			Fudge:      request.Fudge,
+			OriginalId: response.Header.Id,
+			Error:      uint16(tsigErr),
+		}
+		response.Tsig = &g53.RRset{
+			Name:   requestTSIG.Name,
+			Type:   g53.RR_TSIG,
+			Class:  g53.CLASS_ANY,
+			Ttl:    0,
+			Rdatas: []g53.Rdata{tsig},
+		}
+	}
+
+	render := g53.NewMsgRender()
+	response.Rend(render)
+	return render.Data()
+}
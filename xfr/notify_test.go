@@ -0,0 +1,173 @@
+package xfr
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/util"
+)
+
+// serveNotify drops the first `drop` packets to simulate packet loss
+func serveNotify(t *testing.T, handler *NotifyHandler, drop int) (string, chan *g53.Message, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed:%v", err)
+	}
+
+	notifies := make(chan *g53.Message, 10)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if drop > 0 {
+				drop -= 1
+				continue
+			}
+			response, notify, _ := handler.Handle(buf[0:n])
+			if notify != nil {
+				notifies <- notify
+			}
+			if response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), notifies, func() { conn.Close() }
+}
+
+func TestNotify(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	addr, notifies, stop := serveNotify(t, &NotifyHandler{Zones: []*g53.Name{zone}}, 1)
+	defer stop()
+
+	notifier := &Notifier{Timeout: 100 * time.Millisecond, Retries: 3}
+	err := notifier.Notify(addr, zone, soaRRset("5"))
+	g53.Assert(t, err == nil, "notify failed:%v", err)
+	notify := <-notifies
+	serial, ok := g53.NotifySerial(notify)
+	g53.Assert(t, ok && serial == 5, "secondary should get serial 5")
+
+	other, _ := g53.NameFromString("example.org.")
+	err = notifier.Notify(addr, other, nil)
+	g53.Assert(t, err != nil, "notify for zone not served should be rejected")
+
+	addr2, _, stop2 := serveNotify(t, &NotifyHandler{Zones: []*g53.Name{zone}}, 10)
+	defer stop2()
+	notifier.Retries = 1
+	err = notifier.Notify(addr2, zone, soaRRset("5"))
+	g53.Assert(t, err != nil, "notify without ack should fail")
+}
+
+func TestNotifyWithTSIG(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	key, _ := g53.NewTSIGKey("key.example.com.", g53.TSIG_HMAC_SHA256, "c2VjcmV0c2VjcmV0c2VjcmV0")
+	addr, notifies, stop := serveNotify(t, &NotifyHandler{Key: key, Zones: []*g53.Name{zone}}, 0)
+	defer stop()
+
+	notifier := NewNotifier(key)
+	err := notifier.Notify(addr, zone, soaRRset("6"))
+	g53.Assert(t, err == nil, "signed notify failed:%v", err)
+	<-notifies
+
+	wrongKey, _ := g53.NewTSIGKey("key.example.com.", g53.TSIG_HMAC_SHA256, "b3RoZXJzZWNyZXQ=")
+	err = NewNotifier(wrongKey).Notify(addr, zone, soaRRset("6"))
+	g53.Assert(t, err != nil, "notify with wrong key should be rejected")
+
+	err = NewNotifier(nil).Notify(addr, zone, soaRRset("6"))
+	g53.Assert(t, err != nil, "unsigned notify should be rejected")
+}
+
+func TestNotifyHandlerResponse(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	key, _ := g53.NewTSIGKey("key.example.com.", g53.TSIG_HMAC_SHA256, "c2VjcmV0c2VjcmV0c2VjcmV0")
+	handler := &NotifyHandler{Key: key, Zones: []*g53.Name{zone}}
+
+	query := g53.MakeQuery(zone, g53.RR_SOA, 512, false)
+	render := g53.NewMsgRender()
+	query.Rend(render)
+	wire, notify, err := handler.Handle(render.Data())
+	g53.Assert(t, err != nil && notify == nil, "query isn't notify")
+	response, _ := g53.MessageFromWire(util.NewInputBuffer(wire))
+	g53.Equal(t, response.Header.Rcode, g53.Rcode(g53.R_FORMERR))
+
+	msg := g53.MakeNotify(zone, soaRRset("7"))
+	msg.Header.Id = 100
+	render = g53.NewMsgRender()
+	signedAt := time.Now().Add(-time.Hour)
+	key.Sign(msg, render, nil, signedAt)
+	wire, _, err = handler.Handle(render.Data())
+	g53.Equal(t, err, g53.TSIG_BADTIME)
+	response, _ = g53.MessageFromWire(util.NewInputBuffer(wire))
+	g53.Equal(t, response.Header.Rcode, g53.Rcode(g53.R_NOTAUTH))
+	g53.Assert(t, response.Header.GetFlag(g53.FLAG_QR), "response should have qr set")
+	tsig := response.Tsig.Rdatas[0].(*g53.TSIG)
+	g53.Equal(t, tsig.Error, uint16(g53.TSIG_BADTIME))
+	g53.Equal(t, len(tsig.OtherData), 6)
+	//BADTIME response is signed with the time of request
+	_, err = key.Verify(wire, msg.Tsig.Rdatas[0].(*g53.TSIG).MAC, signedAt)
+	g53.Assert(t, err == nil, "BADTIME response should be signed:%v", err)
+
+	//tsig appears twice
+	render = g53.NewMsgRender()
+	key.Sign(msg, render, nil, time.Now())
+	signed := render.Data()
+	msg.Tsig = nil
+	render = g53.NewMsgRender()
+	msg.Rend(render)
+	twice := append(append([]byte{}, signed...), signed[len(render.Data()):]...)
+	twice[11] += 1
+	wire, notify, err = handler.Handle(twice)
+	g53.Assert(t, err != nil && notify == nil, "notify with two tsig should be rejected")
+	response, _ = g53.MessageFromWire(util.NewInputBuffer(wire))
+	g53.Equal(t, response.Header.Rcode, g53.Rcode(g53.R_FORMERR))
+	g53.Assert(t, response.Tsig == nil, "formerr response isn't signed")
+
+	other, _ := g53.NewTSIGKey("key.example.com.", g53.TSIG_HMAC_SHA256, "b3RoZXJvdGhlcm90aGVy")
+	render = g53.NewMsgRender()
+	other.Sign(msg, render, nil, time.Now())
+	wire, _, err = handler.Handle(render.Data())
+	g53.Equal(t, err, g53.TSIG_BADSIG)
+	response, _ = g53.MessageFromWire(util.NewInputBuffer(wire))
+	g53.Equal(t, len(response.Tsig.Rdatas[0].(*g53.TSIG).MAC), 0)
+
+	render = g53.NewMsgRender()
+	key.Sign(msg, render, nil, time.Now())
+	requestMAC := msg.Tsig.Rdatas[0].(*g53.TSIG).MAC
+	wire, notify, err = handler.Handle(render.Data())
+	g53.Assert(t, err == nil && notify != nil, "notify should be accepted:%v", err)
+	response, _ = g53.MessageFromWire(util.NewInputBuffer(wire))
+	g53.Equal(t, response.Header.Id, uint16(100))
+	g53.Equal(t, response.Header.Opcode, g53.Opcode(g53.OP_NOTIFY))
+	g53.Assert(t, response.Header.GetFlag(g53.FLAG_AA), "response should be authoritative")
+	_, err = key.Verify(wire, requestMAC, time.Now())
+	g53.Assert(t, err == nil, "response should be signed:%v", err)
+}
+
+func TestNotifyHandlerEmptyRdata(t *testing.T) {
+	zone, _ := g53.NameFromString("example.com.")
+	key, _ := g53.NewTSIGKey("key.example.com.", g53.TSIG_HMAC_SHA256, "c2VjcmV0c2VjcmV0c2VjcmV0")
+	handler := &NotifyHandler{Key: key, Zones: []*g53.Name{zone}}
+
+	msg := g53.MakeNotify(zone, nil)
+	msg.AddRRset(g53.AnswerSection, &g53.RRset{Name: zone, Type: g53.RR_SOA, Class: g53.CLASS_ANY})
+	render := g53.NewMsgRender()
+	msg.Rend(render)
+	wire, notify, err := handler.Handle(render.Data())
+	g53.Assert(t, err != nil && notify == nil, "notify with empty soa should be rejected")
+	response, _ := g53.MessageFromWire(util.NewInputBuffer(wire))
+	g53.Equal(t, response.Header.Rcode, g53.Rcode(g53.R_FORMERR))
+
+	msg = g53.MakeNotify(zone, soaRRset("7"))
+	msg.AddRRset(g53.AdditionalSection, &g53.RRset{Name: key.Name, Type: g53.RR_TSIG, Class: g53.CLASS_ANY})
+	render = g53.NewMsgRender()
+	msg.Rend(render)
+	wire, notify, err = handler.Handle(render.Data())
+	g53.Assert(t, err != nil && notify == nil, "notify with empty tsig should be rejected")
+	response, _ = g53.MessageFromWire(util.NewInputBuffer(wire))
+	g53.Equal(t, response.Header.Rcode, g53.Rcode(g53.R_FORMERR))
+}
@@ -0,0 +1,302 @@
+package zone
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/mistletoeChao/g53"
+)
+
+type Severity uint8
+
+const (
+	SEV_WARNING Severity = 0
+	SEV_ERROR            = 1
+)
+
+var SeverityStr = map[Severity]string{
+	SEV_WARNING: "warning",
+	SEV_ERROR:   "error",
+}
+
+func (s Severity) String() string {
+	return SeverityStr[s]
+}
+
+type CheckCode uint8
+
+const (
+	CHECK_NO_SOA         CheckCode = 0
+	CHECK_MULTIPLE_SOA             = 1
+	CHECK_CNAME_AND_DATA           = 2
+	CHECK_MISSING_GLUE             = 3
+	CHECK_OUT_OF_ZONE              = 4
+	CHECK_MX_CNAME                 = 5
+	CHECK_SRV_CNAME                = 6
+	CHECK_TTL_MISMATCH             = 7
+	CHECK_DUPLICATE_RR             = 8
+)
+
+var CheckCodeStr = map[CheckCode]string{
+	CHECK_NO_SOA:         "NO-SOA",
+	CHECK_MULTIPLE_SOA:   "MULTIPLE-SOA",
+	CHECK_CNAME_AND_DATA: "CNAME-AND-DATA",
+	CHECK_MISSING_GLUE:   "MISSING-GLUE",
+	CHECK_OUT_OF_ZONE:    "OUT-OF-ZONE",
+	CHECK_MX_CNAME:       "MX-CNAME",
+	CHECK_SRV_CNAME:      "SRV-CNAME",
+	CHECK_TTL_MISMATCH:   "TTL-MISMATCH",
+	CHECK_DUPLICATE_RR:   "DUPLICATE-RR",
+}
+
+func (c CheckCode) String() string {
+	return CheckCodeStr[c]
+}
+
+type Finding struct {
+	Severity Severity
+	Code     CheckCode
+	Name     *g53.Name
+	Type     g53.RRType
+	Message  string
+}
+
+func (f *Finding) String() string {
+	return fmt.Sprintf("%s: %s %s %s: %s", f.Severity.String(), f.Code.String(), f.Name.String(false), f.Type.String(), f.Message)
+}
+
+func HasError(findings []*Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SEV_ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+// rrsets with the same name, type and class, in the order they first appear
+type rrsetGroup struct {
+	rrsets []*g53.RRset
+}
+
+func (g *rrsetGroup) first() *g53.RRset {
+	return g.rrsets[0]
+}
+
+// all the data owned by one name
+type nameNode struct {
+	name   *g53.Name
+	groups []*rrsetGroup
+}
+
+func (n *nameNode) group(typ g53.RRType) *rrsetGroup {
+	for _, g := range n.groups {
+		if g.first().Type == typ {
+			return g
+		}
+	}
+	return nil
+}
+
+type checker struct {
+	origin   *g53.Name
+	nodes    map[string]*nameNode
+	order    []*nameNode
+	findings []*Finding
+}
+
+func nameKey(name *g53.Name) string {
+	return strings.ToLower(name.String(false))
+}
+
+// Check validates the rrsets of the zone rooted at origin, findings are
+// returned in the order of the rrsets which trigger them
+func Check(origin *g53.Name, rrsets []*g53.RRset) []*Finding {
+	c := &checker{
+		origin: origin,
+		nodes:  make(map[string]*nameNode),
+	}
+
+	for _, rrset := range rrsets {
+		c.add(rrset)
+	}
+
+	c.checkSOA()
+	for _, node := range c.order {
+		c.checkName(node)
+		c.checkCName(node)
+		for _, g := range node.groups {
+			c.checkGroup(g)
+		}
+		c.checkNSGlue(node)
+		c.checkTarget(node, g53.RR_MX, CHECK_MX_CNAME, SEV_ERROR)
+		c.checkTarget(node, g53.RR_SRV, CHECK_SRV_CNAME, SEV_WARNING)
+	}
+	return c.findings
+}
+
+func (c *checker) report(sev Severity, code CheckCode, name *g53.Name, typ g53.RRType, format string, args ...interface{}) {
+	c.findings = append(c.findings, &Finding{
+		Severity: sev,
+		Code:     code,
+		Name:     name,
+		Type:     typ,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (c *checker) add(rrset *g53.RRset) {
+	key := nameKey(rrset.Name)
+	node, ok := c.nodes[key]
+	if ok == false {
+		node = &nameNode{name: rrset.Name}
+		c.nodes[key] = node
+		c.order = append(c.order, node)
+	}
+
+	for _, g := range node.groups {
+		if g.first().Type == rrset.Type && g.first().Class == rrset.Class {
+			g.rrsets = append(g.rrsets, rrset)
+			return
+		}
+	}
+	node.groups = append(node.groups, &rrsetGroup{[]*g53.RRset{rrset}})
+}
+
+func (c *checker) isInZone(name *g53.Name) bool {
+	relation := name.Compare(c.origin, false).Relation
+	return relation == g53.SUBDOMAIN || relation == g53.EQUAL
+}
+
+func (c *checker) lookup(name *g53.Name) *nameNode {
+	return c.nodes[nameKey(name)]
+}
+
+func (c *checker) checkSOA() {
+	apex := c.lookup(c.origin)
+	var soa *rrsetGroup
+	if apex != nil {
+		soa = apex.group(g53.RR_SOA)
+	}
+
+	if soa == nil {
+		c.report(SEV_ERROR, CHECK_NO_SOA, c.origin, g53.RR_SOA, "zone has no soa at apex")
+	} else {
+		count := 0
+		for _, rrset := range soa.rrsets {
+			count += rrset.RrCount()
+		}
+		if count > 1 {
+			c.report(SEV_ERROR, CHECK_MULTIPLE_SOA, c.origin, g53.RR_SOA, "zone has %d soa records", count)
+		}
+	}
+
+	for _, node := range c.order {
+		if node != apex && node.group(g53.RR_SOA) != nil && c.isInZone(node.name) {
+			c.report(SEV_ERROR, CHECK_MULTIPLE_SOA, node.name, g53.RR_SOA, "soa isn't at zone apex")
+		}
+	}
+}
+
+func (c *checker) checkName(node *nameNode) {
+	if c.isInZone(node.name) == false {
+		c.report(SEV_ERROR, CHECK_OUT_OF_ZONE, node.name, node.groups[0].first().Type, "name isn't under origin %s", c.origin.String(false))
+	}
+
+}
+
+func (c *checker) checkCName(node *nameNode) {
+	cname := node.group(g53.RR_CNAME)
+	if cname == nil {
+		return
+	}
+
+	for _, g := range node.groups {
+		typ := g.first().Type
+		if typ != g53.RR_CNAME && typ != g53.RR_RRSIG && typ != g53.RR_NSEC {
+			c.report(SEV_ERROR, CHECK_CNAME_AND_DATA, node.name, typ, "cname coexists with %s", typ.String())
+		}
+	}
+
+	count := 0
+	for _, rrset := range cname.rrsets {
+		count += rrset.RrCount()
+	}
+	if count > 1 {
+		c.report(SEV_ERROR, CHECK_CNAME_AND_DATA, node.name, g53.RR_CNAME, "name has %d cname records", count)
+	}
+}
+
+func (c *checker) checkGroup(g *rrsetGroup) {
+	first := g.first()
+	seen := [][]byte{}
+	for i, rrset := range g.rrsets {
+		if i > 0 && rrset.Ttl != first.Ttl {
+			c.report(SEV_WARNING, CHECK_TTL_MISMATCH, first.Name, first.Type, "ttl %d differs from %d", rrset.Ttl, first.Ttl)
+		}
+
+		for _, rdata := range rrset.Rdatas {
+			wire := rdataWire(rdata)
+			for _, other := range seen {
+				if bytes.Equal(wire, other) {
+					c.report(SEV_WARNING, CHECK_DUPLICATE_RR, first.Name, first.Type, "duplicate record %s", rdata.String())
+					break
+				}
+			}
+			seen = append(seen, wire)
+		}
+	}
+}
+
+func rdataWire(rdata g53.Rdata) []byte {
+	render := g53.NewMsgRender()
+	rdata.Rend(render)
+	return render.Data()
+}
+
+func (c *checker) checkNSGlue(node *nameNode) {
+	ns := node.group(g53.RR_NS)
+	if ns == nil {
+		return
+	}
+
+	for _, rrset := range ns.rrsets {
+		for _, rdata := range rrset.Rdatas {
+			target := rdata.(*g53.NS).Name
+			if c.isInZone(target) == false {
+				continue
+			}
+
+			glue := c.lookup(target)
+			if glue == nil || (glue.group(g53.RR_A) == nil && glue.group(g53.RR_AAAA) == nil) {
+				c.report(SEV_ERROR, CHECK_MISSING_GLUE, node.name, g53.RR_NS, "ns %s has no address in zone", target.String(false))
+			}
+		}
+	}
+}
+
+func (c *checker) checkTarget(node *nameNode, typ g53.RRType, code CheckCode, sev Severity) {
+	g := node.group(typ)
+	if g == nil {
+		return
+	}
+
+	for _, rrset := range g.rrsets {
+		for _, rdata := range rrset.Rdatas {
+			var target *g53.Name
+			switch rd := rdata.(type) {
+			case *g53.MX:
+				target = rd.Exchange
+			case *g53.SRV:
+				target = rd.Target
+			default:
+				continue
+			}
+
+			if tn := c.lookup(target); tn != nil && tn.group(g53.RR_CNAME) != nil {
+				c.report(sev, code, node.name, typ, "target %s is a cname", target.String(false))
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package zone
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mistletoeChao/g53"
+)
+
+func buildZone() []*g53.RRset {
+	return []*g53.RRset{
+		g53.BuildRRset("example.com.", g53.RR_SOA, 3600, "ns1.example.com. root.example.com. 1 3600 900 86400"),
+		g53.BuildRRset("example.com.", g53.RR_NS, 3600, "ns1.example.com.", "ns.other.org."),
+		g53.BuildRRset("ns1.example.com.", g53.RR_A, 3600, "1.1.1.1"),
+		g53.BuildRRset("www.example.com.", g53.RR_A, 3600, "2.2.2.2"),
+		g53.BuildRRset("example.com.", g53.RR_MX, 3600, "10 mail.example.com."),
+		g53.BuildRRset("mail.example.com.", g53.RR_A, 3600, "3.3.3.3"),
+	}
+}
+
+func codes(findings []*Finding) []CheckCode {
+	cs := []CheckCode{}
+	for _, f := range findings {
+		cs = append(cs, f.Code)
+	}
+	return cs
+}
+
+func TestCheckValidZone(t *testing.T) {
+	origin, _ := g53.NameFromString("example.com.")
+	findings := Check(origin, buildZone())
+	g53.Equal(t, len(findings), 0)
+	g53.Assert(t, HasError(findings) == false, "valid zone shouldn't have error")
+}
+
+func TestCheckSOA(t *testing.T) {
+	origin, _ := g53.NameFromString("example.com.")
+	rrsets := buildZone()[1:]
+	findings := Check(origin, rrsets)
+	g53.Equal(t, codes(findings), []CheckCode{CHECK_NO_SOA})
+	g53.Assert(t, HasError(findings), "no soa is an error")
+
+	rrsets = append(buildZone(),
+		g53.BuildRRset("example.com.", g53.RR_SOA, 3600, "ns2.example.com. root.example.com. 2 3600 900 86400"),
+		g53.BuildRRset("sub.example.com.", g53.RR_SOA, 3600, "ns1.example.com. root.example.com. 1 3600 900 86400"))
+	findings = Check(origin, rrsets)
+	g53.Equal(t, codes(findings), []CheckCode{CHECK_MULTIPLE_SOA, CHECK_MULTIPLE_SOA})
+}
+
+func TestCheckCNameAndTargets(t *testing.T) {
+	origin, _ := g53.NameFromString("example.com.")
+	rrsets := append(buildZone(),
+		g53.BuildRRset("www.example.com.", g53.RR_CNAME, 3600, "web.example.com."),
+		g53.BuildRRset("alias.example.com.", g53.RR_CNAME, 3600, "mail.example.com."),
+		g53.BuildRRset("example.com.", g53.RR_MX, 3600, "20 alias.example.com."),
+		g53.BuildRRset("_sip._udp.example.com.", g53.RR_SRV, 3600, "0 1 5060 alias.example.com."))
+	findings := Check(origin, rrsets)
+	g53.Equal(t, codes(findings), []CheckCode{CHECK_MX_CNAME, CHECK_CNAME_AND_DATA, CHECK_SRV_CNAME})
+	g53.Equal(t, findings[0].Severity, Severity(SEV_ERROR))
+	g53.Equal(t, findings[2].Severity, Severity(SEV_WARNING))
+	g53.Assert(t, strings.Contains(findings[1].String(), "www.example.com."), "finding should report owner name")
+}
+
+func TestCheckGlueAndZoneCut(t *testing.T) {
+	origin, _ := g53.NameFromString("example.com.")
+	rrsets := append(buildZone(),
+		g53.BuildRRset("sub.example.com.", g53.RR_NS, 3600, "ns.sub.example.com."),
+		g53.BuildRRset("www.example.org.", g53.RR_A, 3600, "4.4.4.4"))
+	findings := Check(origin, rrsets)
+	g53.Equal(t, codes(findings), []CheckCode{CHECK_MISSING_GLUE, CHECK_OUT_OF_ZONE})
+}
+
+func TestCheckRRsetConsistency(t *testing.T) {
+	origin, _ := g53.NameFromString("example.com.")
+	rrsets := append(buildZone(),
+		g53.BuildRRset("www.example.com.", g53.RR_A, 300, "5.5.5.5"),
+		g53.BuildRRset("www.example.com.", g53.RR_A, 3600, "2.2.2.2"))
+	findings := Check(origin, rrsets)
+	g53.Equal(t, codes(findings), []CheckCode{CHECK_TTL_MISMATCH, CHECK_DUPLICATE_RR})
+	g53.Equal(t, HasError(findings), false)
+}
@@ -0,0 +1,391 @@
+package zone
+
+import (
+	"github.com/mistletoeChao/g53"
+	"github.com/mistletoeChao/g53/xfr"
+)
+
+func isMetaType(typ g53.RRType) bool {
+	switch typ {
+	case g53.RR_ANY, g53.RR_AXFR, g53.RR_IXFR, g53.RR_MAILA, g53.RR_MAILB,
+		g53.RR_OPT, g53.RR_TSIG, g53.RR_TKEY:
+		return true
+	default:
+		return false
+	}
+}
+
+// working copy of zone data, nodes are cloned before the first change so
+// the published data is never touched
+type updateTxn struct {
+	origin  *g53.Name
+	data    zoneData
+	touched map[string]bool
+}
+
+func (t *updateTxn) node(name *g53.Name) *node {
+	key := nameKey(name)
+	n, ok := t.data[key]
+	if ok == false {
+		return nil
+	}
+
+	if t.touched[key] == false {
+		n = n.clone()
+		t.data[key] = n
+		t.touched[key] = true
+	}
+	return n
+}
+
+func (t *updateTxn) createNode(name *g53.Name) *node {
+	if n := t.node(name); n != nil {
+		return n
+	}
+
+	key := nameKey(name)
+	n := &node{
+		name:   name,
+		rrsets: make(map[g53.RRType]*g53.RRset),
+	}
+	t.data[key] = n
+	t.touched[key] = true
+	return n
+}
+
+func (t *updateTxn) deleteRRset(n *node, typ g53.RRType) {
+	delete(n.rrsets, typ)
+	if len(n.rrsets) == 0 {
+		delete(t.data, nameKey(n.name))
+	}
+}
+
+// Update processes the update message as rfc2136 section 3, the changes are
+// applied atomically and the soa serial is increased if anything changed.
+// The diff is nil if the zone isn't changed
+func (z *Zone) Update(msg *g53.Message) (g53.Rcode, *xfr.Diff) {
+	if msg.Header.Opcode != g53.OP_UPDATE || msg.Question == nil {
+		return g53.R_FORMERR, nil
+	}
+
+	if msg.Question.Type != g53.RR_SOA {
+		return g53.R_FORMERR, nil
+	}
+
+	if msg.Question.Name.Equals(z.origin) == false || msg.Question.Class != g53.CLASS_IN {
+		return g53.R_NOTAUTH, nil
+	}
+
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	if rcode := z.checkPrerequisites(msg.Sections[g53.PrerequisiteSection]); rcode != g53.R_NOERROR {
+		return rcode, nil
+	}
+
+	updates := msg.Sections[g53.UpdateSection]
+	if rcode := z.prescan(updates); rcode != g53.R_NOERROR {
+		return rcode, nil
+	}
+
+	txn := &updateTxn{
+		origin:  z.origin,
+		data:    z.data.clone(),
+		touched: make(map[string]bool),
+	}
+	for _, rrset := range updates {
+		txn.apply(rrset)
+	}
+
+	diff := makeDiff(z.origin, z.data, txn.data)
+	if diff == nil {
+		return g53.R_NOERROR, nil
+	}
+
+	if soaSerial(diff.OldSOA) == soaSerial(diff.NewSOA) {
+		soa := copyRRset(diff.NewSOA)
+		rdata := *soa.Rdatas[0].(*g53.SOA)
+		rdata.Serial += 1
+		soa.Rdatas[0] = &rdata
+		txn.createNode(z.origin).rrsets[g53.RR_SOA] = soa
+		diff.NewSOA = soa
+	}
+
+	z.data = txn.data
+	return g53.R_NOERROR, diff
+}
+
+// rfc2136 3.2
+func (z *Zone) checkPrerequisites(prereqs []*g53.RRset) g53.Rcode {
+	var values []*g53.RRset
+	for _, rrset := range prereqs {
+		if rrset.Ttl != 0 {
+			return g53.R_FORMERR
+		}
+
+		if z.isInZone(rrset.Name) == false {
+			return g53.R_NOTZONE
+		}
+
+		switch rrset.Class {
+		case g53.CLASS_ANY:
+			if len(rrset.Rdatas) != 0 {
+				return g53.R_FORMERR
+			}
+			if rrset.Type == g53.RR_ANY {
+				if _, ok := z.data[nameKey(rrset.Name)]; ok == false {
+					return g53.R_NXDOMAIN
+				}
+			} else if z.data.get(rrset.Name, rrset.Type) == nil {
+				return g53.R_NXRRSET
+			}
+
+		case g53.CLASS_NONE:
+			if len(rrset.Rdatas) != 0 {
+				return g53.R_FORMERR
+			}
+			if rrset.Type == g53.RR_ANY {
+				if _, ok := z.data[nameKey(rrset.Name)]; ok {
+					return g53.R_YXDOMAIN
+				}
+			} else if z.data.get(rrset.Name, rrset.Type) != nil {
+				return g53.R_YXRRSET
+			}
+
+		case g53.CLASS_IN:
+			if isMetaType(rrset.Type) {
+				return g53.R_FORMERR
+			}
+			values = mergeValue(values, rrset)
+
+		default:
+			return g53.R_FORMERR
+		}
+	}
+
+	for _, value := range values {
+		current := z.data.get(value.Name, value.Type)
+		if current == nil || isSameValue(current, value) == false {
+			return g53.R_NXRRSET
+		}
+	}
+	return g53.R_NOERROR
+}
+
+func mergeValue(values []*g53.RRset, rrset *g53.RRset) []*g53.RRset {
+	for _, value := range values {
+		if value.IsSameRrset(rrset) {
+			for _, rdata := range rrset.Rdatas {
+				if rdataIndex(value, rdata) == -1 {
+					value.Rdatas = append(value.Rdatas, rdata)
+				}
+			}
+			return values
+		}
+	}
+	return append(values, copyRRset(rrset))
+}
+
+func isSameValue(rrset, other *g53.RRset) bool {
+	if len(rrset.Rdatas) != len(other.Rdatas) {
+		return false
+	}
+
+	for _, rdata := range other.Rdatas {
+		if rdataIndex(rrset, rdata) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// rfc2136 3.4.1
+func (z *Zone) prescan(updates []*g53.RRset) g53.Rcode {
+	for _, rrset := range updates {
+		if z.isInZone(rrset.Name) == false {
+			return g53.R_NOTZONE
+		}
+
+		switch rrset.Class {
+		case g53.CLASS_IN:
+			if isMetaType(rrset.Type) || len(rrset.Rdatas) == 0 {
+				return g53.R_FORMERR
+			}
+
+		case g53.CLASS_ANY:
+			if rrset.Ttl != 0 || len(rrset.Rdatas) != 0 {
+				return g53.R_FORMERR
+			}
+			if rrset.Type != g53.RR_ANY && isMetaType(rrset.Type) {
+				return g53.R_FORMERR
+			}
+
+		case g53.CLASS_NONE:
+			if rrset.Ttl != 0 || isMetaType(rrset.Type) || len(rrset.Rdatas) == 0 {
+				return g53.R_FORMERR
+			}
+
+		default:
+			return g53.R_FORMERR
+		}
+	}
+	return g53.R_NOERROR
+}
+
+// rfc2136 3.4.2
+func (t *updateTxn) apply(rrset *g53.RRset) {
+	isApex := rrset.Name.Equals(t.origin)
+	switch rrset.Class {
+	case g53.CLASS_IN:
+		t.add(rrset, isApex)
+
+	case g53.CLASS_ANY:
+		n := t.node(rrset.Name)
+		if n == nil {
+			return
+		}
+
+		if rrset.Type == g53.RR_ANY {
+			for typ := range n.rrsets {
+				if isApex == false || (typ != g53.RR_SOA && typ != g53.RR_NS) {
+					t.deleteRRset(n, typ)
+				}
+			}
+		} else if isApex == false || (rrset.Type != g53.RR_SOA && rrset.Type != g53.RR_NS) {
+			t.deleteRRset(n, rrset.Type)
+		}
+
+	case g53.CLASS_NONE:
+		if rrset.Type == g53.RR_SOA {
+			return
+		}
+
+		n := t.node(rrset.Name)
+		if n == nil {
+			return
+		}
+
+		current, ok := n.rrsets[rrset.Type]
+		if ok == false {
+			return
+		}
+
+		remain := copyRRset(current)
+		for _, rdata := range rrset.Rdatas {
+			if i := rdataIndex(remain, rdata); i != -1 {
+				//the last ns of the zone is never deleted
+				if isApex && rrset.Type == g53.RR_NS && len(remain.Rdatas) == 1 {
+					continue
+				}
+				remain.Rdatas = append(remain.Rdatas[0:i], remain.Rdatas[i+1:]...)
+			}
+		}
+
+		if len(remain.Rdatas) == 0 {
+			t.deleteRRset(n, rrset.Type)
+		} else {
+			n.rrsets[rrset.Type] = remain
+		}
+	}
+}
+
+func (t *updateTxn) add(rrset *g53.RRset, isApex bool) {
+	if rrset.Type == g53.RR_SOA && isApex == false {
+		return
+	}
+
+	if n := t.data[nameKey(rrset.Name)]; n != nil {
+		_, hasCName := n.rrsets[g53.RR_CNAME]
+		if rrset.Type == g53.RR_CNAME {
+			if hasCName == false {
+				return
+			}
+		} else if hasCName {
+			return
+		}
+	}
+
+	n := t.createNode(rrset.Name)
+
+	current, ok := n.rrsets[rrset.Type]
+	switch {
+	case rrset.Type == g53.RR_SOA:
+		if g53.SerialLess(soaSerial(current), soaSerial(rrset)) == false {
+			return
+		}
+		n.rrsets[g53.RR_SOA] = copyRRset(&g53.RRset{
+			Name:   current.Name,
+			Type:   g53.RR_SOA,
+			Class:  current.Class,
+			Ttl:    rrset.Ttl,
+			Rdatas: rrset.Rdatas[0:1],
+		})
+
+	case rrset.Type == g53.RR_CNAME || ok == false:
+		added := copyRRset(rrset)
+		if rrset.Type == g53.RR_CNAME {
+			added.Rdatas = added.Rdatas[len(added.Rdatas)-1:]
+		}
+		n.rrsets[rrset.Type] = added
+
+	default:
+		merged := copyRRset(current)
+		merged.Ttl = rrset.Ttl
+		for _, rdata := range rrset.Rdatas {
+			if rdataIndex(merged, rdata) == -1 {
+				merged.Rdatas = append(merged.Rdatas, rdata)
+			}
+		}
+		n.rrsets[rrset.Type] = merged
+	}
+}
+
+// makeDiff compares every rr except soa, a ttl change is taken as delete
+// and add of the rrset
+func makeDiff(origin *g53.Name, old, new zoneData) *xfr.Diff {
+	diff := &xfr.Diff{
+		OldSOA: old.get(origin, g53.RR_SOA),
+		NewSOA: new.get(origin, g53.RR_SOA),
+	}
+
+	for _, rrset := range old.rrsets(origin)[1:] {
+		if removed := missingRdatas(rrset, new.get(rrset.Name, rrset.Type)); removed != nil {
+			diff.Deleted = append(diff.Deleted, removed)
+		}
+	}
+
+	for _, rrset := range new.rrsets(origin)[1:] {
+		if added := missingRdatas(rrset, old.get(rrset.Name, rrset.Type)); added != nil {
+			diff.Added = append(diff.Added, added)
+		}
+	}
+
+	if len(diff.Deleted) == 0 && len(diff.Added) == 0 && diff.OldSOA == diff.NewSOA {
+		return nil
+	}
+	return diff
+}
+
+// rdatas in rrset which don't exist in other
+func missingRdatas(rrset, other *g53.RRset) *g53.RRset {
+	if other == rrset {
+		return nil
+	}
+
+	missing := &g53.RRset{
+		Name:  rrset.Name,
+		Type:  rrset.Type,
+		Class: rrset.Class,
+		Ttl:   rrset.Ttl,
+	}
+	for _, rdata := range rrset.Rdatas {
+		if other == nil || other.Ttl != rrset.Ttl || rdataIndex(other, rdata) == -1 {
+			missing.Rdatas = append(missing.Rdatas, rdata)
+		}
+	}
+
+	if len(missing.Rdatas) == 0 {
+		return nil
+	}
+	return missing
+}
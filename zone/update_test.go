@@ -0,0 +1,147 @@
+package zone
+
+import (
+	"testing"
+
+	"github.com/mistletoeChao/g53"
+)
+
+func mustName(s string) *g53.Name {
+	n, err := g53.NameFromString(s)
+	if err != nil {
+		panic(err.Error())
+	}
+	return n
+}
+
+func newTestZone(t *testing.T) *Zone {
+	z, err := NewZone(mustName("example.com."), buildZone())
+	g53.Assert(t, err == nil, "create zone failed:%v", err)
+	return z
+}
+
+func TestNewZone(t *testing.T) {
+	z := newTestZone(t)
+	g53.Equal(t, z.Serial(), uint32(1))
+	rrsets := z.RRsets()
+	g53.Equal(t, len(rrsets), 6)
+	g53.Equal(t, rrsets[0].Type, g53.RRType(g53.RR_SOA))
+
+	_, err := NewZone(mustName("example.com."), buildZone()[1:])
+	g53.Assert(t, err != nil, "zone without soa should be rejected")
+
+	_, err = NewZone(mustName("example.org."), buildZone())
+	g53.Assert(t, err != nil, "out of zone data should be rejected")
+}
+
+func TestUpdatePrerequisites(t *testing.T) {
+	z := newTestZone(t)
+	www := mustName("www.example.com.")
+	cases := []struct {
+		build func(b *g53.UpdateBuilder)
+		rcode g53.Rcode
+	}{
+		{func(b *g53.UpdateBuilder) { b.RRsetExists(www, g53.RR_AAAA) }, g53.R_NXRRSET},
+		{func(b *g53.UpdateBuilder) { b.RRsetExists(www, g53.RR_A) }, g53.R_NOERROR},
+		{func(b *g53.UpdateBuilder) { b.RRsetNotExists(www, g53.RR_A) }, g53.R_YXRRSET},
+		{func(b *g53.UpdateBuilder) { b.NameInUse(mustName("ftp.example.com.")) }, g53.R_NXDOMAIN},
+		{func(b *g53.UpdateBuilder) { b.NameNotInUse(www) }, g53.R_YXDOMAIN},
+		{func(b *g53.UpdateBuilder) { b.NameInUse(mustName("www.example.org.")) }, g53.R_NOTZONE},
+		{func(b *g53.UpdateBuilder) {
+			b.RRsetExistsValue(g53.BuildRRset("www.example.com.", g53.RR_A, 0, "2.2.2.2"))
+		}, g53.R_NOERROR},
+		{func(b *g53.UpdateBuilder) {
+			b.RRsetExistsValue(g53.BuildRRset("www.example.com.", g53.RR_A, 0, "2.2.2.2", "4.4.4.4"))
+		}, g53.R_NXRRSET},
+	}
+
+	for i, c := range cases {
+		b := g53.NewUpdateBuilder(mustName("example.com."))
+		c.build(b)
+		rcode, diff := z.Update(b.Message())
+		g53.Assert(t, rcode == c.rcode, "case %d expect %s but get %s", i, c.rcode.String(), rcode.String())
+		g53.Assert(t, diff == nil, "prerequisite only update shouldn't change zone")
+	}
+	g53.Equal(t, z.Serial(), uint32(1))
+
+	b := g53.NewUpdateBuilder(mustName("example.org."))
+	rcode, _ := z.Update(b.Message())
+	g53.Equal(t, rcode, g53.Rcode(g53.R_NOTAUTH))
+}
+
+func TestUpdateApply(t *testing.T) {
+	z := newTestZone(t)
+	www := mustName("www.example.com.")
+	old := z.Get(www, g53.RR_A)
+
+	b := g53.NewUpdateBuilder(mustName("example.com."))
+	b.RRsetExists(www, g53.RR_A)
+	b.Add(g53.BuildRRset("www.example.com.", g53.RR_A, 600, "4.4.4.4"))
+	b.Delete(g53.BuildRRset("www.example.com.", g53.RR_A, 0, "2.2.2.2"))
+	b.Add(g53.BuildRRset("ftp.example.com.", g53.RR_CNAME, 300, "www.example.com."))
+	b.DeleteName(mustName("mail.example.com."))
+	rcode, diff := z.Update(b.Message())
+	g53.Equal(t, rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, diff.FromSerial(), uint32(1))
+	g53.Equal(t, diff.ToSerial(), uint32(2))
+	g53.Equal(t, z.Serial(), uint32(2))
+
+	a := z.Get(www, g53.RR_A)
+	g53.Equal(t, len(a.Rdatas), 1)
+	g53.Equal(t, a.Rdatas[0].String(), "4.4.4.4")
+	g53.Equal(t, a.Ttl, g53.RRTTL(600))
+	g53.Equal(t, old.Rdatas[0].String(), "2.2.2.2")
+	g53.Assert(t, z.Get(mustName("mail.example.com."), g53.RR_A) == nil, "mail should be deleted")
+	g53.Assert(t, z.Get(mustName("ftp.example.com."), g53.RR_CNAME) != nil, "cname should be added")
+
+	//the diff brings the old version to the new one
+	applied, err := diff.Apply(buildZone())
+	g53.Assert(t, err == nil, "apply diff failed:%v", err)
+	newZone, _ := NewZone(mustName("example.com."), applied)
+	g53.Equal(t, len(newZone.RRsets()), len(z.RRsets()))
+
+	//cname conflicts, apex soa and ns deletion are ignored
+	b = g53.NewUpdateBuilder(mustName("example.com."))
+	b.Add(g53.BuildRRset("ftp.example.com.", g53.RR_A, 300, "5.5.5.5"))
+	b.Add(g53.BuildRRset("www.example.com.", g53.RR_CNAME, 300, "ftp.example.com."))
+	b.DeleteRRset(mustName("example.com."), g53.RR_NS)
+	b.DeleteName(mustName("example.com."))
+	rcode, diff = z.Update(b.Message())
+	g53.Equal(t, rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Assert(t, diff != nil, "mx at apex is deleted")
+	g53.Equal(t, len(diff.Deleted), 1)
+	g53.Equal(t, diff.Deleted[0].Type, g53.RRType(g53.RR_MX))
+	g53.Equal(t, len(diff.Added), 0)
+	g53.Assert(t, z.Get(mustName("example.com."), g53.RR_NS) != nil, "apex ns should be kept")
+
+	b = g53.NewUpdateBuilder(mustName("example.com."))
+	b.Delete(g53.BuildRRset("example.com.", g53.RR_NS, 0, "ns1.example.com.", "ns.other.org."))
+	rcode, _ = z.Update(b.Message())
+	g53.Equal(t, rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, len(z.Get(mustName("example.com."), g53.RR_NS).Rdatas), 1)
+}
+
+func TestUpdateAtomic(t *testing.T) {
+	z := newTestZone(t)
+	b := g53.NewUpdateBuilder(mustName("example.com."))
+	b.Add(g53.BuildRRset("new.example.com.", g53.RR_A, 300, "6.6.6.6"))
+	b.Add(g53.BuildRRset("new.example.org.", g53.RR_A, 300, "6.6.6.6"))
+	rcode, diff := z.Update(b.Message())
+	g53.Equal(t, rcode, g53.Rcode(g53.R_NOTZONE))
+	g53.Assert(t, diff == nil && z.Get(mustName("new.example.com."), g53.RR_A) == nil, "nothing should be applied")
+
+	b = g53.NewUpdateBuilder(mustName("example.com."))
+	b.Add(g53.BuildRRset("new.example.com.", g53.RR_A, 300, "6.6.6.6"))
+	b.DeleteRRset(mustName("new.example.com."), g53.RR_AXFR)
+	rcode, _ = z.Update(b.Message())
+	g53.Equal(t, rcode, g53.Rcode(g53.R_FORMERR))
+	g53.Equal(t, z.Serial(), uint32(1))
+
+	//soa with larger serial in update replaces the current one
+	b = g53.NewUpdateBuilder(mustName("example.com."))
+	b.Add(g53.BuildRRset("example.com.", g53.RR_SOA, 3600, "ns1.example.com. root.example.com. 100 3600 900 86400"))
+	rcode, diff = z.Update(b.Message())
+	g53.Equal(t, rcode, g53.Rcode(g53.R_NOERROR))
+	g53.Equal(t, diff.ToSerial(), uint32(100))
+	g53.Equal(t, z.Serial(), uint32(100))
+}
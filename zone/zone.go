@@ -0,0 +1,177 @@
+package zone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mistletoeChao/g53"
+)
+
+// all the rrsets owned by one name, rrsets are never modified in place so
+// the ones handed out stay valid after the zone is updated
+type node struct {
+	name   *g53.Name
+	rrsets map[g53.RRType]*g53.RRset
+}
+
+func (n *node) clone() *node {
+	rrsets := make(map[g53.RRType]*g53.RRset, len(n.rrsets))
+	for typ, rrset := range n.rrsets {
+		rrsets[typ] = rrset
+	}
+	return &node{
+		name:   n.name,
+		rrsets: rrsets,
+	}
+}
+
+type zoneData map[string]*node
+
+func (d zoneData) clone() zoneData {
+	data := make(zoneData, len(d))
+	for key, n := range d {
+		data[key] = n
+	}
+	return data
+}
+
+func (d zoneData) get(name *g53.Name, typ g53.RRType) *g53.RRset {
+	if n, ok := d[nameKey(name)]; ok {
+		return n.rrsets[typ]
+	}
+	return nil
+}
+
+// Zone is an in memory authoritative zone which is safe for concurrent use
+type Zone struct {
+	origin *g53.Name
+	lock   sync.RWMutex
+	data   zoneData
+}
+
+// NewZone builds zone from rrsets, rrsets with same name and type are merged
+// and the zone must have soa at origin
+func NewZone(origin *g53.Name, rrsets []*g53.RRset) (*Zone, error) {
+	z := &Zone{
+		origin: origin,
+		data:   make(zoneData),
+	}
+
+	for _, rrset := range rrsets {
+		if z.isInZone(rrset.Name) == false {
+			return nil, fmt.Errorf("%s is out of zone %s", rrset.Name.String(false), origin.String(false))
+		}
+
+		key := nameKey(rrset.Name)
+		n, ok := z.data[key]
+		if ok == false {
+			n = &node{
+				name:   rrset.Name,
+				rrsets: make(map[g53.RRType]*g53.RRset),
+			}
+			z.data[key] = n
+		}
+
+		if old, ok := n.rrsets[rrset.Type]; ok {
+			merged := copyRRset(old)
+			for _, rdata := range rrset.Rdatas {
+				if rdataIndex(merged, rdata) == -1 {
+					merged.Rdatas = append(merged.Rdatas, rdata)
+				}
+			}
+			n.rrsets[rrset.Type] = merged
+		} else {
+			n.rrsets[rrset.Type] = copyRRset(rrset)
+		}
+	}
+
+	soa := z.data.get(origin, g53.RR_SOA)
+	if soa == nil || len(soa.Rdatas) != 1 {
+		return nil, errors.New("zone should have exactly one soa at origin")
+	}
+	return z, nil
+}
+
+func copyRRset(rrset *g53.RRset) *g53.RRset {
+	return &g53.RRset{
+		Name:   rrset.Name,
+		Type:   rrset.Type,
+		Class:  rrset.Class,
+		Ttl:    rrset.Ttl,
+		Rdatas: append([]g53.Rdata{}, rrset.Rdatas...),
+	}
+}
+
+func rdataIndex(rrset *g53.RRset, rdata g53.Rdata) int {
+	wire := rdataWire(rdata)
+	for i, r := range rrset.Rdatas {
+		if bytes.Equal(rdataWire(r), wire) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (z *Zone) Origin() *g53.Name {
+	return z.origin
+}
+
+func (z *Zone) isInZone(name *g53.Name) bool {
+	relation := name.Compare(z.origin, false).Relation
+	return relation == g53.SUBDOMAIN || relation == g53.EQUAL
+}
+
+func (z *Zone) Get(name *g53.Name, typ g53.RRType) *g53.RRset {
+	z.lock.RLock()
+	defer z.lock.RUnlock()
+	return z.data.get(name, typ)
+}
+
+func (z *Zone) SOA() *g53.RRset {
+	return z.Get(z.origin, g53.RR_SOA)
+}
+
+func (z *Zone) Serial() uint32 {
+	return soaSerial(z.SOA())
+}
+
+func soaSerial(rrset *g53.RRset) uint32 {
+	return rrset.Rdatas[0].(*g53.SOA).Serial
+}
+
+// RRsets returns all the rrsets with soa first and the others sorted by
+// name and type
+func (z *Zone) RRsets() []*g53.RRset {
+	z.lock.RLock()
+	data := z.data
+	z.lock.RUnlock()
+	return data.rrsets(z.origin)
+}
+
+func (d zoneData) rrsets(origin *g53.Name) []*g53.RRset {
+	keys := make([]string, 0, len(d))
+	for key := range d {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rrsets := []*g53.RRset{d.get(origin, g53.RR_SOA)}
+	for _, key := range keys {
+		n := d[key]
+		types := make([]int, 0, len(n.rrsets))
+		for typ := range n.rrsets {
+			types = append(types, int(typ))
+		}
+		sort.Ints(types)
+		for _, typ := range types {
+			rrset := n.rrsets[g53.RRType(typ)]
+			if rrset.Type != g53.RR_SOA || n.name.Equals(origin) == false {
+				rrsets = append(rrsets, rrset)
+			}
+		}
+	}
+	return rrsets
+}